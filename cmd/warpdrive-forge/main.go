@@ -2,74 +2,396 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
+	"time"
 
 	"warpdrive-forge/internal/config"
 	"warpdrive-forge/internal/dataset"
+	"warpdrive-forge/internal/logging"
+	"warpdrive-forge/internal/metrics"
+	"warpdrive-forge/internal/model"
 	"warpdrive-forge/internal/trainer"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "manifest" {
+		runManifest(os.Args[2:])
+		return
+	}
 	cfgPath := flag.String("config", "configs/demo.yaml", "Path to YAML config")
-	trainRootA := flag.String("train-root-a", "", "Override training root A")
-	trainRootB := flag.String("train-root-b", "", "Override training root B")
+	var rootFlags rootList
+	flag.Var(&rootFlags, "root", "Training root as name=path (repeatable; overrides a configured root of the same name)")
+	var valRootFlags rootList
+	flag.Var(&valRootFlags, "val-root", "Validation root as name=path (repeatable; overrides a configured validation root of the same name)")
+	evalEvery := flag.Int("eval-every", 0, "Evaluate on the validation roots every N steps")
 	steps := flag.Int("steps", 0, "Number of training steps")
+	epochs := flag.Int("epochs", 0, "Number of passes over the training shards (replaces the configured steps unless -steps is also set)")
 	batchSize := flag.Int("batch-size", 0, "Batch size")
 	numWorkers := flag.Int("num-workers", 0, "Number of data loader workers")
+	replicas := flag.Int("replicas", 0, "Train each batch data-parallel across N goroutines (0 trains serially)")
+	optimizer := flag.String("optimizer", "", "Optimizer for batch gradients: sgd, adam or adamw")
+	lr := flag.Float64("lr", 0, "Base learning rate (default 0.05, or 0.001 for adam/adamw)")
+	lrSchedule := flag.String("lr-schedule", "", "Learning-rate schedule: constant, step, cosine or one_cycle")
 	seed := flag.Int64("seed", 0, "PRNG seed")
+	preprocess := flag.String("preprocess", "", "Sample preprocessing: raw (byte sampling) or decode (JPEG/PNG pixels)")
+	decodeWorkers := flag.Int("decode-workers", 0, "Goroutines preprocessing each batch (default one per CPU)")
+	prefetch := flag.Int("prefetch", 0, "Ready batches queued ahead of the training loop (default 2)")
+	shuffleBuffer := flag.Int("shuffle-buffer", 0, "Shuffle samples across shards through a rolling buffer of N samples")
+	ordering := flag.String("ordering", "", "Sample order across shards: strict or relaxed")
+	reorderWindow := flag.Int("reorder-window", 0, "With relaxed ordering, read at most N shards ahead of the oldest unfinished one")
+	rank := flag.Int("rank", -1, "This process's rank among -world-size processes (default $RANK)")
+	worldSize := flag.Int("world-size", -1, "Number of processes splitting the shards (default $WORLD_SIZE)")
+	partition := flag.String("partition", "", "Uneven shard split across ranks: pad, drop or wrap")
 	logEvery := flag.Int("log-every", 0, "Log every N steps")
+	checkpointDir := flag.String("checkpoint-dir", "", "Directory for training checkpoints")
+	checkpointEvery := flag.Int("checkpoint-every", 0, "Checkpoint every N steps")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics at this address (e.g. :9091)")
+	resume := flag.Bool("resume", false, "Resume from the newest valid checkpoint in the checkpoint directory")
+	errorPolicy := flag.String("error-policy", "", "Shard error handling: fail, skip_shard or skip_sample")
+	quarantineFile := flag.String("quarantine-file", "", "File listing shards skipped after an error; they are skipped on later runs")
+	verifyChecksums := flag.Bool("verify-checksums", false, "Verify shards against their manifest or .sha256 checksums while reading")
+	checksumMismatch := flag.String("checksum-mismatch", "", "Action on a checksum mismatch: fail, skip or reread")
+	logFormat := flag.String("log-format", "", "Log encoding: text or json")
 
 	flag.Parse()
 
+	if *logFormat != "" {
+		format, err := logging.ParseFormat(*logFormat)
+		if err != nil {
+			logging.Fatal("invalid_flags", err)
+		}
+		logging.SetFormat(format)
+	}
+
 	cfg, err := config.Load(*cfgPath)
 	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
+		logging.Fatal("config_error", err, logging.String("path", *cfgPath))
+	}
+
+	// Launcher environment beats the config file; flags beat both.
+	envOverrides, err := config.EnvOverrides(os.LookupEnv)
+	if err != nil {
+		logging.Fatal("config_error", err)
+	}
+	cfg.ApplyOverrides(envOverrides)
+	var rankOverride, worldSizeOverride *int
+	if *rank >= 0 {
+		rankOverride = rank
+	}
+	if *worldSize >= 0 {
+		worldSizeOverride = worldSize
 	}
 
 	cfg.ApplyOverrides(config.Overrides{
-		TrainRootA: *trainRootA,
-		TrainRootB: *trainRootB,
+		Roots:      rootFlags,
 		Steps:      *steps,
+		Epochs:     *epochs,
 		BatchSize:  *batchSize,
 		NumWorkers: *numWorkers,
+		Replicas:   *replicas,
+		Optimizer:  *optimizer,
+		LR:         *lr,
+		LRSchedule: *lrSchedule,
 		Seed:       *seed,
 		LogEvery:   *logEvery,
+
+		ShuffleBuffer: *shuffleBuffer,
+		Ordering:      *ordering,
+		ReorderWindow: *reorderWindow,
+
+		Preprocess:    *preprocess,
+		DecodeWorkers: *decodeWorkers,
+		Prefetch:      *prefetch,
+
+		Rank:      rankOverride,
+		WorldSize: worldSizeOverride,
+		Partition: *partition,
+
+		CheckpointDir:   *checkpointDir,
+		CheckpointEvery: *checkpointEvery,
+		MetricsAddr:     *metricsAddr,
+		LogFormat:       *logFormat,
+
+		ValidationRoots: valRootFlags,
+		EvalEvery:       *evalEvery,
+
+		ErrorPolicy:    *errorPolicy,
+		QuarantineFile: *quarantineFile,
+
+		VerifyChecksums:  *verifyChecksums,
+		ChecksumMismatch: *checksumMismatch,
 	})
 
 	if err := cfg.Validate(); err != nil {
-		log.Fatalf("invalid config: %v", err)
+		logging.Fatal("config_error", err, logging.String("path", *cfgPath))
+	}
+	modes, err := cfg.Modes()
+	if err != nil {
+		logging.Fatal("config_error", err, logging.String("path", *cfgPath))
 	}
+	logging.SetFormat(modes.LogFormat)
+	logging.Info("run_start", logging.Any("config", cfg))
 
-	roots := map[string][]string{}
-	for _, root := range []string{cfg.TrainRootA, cfg.TrainRootB} {
-		shards, err := dataset.DiscoverShards(root)
+	if cfg.WorldSize > 1 && cfg.CheckpointDir != "" {
+		// Ranks read different shards, so each keeps its own checkpoints.
+		cfg.CheckpointDir = filepath.Join(cfg.CheckpointDir, fmt.Sprintf("rank-%d", cfg.Rank))
+	}
+	var quarantine *dataset.Quarantine
+	if cfg.QuarantineFile != "" {
+		quarantine, err = dataset.LoadQuarantine(cfg.QuarantineFile)
 		if err != nil {
-			log.Fatalf("discover shards under %s: %v", root, err)
+			logging.Fatal("quarantine_error", err, logging.String("path", cfg.QuarantineFile))
+		}
+		logging.Info("quarantine", logging.String("path", cfg.QuarantineFile), logging.Int("shards", quarantine.Len()))
+	}
+
+	weights := cfg.RootWeights()
+	roots, shardSamples, checksums := discoverRoots(cfg.Roots, weights, "root")
+	if len(roots) == 0 {
+		logging.Fatal("root_error", errors.New("no shards discovered under any root"))
+	}
+	// Quarantined training shards stay in the list so the sampler's order,
+	// and any checkpoint taken against it, is unchanged; the sampler skips
+	// them when it reaches them.
+	for _, name := range sortedNames(roots) {
+		if skipped := len(roots[name]) - len(quarantine.Filter(roots[name])); skipped > 0 {
+			logging.Info("root_quarantined", logging.String("root", name), logging.Int("shards", skipped))
 		}
-		if len(shards) == 0 {
-			log.Fatalf("no shards discovered under %s", root)
+	}
+	valRoots, _, _ := discoverRoots(cfg.ValidationRoots, nil, "validation_root")
+	for name, shards := range valRoots {
+		if kept := quarantine.Filter(shards); len(kept) > 0 {
+			valRoots[name] = kept
+		} else {
+			delete(valRoots, name)
 		}
-		roots[root] = shards
-		log.Printf("root=%s shards=%d", root, len(shards))
+	}
+
+	var verify *dataset.Verifier
+	if cfg.VerifyChecksums {
+		verify = &dataset.Verifier{Checksums: checksums, OnMismatch: modes.ChecksumMismatch, Rereads: cfg.ChecksumRereads}
+		logging.Info("verify_checksums", logging.Int("manifest_checksums", len(checksums)), logging.String("on_mismatch", cfg.ChecksumMismatch))
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	for name := range weights {
+		if _, ok := roots[name]; !ok {
+			delete(weights, name)
+		}
+	}
+
+	var resumeFrom *trainer.Checkpoint
+	if *resume {
+		if cfg.CheckpointDir == "" {
+			logging.Fatal("config_error", errors.New("-resume requires checkpoint_dir or -checkpoint-dir"))
+		}
+		resumeFrom, err = trainer.LoadLatestCheckpoint(cfg.CheckpointDir)
+		if err != nil {
+			logging.Fatal("checkpoint_error", err, logging.String("dir", cfg.CheckpointDir))
+		}
+		if resumeFrom == nil {
+			logging.Info("checkpoint_missing", logging.String("dir", cfg.CheckpointDir))
+		}
+	}
+
+	layers := make([]model.LayerSpec, 0, len(cfg.Model.Layers))
+	for _, layer := range cfg.Model.Layers {
+		layers = append(layers, model.LayerSpec{
+			Type:    layer.Type,
+			Units:   layer.Units,
+			Filters: layer.Filters,
+			Kernel:  layer.Kernel,
+			Padding: layer.Padding,
+			Size:    layer.Size,
+			Stride:  layer.Stride,
+		})
+	}
+
+	prep := trainer.PreprocessConfig{
+		Mode:       modes.Preprocess,
+		Workers:    cfg.Preprocess.Workers,
+		Prefetch:   cfg.Preprocess.Prefetch,
+		CropScale:  cfg.Preprocess.CropScale,
+		Flip:       cfg.Preprocess.Flip,
+		Brightness: cfg.Preprocess.Brightness,
+	}
+	copy(prep.Mean[:], cfg.Preprocess.Mean)
+	copy(prep.Std[:], cfg.Preprocess.Std)
+
+	var registry *metrics.Registry
+	if cfg.MetricsAddr != "" {
+		registry = metrics.NewRegistry()
+		shutdown := serveMetrics(cfg.MetricsAddr, registry)
+		defer shutdown()
+	}
+
 	runCfg := trainer.RunConfig{
-		Roots:      roots,
-		Steps:      cfg.Steps,
-		BatchSize:  cfg.BatchSize,
-		NumWorkers: cfg.NumWorkers,
-		LogEvery:   cfg.LogEvery,
-		Seed:       cfg.Seed,
+		Roots:        roots,
+		Weights:      weights,
+		ShardSamples: shardSamples,
+		Steps:        cfg.Steps,
+		Epochs:       cfg.Epochs,
+		BatchSize:    cfg.BatchSize,
+		NumWorkers:   cfg.NumWorkers,
+		Replicas:     cfg.Replicas,
+		LogEvery:     cfg.LogEvery,
+		Seed:         cfg.Seed,
+
+		Optimizer: cfg.Optimizer,
+		Schedule: model.ScheduleConfig{
+			Name:           cfg.LRSchedule.Name,
+			WarmupSteps:    cfg.LRSchedule.WarmupSteps,
+			TotalSteps:     cfg.LRSchedule.TotalSteps,
+			StepSize:       cfg.LRSchedule.StepSize,
+			Gamma:          cfg.LRSchedule.Gamma,
+			MinLR:          cfg.LRSchedule.MinLR,
+			PctStart:       cfg.LRSchedule.PctStart,
+			DivFactor:      cfg.LRSchedule.DivFactor,
+			FinalDivFactor: cfg.LRSchedule.FinalDivFactor,
+		},
+		Layers:     layers,
+		Preprocess: prep,
+
+		ShuffleSamples: cfg.ShuffleBuffer,
+		ShuffleBytes:   cfg.ShuffleBufferBytes,
+		Ordering:       modes.Ordering,
+		ReorderWindow:  cfg.ReorderWindow,
+		Rank:           cfg.Rank,
+		WorldSize:      cfg.WorldSize,
+		Partition:      modes.Partition,
+		Resume:         resumeFrom,
+
+		CheckpointDir:   cfg.CheckpointDir,
+		CheckpointEvery: cfg.CheckpointEvery,
+		KeepCheckpoints: cfg.CheckpointKeep,
+		Metrics:         registry,
+
+		ValidationRoots: valRoots,
+		EvalEvery:       cfg.EvalEvery,
+		EvalMaxSamples:  cfg.EvalMaxSamples,
+
+		ErrorPolicy: modes.ErrorPolicy,
+		ErrorBudget: cfg.ErrorBudget,
+		Quarantine:  quarantine,
+		Verify:      verify,
 	}
 
 	if err := trainer.Run(ctx, runCfg); err != nil {
-		log.Fatalf("training failed: %v", err)
+		if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+			// A signal stopped the run and its exit checkpoint was saved.
+			logging.Info("run_interrupted")
+			return
+		}
+		logging.Fatal("run_failed", err)
+	}
+	logging.Info("run_complete")
+}
+
+// discoverRoots lists the shards of each root, logging one event per root,
+// and returns the sample counts and checksums its manifests list. Optional
+// roots without shards are skipped; any other failure is fatal.
+func discoverRoots(configured []config.RootConfig, weights map[string]float64, event string) (map[string][]string, map[string]int64, map[string]dataset.Checksum) {
+	roots := map[string][]string{}
+	counts := map[string]int64{}
+	sums := map[string]dataset.Checksum{}
+	for _, root := range configured {
+		rootFields := []logging.Field{logging.String("root", root.Name), logging.String("path", root.Path)}
+		filter, err := dataset.ParseShardFilter(root.Patterns, root.Include, root.Exclude)
+		if err != nil {
+			logging.Fatal(event+"_error", err, rootFields...)
+		}
+		shards, manifest, err := dataset.DiscoverRoot(root.Path, root.Manifest, filter)
+		if err == nil && len(shards) == 0 {
+			err = errors.New("no shards discovered")
+		}
+		if err != nil {
+			if root.Optional {
+				logging.Error(event+"_skipped", err, rootFields...)
+				continue
+			}
+			logging.Fatal(event+"_error", err, rootFields...)
+		}
+		roots[root.Name] = shards
+		rootFields = append(rootFields, logging.Int("shards", len(shards)))
+		if manifest != nil {
+			rootCounts := manifest.SampleCounts(root.Path)
+			var samples int64
+			for shard, n := range rootCounts {
+				counts[shard] = n
+				samples += n
+			}
+			for shard, sum := range manifest.Checksums(root.Path) {
+				sums[shard] = sum
+			}
+			path := root.Manifest
+			if path == "" {
+				path = filepath.Join(root.Path, dataset.ManifestName)
+			}
+			rootFields = append(rootFields, logging.String("manifest", path), logging.Int64("samples", samples))
+		}
+		if weights != nil {
+			rootFields = append(rootFields, logging.Float("weight", weights[root.Name], -1))
+		}
+		logging.Info(event, rootFields...)
+	}
+	return roots, counts, sums
+}
+
+func sortedNames(roots map[string][]string) []string {
+	names := make([]string, 0, len(roots))
+	for name := range roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// serveMetrics exposes registry at addr/metrics and returns a function that
+// stops the server.
+func serveMetrics(addr string, registry *metrics.Registry) func() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Error("metrics_error", err, logging.String("addr", addr))
+		}
+	}()
+	logging.Info("metrics_listen", logging.String("addr", addr), logging.String("path", "/metrics"))
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}
+}
+
+// rootList collects repeated -root name=path flags.
+type rootList []config.RootConfig
+
+func (r *rootList) String() string {
+	parts := make([]string, 0, len(*r))
+	for _, root := range *r {
+		parts = append(parts, root.Name+"="+root.Path)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *rootList) Set(value string) error {
+	root, err := config.ParseRootFlag(value)
+	if err != nil {
+		return err
 	}
+	*r = append(*r, root)
+	return nil
 }
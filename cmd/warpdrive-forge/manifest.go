@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"path/filepath"
+	"strings"
+
+	"warpdrive-forge/internal/dataset"
+	"warpdrive-forge/internal/logging"
+)
+
+// runManifest implements "warpdrive-forge manifest": it describes every
+// shard under a root and writes the manifest that training reads instead of
+// walking the root.
+func runManifest(args []string) {
+	flags := flag.NewFlagSet("manifest", flag.ExitOnError)
+	root := flags.String("root", "", "Root directory whose shards to describe")
+	out := flags.String("out", "", "Manifest path; .json writes one JSON document, anything else JSON Lines (default <root>/manifest.jsonl)")
+	index := flags.Bool("index", false, "Also write each uncompressed shard's .idx index sidecar")
+	var patterns, include, exclude stringList
+	flags.Var(&patterns, "pattern", "Shard file name glob or re:regexp (repeatable; default shard-NNNNNN.tar)")
+	flags.Var(&include, "include", "Only describe shards whose path or a parent directory matches (repeatable)")
+	flags.Var(&exclude, "exclude", "Skip shards whose path or a parent directory matches (repeatable)")
+	logFormat := flags.String("log-format", "", "Log encoding: text or json")
+	flags.Parse(args)
+
+	format, err := logging.ParseFormat(*logFormat)
+	if err != nil {
+		logging.Fatal("invalid_flags", err)
+	}
+	logging.SetFormat(format)
+	if *root == "" {
+		logging.Fatal("invalid_flags", errors.New("manifest requires -root"))
+	}
+	if *out == "" {
+		*out = filepath.Join(*root, dataset.ManifestName)
+	}
+	filter, err := dataset.ParseShardFilter(patterns, include, exclude)
+	if err != nil {
+		logging.Fatal("invalid_flags", err)
+	}
+
+	var samples, bytes int64
+	manifest, err := dataset.BuildManifest(*root, filter, *index, func(entry dataset.ManifestEntry) {
+		samples += entry.Samples
+		bytes += entry.Size
+		logging.Info("manifest_shard",
+			logging.String("path", entry.Path),
+			logging.Int64("samples", entry.Samples),
+			logging.Int64("bytes", entry.Size),
+		)
+	})
+	if err != nil {
+		logging.Fatal("manifest_error", err, logging.String("root", *root))
+	}
+	if len(manifest.Shards) == 0 {
+		logging.Fatal("manifest_error", errors.New("no shards discovered"), logging.String("root", *root))
+	}
+	if err := dataset.WriteManifest(*out, manifest); err != nil {
+		logging.Fatal("manifest_error", err, logging.String("path", *out))
+	}
+	logging.Info("manifest_written",
+		logging.String("path", *out),
+		logging.Int("shards", len(manifest.Shards)),
+		logging.Int64("samples", samples),
+		logging.Int64("bytes", bytes),
+	)
+}
+
+// stringList collects the values of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -17,8 +17,8 @@ fi
 echo "=== Cold-cache training ==="
 "$BIN_PATH" \
   -config "$REPO_DIR/configs/demo.yaml" \
-  -train-root-a /wd/datasets-cac/train \
-  -train-root-b /wd/datasets-wus3/train \
+  -root cac=/wd/datasets-cac/train \
+  -root wus3=/wd/datasets-wus3/train \
   -steps 200 \
   -batch-size 16 \
   -num-workers 4 \
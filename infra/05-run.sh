@@ -99,8 +99,8 @@ extract_data_ms() { grep 'data_ms'        "$1" | tail -1 | sed 's/.*data_ms=\([^
 run_training() {
   "$FORGE_BIN" \
     -config "$FORGE_DIR/configs/demo.yaml" \
-    -train-root-a "$WD_MOUNT_POINT/datasets-cac/train" \
-    -train-root-b "$WD_MOUNT_POINT/datasets-wus3/train" \
+    -root "cac=$WD_MOUNT_POINT/datasets-cac/train" \
+    -root "wus3=$WD_MOUNT_POINT/datasets-wus3/train" \
     -steps "$TRAIN_STEPS" \
     -batch-size "$TRAIN_BATCH" \
     -num-workers "$TRAIN_WORKERS" \
@@ -1,38 +1,226 @@
 package config
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"io"
 	"os"
-	"strconv"
 	"strings"
+
+	"warpdrive-forge/internal/dataset"
+	"warpdrive-forge/internal/logging"
+	"warpdrive-forge/internal/model"
+	"warpdrive-forge/internal/trainer"
 )
 
 // Config captures the runtime knobs for a training run.
 type Config struct {
-	TrainRootA string `yaml:"train_root_a"`
-	TrainRootB string `yaml:"train_root_b"`
-	Steps      int    `yaml:"steps"`
-	BatchSize  int    `yaml:"batch_size"`
-	NumWorkers int    `yaml:"num_workers"`
-	Seed       int64  `yaml:"seed"`
-	LogEvery   int    `yaml:"log_every"`
+	Roots []RootConfig `yaml:"roots" json:"roots"`
+	Steps int          `yaml:"steps" json:"steps"`
+	// Epochs, when set, ends the run after that many passes over the
+	// training shards (or at Steps, whichever comes first).
+	Epochs     int   `yaml:"epochs" json:"epochs"`
+	BatchSize  int   `yaml:"batch_size" json:"batch_size"`
+	NumWorkers int   `yaml:"num_workers" json:"num_workers"`
+	Seed       int64 `yaml:"seed" json:"seed"`
+	LogEvery   int   `yaml:"log_every" json:"log_every"`
+	// Replicas, when > 0, splits every batch across that many goroutines
+	// and applies their averaged gradients once per batch.
+	Replicas int `yaml:"replicas" json:"replicas"`
+	// Optimizer, when its name is set, trains on averaged batch gradients
+	// instead of the model's built-in per-sample SGD. Its lr is the base
+	// learning rate in both cases.
+	Optimizer model.OptimizerConfig `yaml:"optimizer" json:"optimizer"`
+	// LRSchedule scales the learning rate by training step.
+	LRSchedule ScheduleConfig `yaml:"lr_schedule" json:"lr_schedule"`
+	// Model describes the network trained on the feature grid.
+	Model ModelConfig `yaml:"model" json:"model"`
+	// Preprocess turns sample bytes into the feature grid.
+	Preprocess PreprocessConfig `yaml:"preprocess" json:"preprocess"`
+	// ShuffleBuffer and ShuffleBufferBytes size the sampler's cross-shard
+	// shuffle buffer in samples and bytes; both 0 disables it.
+	ShuffleBuffer      int   `yaml:"shuffle_buffer" json:"shuffle_buffer"`
+	ShuffleBufferBytes int64 `yaml:"shuffle_buffer_bytes" json:"shuffle_buffer_bytes"`
+	// Ordering is "strict" (default) or "relaxed". Relaxed ordering emits
+	// samples from whichever open shard is ready, reading at most
+	// ReorderWindow shards ahead of the oldest unfinished one (0 means no
+	// limit beyond the workers).
+	Ordering      string `yaml:"ordering" json:"ordering"`
+	ReorderWindow int    `yaml:"reorder_window" json:"reorder_window"`
+	// Rank and WorldSize split each epoch's shards across WorldSize
+	// processes; Partition is "pad" (default), "drop" or "wrap" and decides
+	// what happens to shards that do not divide evenly.
+	Rank      int    `yaml:"rank" json:"rank"`
+	WorldSize int    `yaml:"world_size" json:"world_size"`
+	Partition string `yaml:"partition" json:"partition"`
+	// CheckpointDir enables checkpointing every CheckpointEvery steps,
+	// keeping the newest CheckpointKeep files.
+	CheckpointDir   string `yaml:"checkpoint_dir" json:"checkpoint_dir"`
+	CheckpointEvery int    `yaml:"checkpoint_every" json:"checkpoint_every"`
+	CheckpointKeep  int    `yaml:"checkpoint_keep" json:"checkpoint_keep"`
+	// MetricsAddr, when set, serves Prometheus metrics at /metrics.
+	MetricsAddr string `yaml:"metrics_addr" json:"metrics_addr"`
+	// LogFormat is "text" (default) or "json".
+	LogFormat string `yaml:"log_format" json:"log_format"`
+	// ValidationRoots are held-out roots scored every EvalEvery steps, each
+	// pass reading at most EvalMaxSamples samples (0 reads them all).
+	ValidationRoots []RootConfig `yaml:"validation_roots" json:"validation_roots"`
+	EvalEvery       int          `yaml:"eval_every" json:"eval_every"`
+	EvalMaxSamples  int          `yaml:"eval_max_samples" json:"eval_max_samples"`
+	// ErrorPolicy is "fail" (default), "skip_shard" or "skip_sample".
+	// ErrorBudget caps the skipped errors per run (0 means no limit), and
+	// shards the policy skips are appended to QuarantineFile and not read
+	// again.
+	ErrorPolicy    string `yaml:"error_policy" json:"error_policy"`
+	ErrorBudget    int    `yaml:"error_budget" json:"error_budget"`
+	QuarantineFile string `yaml:"quarantine_file" json:"quarantine_file"`
+	// VerifyChecksums checks every shard with a checksum, from its root's
+	// manifest or a .sha256 sidecar, as it is read. ChecksumMismatch is
+	// "fail" (default), "skip" or "reread", and ChecksumRereads caps the
+	// extra reads of a mismatching shard (default 2).
+	VerifyChecksums  bool   `yaml:"verify_checksums" json:"verify_checksums"`
+	ChecksumMismatch string `yaml:"checksum_mismatch" json:"checksum_mismatch"`
+	ChecksumRereads  int    `yaml:"checksum_rereads" json:"checksum_rereads"`
+}
+
+// RootConfig describes one named training root, typically a WarpDrive
+// backend mounted under /wd.
+type RootConfig struct {
+	Name string `yaml:"name" json:"name"`
+	Path string `yaml:"path" json:"path"`
+	// Optional roots that yield no shards are skipped instead of failing
+	// the run.
+	Optional bool `yaml:"optional" json:"optional"`
+	// Manifest lists the root's shards instead of walking Path. When unset,
+	// a manifest.jsonl directly under Path is used if there is one.
+	Manifest string `yaml:"manifest" json:"manifest,omitempty"`
+	// Patterns name the root's shard files as globs, with WebDataset brace
+	// ranges, or "re:" regular expressions; when unset, files named
+	// shard-NNNNNN.tar (optionally .gz or .zst) are shards. Include and
+	// Exclude filter shards by their path or parent directories.
+	Patterns []string `yaml:"patterns" json:"patterns,omitempty"`
+	Include  []string `yaml:"include" json:"include,omitempty"`
+	Exclude  []string `yaml:"exclude" json:"exclude,omitempty"`
+	// Weight is the relative share of shards drawn from this root. When no
+	// root sets a weight the sampler alternates roots round robin; otherwise
+	// roots without a weight default to 1. Validation roots ignore it.
+	Weight float64 `yaml:"weight" json:"weight"`
+}
+
+// ScheduleConfig selects the learning-rate schedule: "constant" (default),
+// "step", "cosine" or "one_cycle". total_steps defaults to steps, or for a
+// run bounded by epochs alone to the steps the trainer derives from the
+// manifest sample counts.
+type ScheduleConfig struct {
+	Name           string  `yaml:"name" json:"name"`
+	WarmupSteps    int     `yaml:"warmup_steps" json:"warmup_steps"`
+	TotalSteps     int     `yaml:"total_steps" json:"total_steps"`
+	StepSize       int     `yaml:"step_size" json:"step_size"`
+	Gamma          float64 `yaml:"gamma" json:"gamma"`
+	MinLR          float64 `yaml:"min_lr" json:"min_lr"`
+	PctStart       float64 `yaml:"pct_start" json:"pct_start"`
+	DivFactor      float64 `yaml:"div_factor" json:"div_factor"`
+	FinalDivFactor float64 `yaml:"final_div_factor" json:"final_div_factor"`
+}
+
+// ModelConfig lists the layers of the network, in order. A dense layer to
+// the class count is always appended, so no layers trains the linear
+// classifier.
+type ModelConfig struct {
+	Layers []LayerConfig `yaml:"layers" json:"layers"`
+}
+
+// LayerConfig is one layer: "dense" takes units; "conv2d" takes filters,
+// kernel, stride (default 1) and padding; "max_pool" and "avg_pool" take
+// size (default 2) and stride (default size); "relu" and "flatten" take
+// nothing.
+type LayerConfig struct {
+	Type    string `yaml:"type" json:"type"`
+	Units   int    `yaml:"units" json:"units"`
+	Filters int    `yaml:"filters" json:"filters"`
+	Kernel  int    `yaml:"kernel" json:"kernel"`
+	Stride  int    `yaml:"stride" json:"stride"`
+	Padding int    `yaml:"padding" json:"padding"`
+	Size    int    `yaml:"size" json:"size"`
+}
+
+// PreprocessConfig selects how samples become model inputs. Mode "raw"
+// (default) samples the compressed bytes, which keeps a run I/O bound;
+// "decode" decodes JPEG/PNG pixels on Workers goroutines (default one per
+// CPU), resizes them to an RGB grid and normalizes each channel by Mean
+// and Std (default ImageNet). The augmentations apply to decoded training
+// samples: a random crop of at least CropScale of each side, a horizontal
+// flip of half the images, and a brightness factor within ±Brightness.
+// Batches are built ahead of the training loop, with up to Prefetch
+// (default 2) ready batches queued.
+type PreprocessConfig struct {
+	Mode       string    `yaml:"mode" json:"mode"`
+	Workers    int       `yaml:"workers" json:"workers"`
+	Prefetch   int       `yaml:"prefetch" json:"prefetch"`
+	Mean       []float64 `yaml:"mean" json:"mean"`
+	Std        []float64 `yaml:"std" json:"std"`
+	CropScale  float64   `yaml:"crop_scale" json:"crop_scale"`
+	Flip       bool      `yaml:"flip" json:"flip"`
+	Brightness float64   `yaml:"brightness" json:"brightness"`
 }
 
 // Overrides captures CLI supplied values.
 type Overrides struct {
-	TrainRootA string
-	TrainRootB string
-	Steps      int
+	// Roots replace the path of a configured root with the same name, or
+	// are appended when the name is new.
+	Roots []RootConfig
+	Steps int
+	// Epochs replaces the configured run length; when only one of Steps
+	// and Epochs is given, the other is cleared.
+	Epochs     int
 	BatchSize  int
 	NumWorkers int
+	Replicas   int
 	Seed       int64
 	LogEvery   int
+
+	// Optimizer replaces the optimizer name and LR its learning rate; the
+	// other optimizer settings are kept. LRSchedule replaces the schedule
+	// name.
+	Optimizer  string
+	LR         float64
+	LRSchedule string
+
+	ShuffleBuffer int
+	Ordering      string
+	ReorderWindow int
+
+	// Preprocess replaces the preprocessing mode, DecodeWorkers its worker
+	// count and Prefetch its queue depth.
+	Preprocess    string
+	DecodeWorkers int
+	Prefetch      int
+
+	// Rank and WorldSize are pointers because rank 0 is a valid override.
+	Rank      *int
+	WorldSize *int
+	Partition string
+
+	CheckpointDir   string
+	CheckpointEvery int
+	MetricsAddr     string
+	LogFormat       string
+
+	// ValidationRoots follow the same replace-or-append rule as Roots.
+	ValidationRoots []RootConfig
+	EvalEvery       int
+
+	ErrorPolicy    string
+	QuarantineFile string
+
+	// VerifyChecksums turns verification on; it cannot turn it off.
+	VerifyChecksums  bool
+	ChecksumMismatch string
 }
 
-// Load reads and validates a Config from YAML.
+// Load reads a Config from YAML. It does not validate it, so that settings
+// such as roots can still come from overrides; call Validate once they have
+// been applied.
 func Load(path string) (*Config, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -44,24 +232,24 @@ func Load(path string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("parse config: %w", err)
 	}
-
-	if err := cfg.Validate(); err != nil {
-		return nil, err
-	}
-
 	return cfg, nil
 }
 
 // ApplyOverrides updates cfg using any non-zero override.
 func (c *Config) ApplyOverrides(o Overrides) {
-	if o.TrainRootA != "" {
-		c.TrainRootA = o.TrainRootA
-	}
-	if o.TrainRootB != "" {
-		c.TrainRootB = o.TrainRootB
-	}
+	c.Roots = overrideRoots(c.Roots, o.Roots)
+	c.ValidationRoots = overrideRoots(c.ValidationRoots, o.ValidationRoots)
 	if o.Steps > 0 {
 		c.Steps = o.Steps
+		if o.Epochs == 0 {
+			c.Epochs = 0
+		}
+	}
+	if o.Epochs > 0 {
+		c.Epochs = o.Epochs
+		if o.Steps == 0 {
+			c.Steps = 0
+		}
 	}
 	if o.BatchSize > 0 {
 		c.BatchSize = o.BatchSize
@@ -69,12 +257,132 @@ func (c *Config) ApplyOverrides(o Overrides) {
 	if o.NumWorkers > 0 {
 		c.NumWorkers = o.NumWorkers
 	}
+	if o.Replicas > 0 {
+		c.Replicas = o.Replicas
+	}
+	if o.Optimizer != "" {
+		c.Optimizer.Name = o.Optimizer
+	}
+	if o.LR > 0 {
+		c.Optimizer.LR = o.LR
+	}
+	if o.LRSchedule != "" {
+		c.LRSchedule.Name = o.LRSchedule
+	}
 	if o.Seed != 0 {
 		c.Seed = o.Seed
 	}
 	if o.LogEvery > 0 {
 		c.LogEvery = o.LogEvery
 	}
+	if o.ShuffleBuffer > 0 {
+		c.ShuffleBuffer = o.ShuffleBuffer
+	}
+	if o.Ordering != "" {
+		c.Ordering = o.Ordering
+	}
+	if o.ReorderWindow > 0 {
+		c.ReorderWindow = o.ReorderWindow
+	}
+	if o.Preprocess != "" {
+		c.Preprocess.Mode = o.Preprocess
+	}
+	if o.DecodeWorkers > 0 {
+		c.Preprocess.Workers = o.DecodeWorkers
+	}
+	if o.Prefetch > 0 {
+		c.Preprocess.Prefetch = o.Prefetch
+	}
+	if o.Rank != nil {
+		c.Rank = *o.Rank
+	}
+	if o.WorldSize != nil {
+		c.WorldSize = *o.WorldSize
+	}
+	if o.Partition != "" {
+		c.Partition = o.Partition
+	}
+	if o.CheckpointDir != "" {
+		c.CheckpointDir = o.CheckpointDir
+	}
+	if o.CheckpointEvery > 0 {
+		c.CheckpointEvery = o.CheckpointEvery
+	}
+	if o.MetricsAddr != "" {
+		c.MetricsAddr = o.MetricsAddr
+	}
+	if o.LogFormat != "" {
+		c.LogFormat = o.LogFormat
+	}
+	if o.EvalEvery > 0 {
+		c.EvalEvery = o.EvalEvery
+	}
+	if o.ErrorPolicy != "" {
+		c.ErrorPolicy = o.ErrorPolicy
+	}
+	if o.QuarantineFile != "" {
+		c.QuarantineFile = o.QuarantineFile
+	}
+	if o.VerifyChecksums {
+		c.VerifyChecksums = true
+	}
+	if o.ChecksumMismatch != "" {
+		c.ChecksumMismatch = o.ChecksumMismatch
+	}
+}
+
+func overrideRoots(roots, overrides []RootConfig) []RootConfig {
+	for _, override := range overrides {
+		replaced := false
+		for i := range roots {
+			if roots[i].Name == override.Name {
+				// A configured manifest describes the old path.
+				roots[i].Path = override.Path
+				roots[i].Manifest = ""
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			roots = append(roots, override)
+		}
+	}
+	return roots
+}
+
+// RootWeights returns the sampling weight per root name, or nil when no
+// root sets a weight.
+func (c *Config) RootWeights() map[string]float64 {
+	weighted := false
+	for _, root := range c.Roots {
+		if root.Weight > 0 {
+			weighted = true
+			break
+		}
+	}
+	if !weighted {
+		return nil
+	}
+	weights := make(map[string]float64, len(c.Roots))
+	for _, root := range c.Roots {
+		weight := root.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		weights[root.Name] = weight
+	}
+	return weights
+}
+
+// ParseRootFlag parses a CLI root override of the form name=path.
+func ParseRootFlag(value string) (RootConfig, error) {
+	name, path, ok := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	path = strings.TrimSpace(path)
+	if !ok || name == "" || path == "" {
+		return RootConfig{}, fmt.Errorf("root %q: expected name=path", value)
+	}
+	return RootConfig{Name: name, Path: path}, nil
 }
 
 // Validate verifies the config is runnable.
@@ -82,14 +390,23 @@ func (c *Config) Validate() error {
 	if c == nil {
 		return errors.New("config is nil")
 	}
-	if c.TrainRootA == "" && c.TrainRootB == "" {
+	if len(c.Roots) == 0 {
 		return errors.New("at least one training root must be set")
 	}
-	if c.TrainRootA == "" || c.TrainRootB == "" {
-		return errors.New("both training roots must be provided for multi-region demo")
+	if err := validateRoots("roots", c.Roots); err != nil {
+		return err
+	}
+	if err := validateRoots("validation_roots", c.ValidationRoots); err != nil {
+		return err
 	}
-	if c.Steps <= 0 {
-		return fmt.Errorf("steps must be > 0 (got %d)", c.Steps)
+	if c.Steps < 0 {
+		return fmt.Errorf("steps must be >= 0 (got %d)", c.Steps)
+	}
+	if c.Epochs < 0 {
+		return fmt.Errorf("epochs must be >= 0 (got %d)", c.Epochs)
+	}
+	if c.Steps == 0 && c.Epochs == 0 {
+		return errors.New("steps or epochs must be > 0")
 	}
 	if c.BatchSize <= 0 {
 		return fmt.Errorf("batch_size must be > 0 (got %d)", c.BatchSize)
@@ -97,70 +414,698 @@ func (c *Config) Validate() error {
 	if c.NumWorkers <= 0 {
 		return fmt.Errorf("num_workers must be > 0 (got %d)", c.NumWorkers)
 	}
+	if c.Replicas < 0 {
+		return fmt.Errorf("replicas must be >= 0 (got %d)", c.Replicas)
+	}
+	if err := validateOptimizer(c.Optimizer); err != nil {
+		return err
+	}
+	if err := c.LRSchedule.validate(); err != nil {
+		return err
+	}
+	if err := c.Model.validate(); err != nil {
+		return err
+	}
+	modes, err := c.Modes()
+	if err != nil {
+		return err
+	}
+	// Spell every mode the way its package does, which fills in defaults.
+	c.LogFormat = modes.LogFormat.String()
+	c.ErrorPolicy = modes.ErrorPolicy.String()
+	c.Ordering = modes.Ordering.String()
+	c.Partition = modes.Partition.String()
+	c.ChecksumMismatch = modes.ChecksumMismatch.String()
+	c.Preprocess.Mode = modes.Preprocess.String()
+	if err := c.Preprocess.validate(); err != nil {
+		return err
+	}
 	if c.LogEvery <= 0 {
 		c.LogEvery = 50
 	}
+	if c.ShuffleBuffer < 0 {
+		return fmt.Errorf("shuffle_buffer must be >= 0 (got %d)", c.ShuffleBuffer)
+	}
+	if c.ShuffleBufferBytes < 0 {
+		return fmt.Errorf("shuffle_buffer_bytes must be >= 0 (got %d)", c.ShuffleBufferBytes)
+	}
+	if c.ReorderWindow < 0 {
+		return fmt.Errorf("reorder_window must be >= 0 (got %d)", c.ReorderWindow)
+	}
+	if modes.Ordering == dataset.OrderRelaxed && (c.ShuffleBuffer > 0 || c.ShuffleBufferBytes > 0) {
+		return errors.New("shuffle_buffer requires strict ordering")
+	}
+	if c.WorldSize < 0 {
+		return fmt.Errorf("world_size must be >= 0 (got %d)", c.WorldSize)
+	}
+	if c.WorldSize == 0 {
+		c.WorldSize = 1
+	}
+	if c.Rank < 0 || c.Rank >= c.WorldSize {
+		return fmt.Errorf("rank must be in [0, %d) (got %d)", c.WorldSize, c.Rank)
+	}
+	if c.ErrorBudget < 0 {
+		return fmt.Errorf("error_budget must be >= 0 (got %d)", c.ErrorBudget)
+	}
+	if c.ChecksumRereads < 0 {
+		return fmt.Errorf("checksum_rereads must be >= 0 (got %d)", c.ChecksumRereads)
+	}
+	if c.CheckpointEvery < 0 {
+		return fmt.Errorf("checkpoint_every must be >= 0 (got %d)", c.CheckpointEvery)
+	}
+	if c.CheckpointKeep < 0 {
+		return fmt.Errorf("checkpoint_keep must be >= 0 (got %d)", c.CheckpointKeep)
+	}
+	if c.EvalEvery < 0 {
+		return fmt.Errorf("eval_every must be >= 0 (got %d)", c.EvalEvery)
+	}
+	if c.EvalMaxSamples < 0 {
+		return fmt.Errorf("eval_max_samples must be >= 0 (got %d)", c.EvalMaxSamples)
+	}
+	if c.EvalEvery > 0 && len(c.ValidationRoots) == 0 {
+		return errors.New("eval_every requires validation_roots")
+	}
+	if c.CheckpointDir != "" {
+		if c.CheckpointEvery == 0 {
+			c.CheckpointEvery = 100
+		}
+		if c.CheckpointKeep == 0 {
+			c.CheckpointKeep = 3
+		}
+	}
+	return nil
+}
+
+// validateOptimizer checks o. An unset learning rate is left at 0 for the
+// trainer, which picks the default of the final optimizer name, so a later
+// -optimizer override still gets its own default.
+func validateOptimizer(o model.OptimizerConfig) error {
+	switch o.Name {
+	case "":
+		if o.LR < 0 {
+			return fmt.Errorf("optimizer.lr must be > 0 (got %g)", o.LR)
+		}
+		return nil
+	case "sgd", "adam", "adamw":
+	default:
+		return fmt.Errorf("optimizer.name must be sgd, adam or adamw (got %q)", o.Name)
+	}
+	if o.LR < 0 {
+		return fmt.Errorf("optimizer.lr must be > 0 (got %g)", o.LR)
+	}
+	if o.Momentum < 0 || o.Momentum >= 1 {
+		return fmt.Errorf("optimizer.momentum must be in [0, 1) (got %g)", o.Momentum)
+	}
+	if o.Nesterov && (o.Name != "sgd" || o.Momentum == 0) {
+		return errors.New("optimizer.nesterov requires sgd with momentum")
+	}
+	if o.Beta1 < 0 || o.Beta1 >= 1 || o.Beta2 < 0 || o.Beta2 >= 1 {
+		return fmt.Errorf("optimizer betas must be in [0, 1) (got %g, %g)", o.Beta1, o.Beta2)
+	}
+	if o.Epsilon < 0 {
+		return fmt.Errorf("optimizer.epsilon must be >= 0 (got %g)", o.Epsilon)
+	}
+	if o.WeightDecay < 0 {
+		return fmt.Errorf("optimizer.weight_decay must be >= 0 (got %g)", o.WeightDecay)
+	}
+	return nil
+}
+
+func (sc *ScheduleConfig) validate() error {
+	switch sc.Name {
+	case "":
+		sc.Name = "constant"
+	case "constant", "step", "cosine", "one_cycle":
+	default:
+		return fmt.Errorf("lr_schedule.name must be constant, step, cosine or one_cycle (got %q)", sc.Name)
+	}
+	if sc.WarmupSteps < 0 || sc.TotalSteps < 0 || sc.StepSize < 0 {
+		return errors.New("lr_schedule step counts must be >= 0")
+	}
+	if sc.Gamma < 0 || sc.MinLR < 0 || sc.PctStart < 0 || sc.PctStart >= 1 || sc.DivFactor < 0 || sc.FinalDivFactor < 0 {
+		return errors.New("lr_schedule: gamma, min_lr, div_factor and final_div_factor must be >= 0 and pct_start in [0, 1)")
+	}
+	if sc.Name == "step" && sc.StepSize == 0 {
+		return errors.New("lr_schedule.step_size is required by the step schedule")
+	}
+	return nil
+}
+
+func (m *ModelConfig) validate() error {
+	for i, layer := range m.Layers {
+		if layer.Units < 0 || layer.Filters < 0 || layer.Kernel < 0 || layer.Stride < 0 || layer.Padding < 0 || layer.Size < 0 {
+			return fmt.Errorf("model.layers[%d]: sizes must be >= 0", i)
+		}
+		switch layer.Type {
+		case "dense":
+			if layer.Units == 0 {
+				return fmt.Errorf("model.layers[%d]: dense needs units", i)
+			}
+		case "conv2d":
+			if layer.Filters == 0 || layer.Kernel == 0 {
+				return fmt.Errorf("model.layers[%d]: conv2d needs filters and kernel", i)
+			}
+		case "max_pool", "avg_pool", "relu", "flatten":
+		default:
+			return fmt.Errorf("model.layers[%d]: type must be dense, conv2d, max_pool, avg_pool, relu or flatten (got %q)", i, layer.Type)
+		}
+	}
+	return nil
+}
+
+// Modes holds the settings of a Config that name a mode, parsed by the
+// packages that use them.
+type Modes struct {
+	LogFormat        logging.Format
+	ErrorPolicy      dataset.ErrorPolicy
+	Ordering         dataset.Ordering
+	Partition        dataset.Partition
+	ChecksumMismatch dataset.MismatchAction
+	Preprocess       trainer.PreprocessMode
+}
+
+// Modes parses the mode settings, naming the offending key in its error.
+func (c *Config) Modes() (Modes, error) {
+	var m Modes
+	var err error
+	if m.LogFormat, err = logging.ParseFormat(c.LogFormat); err != nil {
+		return m, fmt.Errorf("log_format: %w", err)
+	}
+	if m.ErrorPolicy, err = dataset.ParseErrorPolicy(c.ErrorPolicy); err != nil {
+		return m, fmt.Errorf("error_policy: %w", err)
+	}
+	if m.Ordering, err = dataset.ParseOrdering(c.Ordering); err != nil {
+		return m, fmt.Errorf("ordering: %w", err)
+	}
+	if m.Partition, err = dataset.ParsePartition(c.Partition); err != nil {
+		return m, fmt.Errorf("partition: %w", err)
+	}
+	if m.ChecksumMismatch, err = dataset.ParseMismatchAction(c.ChecksumMismatch); err != nil {
+		return m, fmt.Errorf("checksum_mismatch: %w", err)
+	}
+	if m.Preprocess, err = trainer.ParsePreprocessMode(c.Preprocess.Mode); err != nil {
+		return m, fmt.Errorf("preprocess.mode: %w", err)
+	}
+	return m, nil
+}
+
+func (p *PreprocessConfig) validate() error {
+	if p.Workers < 0 {
+		return fmt.Errorf("preprocess.workers must be >= 0 (got %d)", p.Workers)
+	}
+	if p.Prefetch < 0 {
+		return fmt.Errorf("preprocess.prefetch must be >= 0 (got %d)", p.Prefetch)
+	}
+	if len(p.Mean) != 0 && len(p.Mean) != 3 {
+		return fmt.Errorf("preprocess.mean needs 3 values, one per RGB channel (got %d)", len(p.Mean))
+	}
+	if len(p.Std) != 0 && len(p.Std) != 3 {
+		return fmt.Errorf("preprocess.std needs 3 values, one per RGB channel (got %d)", len(p.Std))
+	}
+	for _, std := range p.Std {
+		if std <= 0 {
+			return fmt.Errorf("preprocess.std values must be > 0 (got %g)", std)
+		}
+	}
+	if p.CropScale < 0 || p.CropScale > 1 {
+		return fmt.Errorf("preprocess.crop_scale must be in [0, 1] (got %g)", p.CropScale)
+	}
+	if p.Brightness < 0 || p.Brightness >= 1 {
+		return fmt.Errorf("preprocess.brightness must be in [0, 1) (got %g)", p.Brightness)
+	}
+	augmented := (p.CropScale > 0 && p.CropScale < 1) || p.Flip || p.Brightness > 0
+	if p.Mode == "raw" && (augmented || len(p.Mean) > 0 || len(p.Std) > 0) {
+		return errors.New("preprocess: normalization and augmentation require mode decode")
+	}
+	return nil
+}
+
+func validateRoots(field string, roots []RootConfig) error {
+	seen := make(map[string]bool, len(roots))
+	for i, root := range roots {
+		if root.Name == "" {
+			return fmt.Errorf("%s[%d]: name must be set", field, i)
+		}
+		if strings.ContainsAny(root.Name, "= ") {
+			return fmt.Errorf("%s[%d]: name %q must not contain '=' or spaces", field, i, root.Name)
+		}
+		if root.Path == "" {
+			return fmt.Errorf("root %s: path must be set", root.Name)
+		}
+		if root.Weight < 0 {
+			return fmt.Errorf("root %s: weight must be >= 0 (got %g)", root.Name, root.Weight)
+		}
+		if seen[root.Name] {
+			return fmt.Errorf("root %s: duplicate name", root.Name)
+		}
+		seen[root.Name] = true
+	}
 	return nil
 }
 
 func parseYAML(r io.Reader) (*Config, error) {
+	tree, err := parseTree(r)
+	if err != nil {
+		return nil, err
+	}
 	cfg := &Config{}
-	scanner := bufio.NewScanner(r)
-	lineNo := 0
-	for scanner.Scan() {
-		lineNo++
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("line %d: missing ':'", lineNo)
-		}
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-		value = strings.Trim(value, "\"'")
+	for _, key := range tree.keys {
+		value := tree.fields[key]
 		switch key {
-		case "train_root_a":
-			cfg.TrainRootA = value
-		case "train_root_b":
-			cfg.TrainRootB = value
+		case "roots":
+			if cfg.Roots, err = parseRoots(value, key); err != nil {
+				return nil, err
+			}
+		case "validation_roots":
+			if cfg.ValidationRoots, err = parseRoots(value, key); err != nil {
+				return nil, err
+			}
+		case "error_policy":
+			if cfg.ErrorPolicy, err = value.str(key); err != nil {
+				return nil, err
+			}
+		case "error_budget":
+			if cfg.ErrorBudget, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "quarantine_file":
+			if cfg.QuarantineFile, err = value.str(key); err != nil {
+				return nil, err
+			}
+		case "verify_checksums":
+			if cfg.VerifyChecksums, err = value.boolValue(key); err != nil {
+				return nil, err
+			}
+		case "checksum_mismatch":
+			if cfg.ChecksumMismatch, err = value.str(key); err != nil {
+				return nil, err
+			}
+		case "checksum_rereads":
+			if cfg.ChecksumRereads, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "eval_every":
+			if cfg.EvalEvery, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "eval_max_samples":
+			if cfg.EvalMaxSamples, err = value.intValue(key); err != nil {
+				return nil, err
+			}
 		case "steps":
-			v, err := strconv.Atoi(value)
-			if err != nil {
-				return nil, fmt.Errorf("line %d: steps: %w", lineNo, err)
+			if cfg.Steps, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "epochs":
+			if cfg.Epochs, err = value.intValue(key); err != nil {
+				return nil, err
 			}
-			cfg.Steps = v
 		case "batch_size":
-			v, err := strconv.Atoi(value)
-			if err != nil {
-				return nil, fmt.Errorf("line %d: batch_size: %w", lineNo, err)
+			if cfg.BatchSize, err = value.intValue(key); err != nil {
+				return nil, err
 			}
-			cfg.BatchSize = v
 		case "num_workers":
-			v, err := strconv.Atoi(value)
-			if err != nil {
-				return nil, fmt.Errorf("line %d: num_workers: %w", lineNo, err)
+			if cfg.NumWorkers, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "replicas":
+			if cfg.Replicas, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "optimizer":
+			if cfg.Optimizer, err = parseOptimizer(value, key); err != nil {
+				return nil, err
+			}
+		case "lr_schedule":
+			if cfg.LRSchedule, err = parseSchedule(value, key); err != nil {
+				return nil, err
+			}
+		case "model":
+			if cfg.Model, err = parseModel(value, key); err != nil {
+				return nil, err
+			}
+		case "preprocess":
+			if cfg.Preprocess, err = parsePreprocess(value, key); err != nil {
+				return nil, err
 			}
-			cfg.NumWorkers = v
 		case "seed":
-			v, err := strconv.ParseInt(value, 10, 64)
-			if err != nil {
-				return nil, fmt.Errorf("line %d: seed: %w", lineNo, err)
+			if cfg.Seed, err = value.int64Value(key); err != nil {
+				return nil, err
 			}
-			cfg.Seed = v
 		case "log_every":
-			v, err := strconv.Atoi(value)
+			if cfg.LogEvery, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "shuffle_buffer":
+			if cfg.ShuffleBuffer, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "shuffle_buffer_bytes":
+			if cfg.ShuffleBufferBytes, err = value.int64Value(key); err != nil {
+				return nil, err
+			}
+		case "ordering":
+			if cfg.Ordering, err = value.str(key); err != nil {
+				return nil, err
+			}
+		case "reorder_window":
+			if cfg.ReorderWindow, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "rank":
+			if cfg.Rank, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "world_size":
+			if cfg.WorldSize, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "partition":
+			if cfg.Partition, err = value.str(key); err != nil {
+				return nil, err
+			}
+		case "checkpoint_dir":
+			if cfg.CheckpointDir, err = value.str(key); err != nil {
+				return nil, err
+			}
+		case "checkpoint_every":
+			if cfg.CheckpointEvery, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "checkpoint_keep":
+			if cfg.CheckpointKeep, err = value.intValue(key); err != nil {
+				return nil, err
+			}
+		case "metrics_addr":
+			if cfg.MetricsAddr, err = value.str(key); err != nil {
+				return nil, err
+			}
+		case "log_format":
+			if cfg.LogFormat, err = value.str(key); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %s", value.line, key)
+		}
+	}
+	return cfg, nil
+}
+
+func parseOptimizer(n *node, field string) (model.OptimizerConfig, error) {
+	var opt model.OptimizerConfig
+	if _, err := n.mapping(field); err != nil {
+		return opt, err
+	}
+	var err error
+	for _, key := range n.keys {
+		value := n.fields[key]
+		switch key {
+		case "name":
+			if opt.Name, err = value.str(key); err != nil {
+				return opt, err
+			}
+		case "lr":
+			if opt.LR, err = value.floatValue(key); err != nil {
+				return opt, err
+			}
+		case "momentum":
+			if opt.Momentum, err = value.floatValue(key); err != nil {
+				return opt, err
+			}
+		case "nesterov":
+			if opt.Nesterov, err = value.boolValue(key); err != nil {
+				return opt, err
+			}
+		case "beta1":
+			if opt.Beta1, err = value.floatValue(key); err != nil {
+				return opt, err
+			}
+		case "beta2":
+			if opt.Beta2, err = value.floatValue(key); err != nil {
+				return opt, err
+			}
+		case "epsilon":
+			if opt.Epsilon, err = value.floatValue(key); err != nil {
+				return opt, err
+			}
+		case "weight_decay":
+			if opt.WeightDecay, err = value.floatValue(key); err != nil {
+				return opt, err
+			}
+		default:
+			return opt, fmt.Errorf("line %d: unknown optimizer key %s", value.line, key)
+		}
+	}
+	return opt, nil
+}
+
+func parseSchedule(n *node, field string) (ScheduleConfig, error) {
+	var sc ScheduleConfig
+	if _, err := n.mapping(field); err != nil {
+		return sc, err
+	}
+	var err error
+	for _, key := range n.keys {
+		value := n.fields[key]
+		switch key {
+		case "name":
+			if sc.Name, err = value.str(key); err != nil {
+				return sc, err
+			}
+		case "warmup_steps":
+			if sc.WarmupSteps, err = value.intValue(key); err != nil {
+				return sc, err
+			}
+		case "total_steps":
+			if sc.TotalSteps, err = value.intValue(key); err != nil {
+				return sc, err
+			}
+		case "step_size":
+			if sc.StepSize, err = value.intValue(key); err != nil {
+				return sc, err
+			}
+		case "gamma":
+			if sc.Gamma, err = value.floatValue(key); err != nil {
+				return sc, err
+			}
+		case "min_lr":
+			if sc.MinLR, err = value.floatValue(key); err != nil {
+				return sc, err
+			}
+		case "pct_start":
+			if sc.PctStart, err = value.floatValue(key); err != nil {
+				return sc, err
+			}
+		case "div_factor":
+			if sc.DivFactor, err = value.floatValue(key); err != nil {
+				return sc, err
+			}
+		case "final_div_factor":
+			if sc.FinalDivFactor, err = value.floatValue(key); err != nil {
+				return sc, err
+			}
+		default:
+			return sc, fmt.Errorf("line %d: unknown lr_schedule key %s", value.line, key)
+		}
+	}
+	return sc, nil
+}
+
+func parseModel(n *node, field string) (ModelConfig, error) {
+	var m ModelConfig
+	if _, err := n.mapping(field); err != nil {
+		return m, err
+	}
+	for _, key := range n.keys {
+		value := n.fields[key]
+		switch key {
+		case "layers":
+			items, err := value.seq(key)
 			if err != nil {
-				return nil, fmt.Errorf("line %d: log_every: %w", lineNo, err)
+				return m, err
+			}
+			for _, item := range items {
+				layer, err := parseLayer(item, key)
+				if err != nil {
+					return m, err
+				}
+				m.Layers = append(m.Layers, layer)
+			}
+		default:
+			return m, fmt.Errorf("line %d: unknown model key %s", value.line, key)
+		}
+	}
+	return m, nil
+}
+
+func parseLayer(n *node, field string) (LayerConfig, error) {
+	var layer LayerConfig
+	if _, err := n.mapping(field); err != nil {
+		return layer, err
+	}
+	var err error
+	for _, key := range n.keys {
+		value := n.fields[key]
+		switch key {
+		case "type":
+			if layer.Type, err = value.str(key); err != nil {
+				return layer, err
+			}
+		case "units":
+			if layer.Units, err = value.intValue(key); err != nil {
+				return layer, err
+			}
+		case "filters":
+			if layer.Filters, err = value.intValue(key); err != nil {
+				return layer, err
+			}
+		case "kernel":
+			if layer.Kernel, err = value.intValue(key); err != nil {
+				return layer, err
+			}
+		case "stride":
+			if layer.Stride, err = value.intValue(key); err != nil {
+				return layer, err
+			}
+		case "padding":
+			if layer.Padding, err = value.intValue(key); err != nil {
+				return layer, err
+			}
+		case "size":
+			if layer.Size, err = value.intValue(key); err != nil {
+				return layer, err
 			}
-			cfg.LogEvery = v
 		default:
-			return nil, fmt.Errorf("line %d: unknown key %s", lineNo, key)
+			return layer, fmt.Errorf("line %d: unknown layer key %s", value.line, key)
 		}
 	}
-	if err := scanner.Err(); err != nil {
+	return layer, nil
+}
+
+func parsePreprocess(n *node, field string) (PreprocessConfig, error) {
+	var p PreprocessConfig
+	if _, err := n.mapping(field); err != nil {
+		return p, err
+	}
+	var err error
+	for _, key := range n.keys {
+		value := n.fields[key]
+		switch key {
+		case "mode":
+			if p.Mode, err = value.str(key); err != nil {
+				return p, err
+			}
+		case "workers":
+			if p.Workers, err = value.intValue(key); err != nil {
+				return p, err
+			}
+		case "prefetch":
+			if p.Prefetch, err = value.intValue(key); err != nil {
+				return p, err
+			}
+		case "mean":
+			if p.Mean, err = parseFloats(value, key); err != nil {
+				return p, err
+			}
+		case "std":
+			if p.Std, err = parseFloats(value, key); err != nil {
+				return p, err
+			}
+		case "crop_scale":
+			if p.CropScale, err = value.floatValue(key); err != nil {
+				return p, err
+			}
+		case "flip":
+			if p.Flip, err = value.boolValue(key); err != nil {
+				return p, err
+			}
+		case "brightness":
+			if p.Brightness, err = value.floatValue(key); err != nil {
+				return p, err
+			}
+		default:
+			return p, fmt.Errorf("line %d: unknown preprocess key %s", value.line, key)
+		}
+	}
+	return p, nil
+}
+
+func parseFloats(n *node, key string) ([]float64, error) {
+	items, err := n.seq(key)
+	if err != nil {
 		return nil, err
 	}
-	return cfg, nil
+	values := make([]float64, 0, len(items))
+	for _, item := range items {
+		v, err := item.floatValue(key)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func parseRoots(n *node, key string) ([]RootConfig, error) {
+	items, err := n.seq(key)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]RootConfig, 0, len(items))
+	for _, item := range items {
+		root, err := parseRoot(item, key)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	return roots, nil
+}
+
+func parseRoot(n *node, field string) (RootConfig, error) {
+	var root RootConfig
+	if _, err := n.mapping(field); err != nil {
+		return root, err
+	}
+	var err error
+	for _, key := range n.keys {
+		value := n.fields[key]
+		switch key {
+		case "name":
+			if root.Name, err = value.str(key); err != nil {
+				return root, err
+			}
+		case "path":
+			if root.Path, err = value.str(key); err != nil {
+				return root, err
+			}
+		case "optional":
+			if root.Optional, err = value.boolValue(key); err != nil {
+				return root, err
+			}
+		case "manifest":
+			if root.Manifest, err = value.str(key); err != nil {
+				return root, err
+			}
+		case "patterns":
+			if root.Patterns, err = value.stringList(key); err != nil {
+				return root, err
+			}
+		case "include":
+			if root.Include, err = value.stringList(key); err != nil {
+				return root, err
+			}
+		case "exclude":
+			if root.Exclude, err = value.stringList(key); err != nil {
+				return root, err
+			}
+		case "weight":
+			if root.Weight, err = value.floatValue(key); err != nil {
+				return root, err
+			}
+		default:
+			return root, fmt.Errorf("line %d: unknown root key %s", value.line, key)
+		}
+	}
+	return root, nil
 }
@@ -0,0 +1,495 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"warpdrive-forge/internal/dataset"
+	"warpdrive-forge/internal/model"
+)
+
+func TestParseYAMLRoots(t *testing.T) {
+	cfg, err := parseYAML(strings.NewReader(`
+roots:
+  - name: cac
+    path: /wd/datasets-cac/train
+    patterns:
+      - train-{00000..01023}-of-01024.tar
+      - "re:^extra-\d+\.tar$"
+    include: [2024, 2025]
+    exclude: quarantine
+  - name: wus3
+    path: "/wd/datasets-wus3/train"
+    optional: true
+    weight: 0.3
+    manifest: /var/lib/forge/wus3.jsonl
+steps: 10
+batch_size: 4
+num_workers: 2
+`))
+	if err != nil {
+		t.Fatalf("parseYAML: %v", err)
+	}
+	if len(cfg.Roots) != 2 {
+		t.Fatalf("expected 2 roots, got %d", len(cfg.Roots))
+	}
+	if cfg.Roots[1].Name != "wus3" || cfg.Roots[1].Path != "/wd/datasets-wus3/train" || !cfg.Roots[1].Optional || cfg.Roots[1].Manifest != "/var/lib/forge/wus3.jsonl" {
+		t.Fatalf("unexpected root: %+v", cfg.Roots[1])
+	}
+	cac := cfg.Roots[0]
+	if len(cac.Patterns) != 2 || cac.Patterns[1] != `re:^extra-\d+\.tar$` || len(cac.Include) != 2 || cac.Include[1] != "2025" || len(cac.Exclude) != 1 || cac.Exclude[0] != "quarantine" {
+		t.Fatalf("unexpected shard filters: %+v", cac)
+	}
+	weights := cfg.RootWeights()
+	if weights["cac"] != 1 || weights["wus3"] != 0.3 {
+		t.Fatalf("unexpected weights: %v", weights)
+	}
+	if cfg.Steps != 10 || cfg.BatchSize != 4 || cfg.NumWorkers != 2 {
+		t.Fatalf("unexpected scalars: %+v", cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+func TestParseYAMLRejectsUnknownKeys(t *testing.T) {
+	_, err := parseYAML(strings.NewReader("steps: 1\nbogus: 2\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2: unknown key bogus") {
+		t.Fatalf("expected unknown key error, got %v", err)
+	}
+	_, err = parseYAML(strings.NewReader("roots:\n  - name: a\n    colour: red\n"))
+	if err == nil || !strings.Contains(err.Error(), "unknown root key colour") {
+		t.Fatalf("expected unknown root key error, got %v", err)
+	}
+}
+
+func TestApplyOverridesRoots(t *testing.T) {
+	cfg := &Config{
+		Roots:      []RootConfig{{Name: "cac", Path: "/a", Optional: true, Manifest: "/a.jsonl"}},
+		Steps:      1,
+		BatchSize:  1,
+		NumWorkers: 1,
+	}
+	override, err := ParseRootFlag("cac=/b")
+	if err != nil {
+		t.Fatalf("ParseRootFlag: %v", err)
+	}
+	extra, err := ParseRootFlag("weu=/c")
+	if err != nil {
+		t.Fatalf("ParseRootFlag: %v", err)
+	}
+	cfg.ApplyOverrides(Overrides{Roots: []RootConfig{override, extra}})
+	if len(cfg.Roots) != 2 {
+		t.Fatalf("expected 2 roots, got %d", len(cfg.Roots))
+	}
+	if cfg.Roots[0].Path != "/b" || !cfg.Roots[0].Optional || cfg.Roots[0].Manifest != "" {
+		t.Fatalf("override should replace path and manifest and keep options: %+v", cfg.Roots[0])
+	}
+	if cfg.Roots[1].Name != "weu" || cfg.Roots[1].Path != "/c" {
+		t.Fatalf("unexpected appended root: %+v", cfg.Roots[1])
+	}
+	if _, err := ParseRootFlag("/no/name"); err == nil {
+		t.Fatal("expected error for root flag without name")
+	}
+}
+
+func TestLoadTakesRootsFromOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "forge.yaml")
+	if err := os.WriteFile(path, []byte("steps: 10\nbatch_size: 4\nnum_workers: 2\n"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	root, err := ParseRootFlag("cac=/wd/datasets-cac/train")
+	if err != nil {
+		t.Fatalf("ParseRootFlag: %v", err)
+	}
+	cfg.ApplyOverrides(Overrides{Roots: []RootConfig{root}})
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if len(cfg.Roots) != 1 || cfg.Roots[0].Name != "cac" {
+		t.Fatalf("expected the root from the override, got %+v", cfg.Roots)
+	}
+}
+
+func TestValidateRejectsDuplicateRoots(t *testing.T) {
+	cfg := &Config{
+		Roots:      []RootConfig{{Name: "a", Path: "/a"}, {Name: "a", Path: "/b"}},
+		Steps:      1,
+		BatchSize:  1,
+		NumWorkers: 1,
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected duplicate root error")
+	}
+}
+
+func TestParseYAMLValidationRoots(t *testing.T) {
+	cfg, err := parseYAML(strings.NewReader(`
+roots:
+  - name: cac
+    path: /wd/datasets-cac/train
+validation_roots:
+  - name: cac
+    path: /wd/datasets-cac/val
+eval_every: 20
+eval_max_samples: 500
+steps: 10
+batch_size: 4
+num_workers: 2
+`))
+	if err != nil {
+		t.Fatalf("parseYAML: %v", err)
+	}
+	if len(cfg.ValidationRoots) != 1 || cfg.ValidationRoots[0].Path != "/wd/datasets-cac/val" {
+		t.Fatalf("unexpected validation roots: %+v", cfg.ValidationRoots)
+	}
+	if cfg.EvalEvery != 20 || cfg.EvalMaxSamples != 500 {
+		t.Fatalf("unexpected eval settings: %+v", cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	cfg.ValidationRoots = nil
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "eval_every requires validation_roots") {
+		t.Fatalf("expected eval_every error, got %v", err)
+	}
+}
+
+func TestValidateErrorPolicy(t *testing.T) {
+	cfg := &Config{
+		Roots:     []RootConfig{{Name: "cac", Path: "/wd/datasets-cac/train"}},
+		Steps:     1,
+		BatchSize: 1, NumWorkers: 1,
+	}
+	if err := cfg.Validate(); err != nil || cfg.ErrorPolicy != "fail" {
+		t.Fatalf("expected default fail policy, got %q, %v", cfg.ErrorPolicy, err)
+	}
+	cfg.ErrorPolicy = "retry"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected error for unknown error_policy")
+	}
+	cfg.ErrorPolicy = "skip_sample"
+	cfg.ErrorBudget = -1
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected error for negative error_budget")
+	}
+}
+
+func TestParseYAMLChecksums(t *testing.T) {
+	cfg, err := parseYAML(strings.NewReader(`
+roots:
+  - name: cac
+    path: /wd/datasets-cac/train
+steps: 1
+batch_size: 1
+num_workers: 1
+verify_checksums: true
+checksum_mismatch: reread
+checksum_rereads: 3
+`))
+	if err != nil {
+		t.Fatalf("parseYAML: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if !cfg.VerifyChecksums || cfg.ChecksumMismatch != "reread" || cfg.ChecksumRereads != 3 {
+		t.Fatalf("unexpected checksum settings: %v %q %d", cfg.VerifyChecksums, cfg.ChecksumMismatch, cfg.ChecksumRereads)
+	}
+	cfg.ApplyOverrides(Overrides{ChecksumMismatch: "retry"})
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "checksum_mismatch") {
+		t.Fatalf("expected checksum_mismatch error, got %v", err)
+	}
+}
+
+func TestApplyOverridesEpochsReplaceSteps(t *testing.T) {
+	cfg := &Config{Steps: 2000}
+	cfg.ApplyOverrides(Overrides{Epochs: 3})
+	if cfg.Epochs != 3 || cfg.Steps != 0 {
+		t.Fatalf("expected epochs to replace steps, got steps=%d epochs=%d", cfg.Steps, cfg.Epochs)
+	}
+	cfg.ApplyOverrides(Overrides{Steps: 10, Epochs: 2})
+	if cfg.Epochs != 2 || cfg.Steps != 10 {
+		t.Fatalf("expected both limits, got steps=%d epochs=%d", cfg.Steps, cfg.Epochs)
+	}
+	cfg.ApplyOverrides(Overrides{Steps: 50})
+	if cfg.Epochs != 0 || cfg.Steps != 50 {
+		t.Fatalf("expected steps to replace epochs, got steps=%d epochs=%d", cfg.Steps, cfg.Epochs)
+	}
+}
+
+func TestValidateOrdering(t *testing.T) {
+	cfg := &Config{
+		Roots:     []RootConfig{{Name: "cac", Path: "/wd/datasets-cac/train"}},
+		Steps:     1,
+		BatchSize: 1, NumWorkers: 1,
+	}
+	if err := cfg.Validate(); err != nil || cfg.Ordering != "strict" {
+		t.Fatalf("expected default strict ordering, got %q, %v", cfg.Ordering, err)
+	}
+	cfg.Ordering = "relaxed"
+	cfg.ReorderWindow = 4
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	cfg.ShuffleBuffer = 64
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "strict ordering") {
+		t.Fatalf("expected shuffle_buffer to require strict ordering, got %v", err)
+	}
+	cfg.ShuffleBuffer = 0
+	cfg.ReorderWindow = -1
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected error for negative reorder_window")
+	}
+	cfg.ReorderWindow = 0
+	cfg.Ordering = "fifo"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "ordering: unknown ordering") {
+		t.Fatalf("expected error for unknown ordering, got %v", err)
+	}
+	cfg.Ordering = " Relaxed"
+	if err := cfg.Validate(); err != nil || cfg.Ordering != "relaxed" {
+		t.Fatalf("expected ordering spelled relaxed, got %q, %v", cfg.Ordering, err)
+	}
+	if modes, err := cfg.Modes(); err != nil || modes.Ordering != dataset.OrderRelaxed {
+		t.Fatalf("expected relaxed ordering mode, got %v, %v", modes.Ordering, err)
+	}
+}
+
+func TestRankOverrides(t *testing.T) {
+	env := map[string]string{"RANK": "0", "WORLD_SIZE": "4", "SLURM_PROCID": "3"}
+	o, err := EnvOverrides(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatalf("EnvOverrides: %v", err)
+	}
+	cfg := &Config{
+		Roots:     []RootConfig{{Name: "cac", Path: "/wd/datasets-cac/train"}},
+		Steps:     1,
+		BatchSize: 1, NumWorkers: 1,
+		Rank: 2, WorldSize: 3,
+	}
+	cfg.ApplyOverrides(o)
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if cfg.Rank != 0 || cfg.WorldSize != 4 || cfg.Partition != "pad" {
+		t.Fatalf("expected rank 0 of 4 with pad, got %d of %d with %q", cfg.Rank, cfg.WorldSize, cfg.Partition)
+	}
+
+	cfg.Rank = 4
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected error for rank >= world_size")
+	}
+	cfg.Rank = 1
+	cfg.Partition = "split"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected error for unknown partition")
+	}
+
+	env["RANK"] = "first"
+	if _, err := EnvOverrides(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}); err == nil {
+		t.Fatal("expected error for a non-numeric RANK")
+	}
+}
+
+func TestParseYAMLOptimizer(t *testing.T) {
+	cfg, err := parseYAML(strings.NewReader(`
+roots:
+  - name: cac
+    path: /wd/datasets-cac/train
+steps: 10
+batch_size: 4
+num_workers: 2
+optimizer:
+  name: sgd
+  momentum: 0.9
+  nesterov: true
+  weight_decay: 0.0005
+`))
+	if err != nil {
+		t.Fatalf("parseYAML: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	want := model.OptimizerConfig{Name: "sgd", Momentum: 0.9, Nesterov: true, WeightDecay: 0.0005}
+	if cfg.Optimizer != want {
+		t.Fatalf("unexpected optimizer: %+v", cfg.Optimizer)
+	}
+
+	cfg.ApplyOverrides(Overrides{Optimizer: "adamw"})
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "nesterov") {
+		t.Fatalf("expected nesterov to be rejected for adamw, got %v", err)
+	}
+	cfg.Optimizer.Nesterov = false
+	cfg.Optimizer.Beta2 = 1
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected error for beta2 = 1")
+	}
+
+	_, err = parseYAML(strings.NewReader("optimizer:\n  name: adam\n  gamma: 2\n"))
+	if err == nil || !strings.Contains(err.Error(), "unknown optimizer key gamma") {
+		t.Fatalf("expected unknown optimizer key error, got %v", err)
+	}
+}
+
+func TestLoadLeavesDefaultLRToFinalOptimizer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "forge.yaml")
+	yaml := "roots:\n  - name: cac\n    path: /wd/datasets-cac/train\nsteps: 10\nbatch_size: 4\nnum_workers: 2\noptimizer:\n  name: sgd\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	cfg.ApplyOverrides(Overrides{Optimizer: "adam"})
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	// The trainer picks adam's default, not the one sgd would have had.
+	if cfg.Optimizer.Name != "adam" || cfg.Optimizer.LR != 0 {
+		t.Fatalf("expected adam with no learning rate set, got %+v", cfg.Optimizer)
+	}
+}
+
+func TestParseYAMLLRSchedule(t *testing.T) {
+	cfg, err := parseYAML(strings.NewReader(`
+roots:
+  - name: cac
+    path: /wd/datasets-cac/train
+epochs: 2
+batch_size: 4
+num_workers: 2
+optimizer:
+  lr: 0.1
+lr_schedule:
+  name: cosine
+  warmup_steps: 50
+  total_steps: 1000
+  min_lr: 0.001
+`))
+	if err != nil {
+		t.Fatalf("parseYAML: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	want := ScheduleConfig{Name: "cosine", WarmupSteps: 50, TotalSteps: 1000, MinLR: 0.001}
+	if cfg.LRSchedule != want || cfg.Optimizer.LR != 0.1 {
+		t.Fatalf("unexpected schedule %+v, lr %g", cfg.LRSchedule, cfg.Optimizer.LR)
+	}
+
+	// An epochs-only run leaves the step count to the trainer's epoch plan.
+	cfg.LRSchedule.TotalSteps = 0
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate epochs-only cosine: %v", err)
+	}
+	cfg.ApplyOverrides(Overrides{LRSchedule: "step", LR: 0.2})
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "step_size") {
+		t.Fatalf("expected step_size error, got %v", err)
+	}
+	if cfg.Optimizer.LR != 0.2 {
+		t.Fatalf("expected lr override, got %g", cfg.Optimizer.LR)
+	}
+}
+
+func TestParseYAMLModelLayers(t *testing.T) {
+	cfg, err := parseYAML(strings.NewReader(`
+roots:
+  - name: cac
+    path: /wd/datasets-cac/train
+steps: 10
+batch_size: 4
+num_workers: 2
+model:
+  layers:
+    - type: conv2d
+      filters: 8
+      kernel: 3
+      padding: 1
+    - type: relu
+    - type: max_pool
+      size: 2
+    - type: flatten
+    - type: dense
+      units: 32
+`))
+	if err != nil {
+		t.Fatalf("parseYAML: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	layers := cfg.Model.Layers
+	if len(layers) != 5 || layers[0] != (LayerConfig{Type: "conv2d", Filters: 8, Kernel: 3, Padding: 1}) ||
+		layers[2].Size != 2 || layers[4].Units != 32 {
+		t.Fatalf("unexpected layers %+v", layers)
+	}
+
+	cfg.Model.Layers[4].Units = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "units") {
+		t.Fatalf("expected units error, got %v", err)
+	}
+	cfg.Model.Layers[4] = LayerConfig{Type: "lstm"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "type") {
+		t.Fatalf("expected type error, got %v", err)
+	}
+	if _, err := parseYAML(strings.NewReader("model:\n  layers:\n    - type: dense\n      width: 3\n")); err == nil {
+		t.Fatal("expected unknown layer key error")
+	}
+}
+
+func TestParseYAMLPreprocess(t *testing.T) {
+	cfg, err := parseYAML(strings.NewReader(`
+roots:
+  - name: cac
+    path: /wd/datasets-cac/train
+steps: 10
+batch_size: 4
+num_workers: 2
+preprocess:
+  mode: decode
+  workers: 6
+  prefetch: 4
+  mean: [0.5, 0.5, 0.5]
+  std: [0.25, 0.25, 0.25]
+  crop_scale: 0.8
+  flip: true
+  brightness: 0.2
+`))
+	if err != nil {
+		t.Fatalf("parseYAML: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	p := cfg.Preprocess
+	if p.Mode != "decode" || p.Workers != 6 || p.Prefetch != 4 || len(p.Mean) != 3 || p.Std[2] != 0.25 || p.CropScale != 0.8 || !p.Flip || p.Brightness != 0.2 {
+		t.Fatalf("unexpected preprocess config %+v", p)
+	}
+
+	cfg.ApplyOverrides(Overrides{Preprocess: "raw", DecodeWorkers: 2})
+	if cfg.Preprocess.Workers != 2 {
+		t.Fatalf("expected workers override, got %d", cfg.Preprocess.Workers)
+	}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "require mode decode") {
+		t.Fatalf("expected augmentation to be rejected in raw mode, got %v", err)
+	}
+	cfg.Preprocess = PreprocessConfig{Mode: "decode", Std: []float64{1, 0, 1}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "std") {
+		t.Fatalf("expected std error, got %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Launchers export a process's rank and world size under different names;
+// the first variable set in each list wins.
+var (
+	rankEnv      = []string{"RANK", "OMPI_COMM_WORLD_RANK", "PMI_RANK", "SLURM_PROCID"}
+	worldSizeEnv = []string{"WORLD_SIZE", "OMPI_COMM_WORLD_SIZE", "PMI_SIZE", "SLURM_NTASKS"}
+)
+
+// EnvOverrides reads the rank and world size set by common distributed
+// launchers (torchrun, mpirun, srun) through lookup, which is normally
+// os.LookupEnv. Unset variables leave the fields nil.
+func EnvOverrides(lookup func(string) (string, bool)) (Overrides, error) {
+	var o Overrides
+	var err error
+	if o.Rank, err = envInt(lookup, rankEnv); err != nil {
+		return Overrides{}, err
+	}
+	if o.WorldSize, err = envInt(lookup, worldSizeEnv); err != nil {
+		return Overrides{}, err
+	}
+	return o, nil
+}
+
+func envInt(lookup func(string) (string, bool), names []string) (*int, error) {
+	for _, name := range names {
+		raw, ok := lookup(name)
+		if !ok || raw == "" {
+			continue
+		}
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not an integer", name, raw)
+		}
+		return &v, nil
+	}
+	return nil, nil
+}
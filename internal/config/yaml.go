@@ -0,0 +1,319 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// node is a parsed block of the restricted YAML subset accepted by Load:
+// block mappings, block sequences, flow sequences ([a, b]) and scalars.
+type node struct {
+	line   int
+	kind   nodeKind
+	value  string
+	items  []*node
+	keys   []string
+	fields map[string]*node
+}
+
+type nodeKind int
+
+const (
+	scalarNode nodeKind = iota
+	mapNode
+	seqNode
+)
+
+type yamlLine struct {
+	no     int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func parseTree(r io.Reader) (*node, error) {
+	var lines []yamlLine
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := strings.TrimRight(scanner.Text(), " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", lineNo)
+		}
+		lines = append(lines, yamlLine{no: lineNo, indent: len(raw) - len(text), text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return &node{kind: mapNode, fields: map[string]*node{}}, nil
+	}
+	p := &yamlParser{lines: lines}
+	root, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].no)
+	}
+	if root.kind != mapNode {
+		return nil, fmt.Errorf("line %d: top level must be a mapping", root.line)
+	}
+	return root, nil
+}
+
+func (p *yamlParser) parseBlock() (*node, error) {
+	ln := p.lines[p.pos]
+	if isSeqItem(ln.text) {
+		return p.parseSeq(ln.indent)
+	}
+	return p.parseMap(ln.indent)
+}
+
+func (p *yamlParser) parseMap(indent int) (*node, error) {
+	n := &node{line: p.lines[p.pos].no, kind: mapNode, fields: map[string]*node{}}
+	for p.pos < len(p.lines) {
+		ln := p.lines[p.pos]
+		if ln.indent < indent {
+			break
+		}
+		if ln.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", ln.no)
+		}
+		if isSeqItem(ln.text) {
+			return nil, fmt.Errorf("line %d: unexpected list item", ln.no)
+		}
+		key, value, ok := splitKey(ln.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing ':'", ln.no)
+		}
+		if _, dup := n.fields[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %s", ln.no, key)
+		}
+		p.pos++
+		var child *node
+		switch {
+		case value != "":
+			var err error
+			child, err = parseInline(ln.no, value)
+			if err != nil {
+				return nil, err
+			}
+		case p.pos < len(p.lines) && (p.lines[p.pos].indent > indent ||
+			(p.lines[p.pos].indent == indent && isSeqItem(p.lines[p.pos].text))):
+			var err error
+			child, err = p.parseBlock()
+			if err != nil {
+				return nil, err
+			}
+		default:
+			child = &node{line: ln.no, kind: scalarNode}
+		}
+		n.keys = append(n.keys, key)
+		n.fields[key] = child
+	}
+	return n, nil
+}
+
+func (p *yamlParser) parseSeq(indent int) (*node, error) {
+	n := &node{line: p.lines[p.pos].no, kind: seqNode}
+	for p.pos < len(p.lines) {
+		ln := p.lines[p.pos]
+		if ln.indent < indent || (ln.indent == indent && !isSeqItem(ln.text)) {
+			break
+		}
+		if ln.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", ln.no)
+		}
+		rest := strings.TrimLeft(ln.text[1:], " ")
+		switch {
+		case rest == "":
+			p.pos++
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				child, err := p.parseBlock()
+				if err != nil {
+					return nil, err
+				}
+				n.items = append(n.items, child)
+			} else {
+				n.items = append(n.items, &node{line: ln.no, kind: scalarNode})
+			}
+		case isMapEntry(rest):
+			// Re-home "- key: value" as the first entry of a mapping that
+			// starts at the column after the dash.
+			col := indent + len(ln.text) - len(rest)
+			p.lines[p.pos] = yamlLine{no: ln.no, indent: col, text: rest}
+			child, err := p.parseMap(col)
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, child)
+		default:
+			child, err := parseInline(ln.no, rest)
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, child)
+			p.pos++
+		}
+	}
+	return n, nil
+}
+
+func parseInline(lineNo int, value string) (*node, error) {
+	if !strings.HasPrefix(value, "[") {
+		return &node{line: lineNo, kind: scalarNode, value: unquote(value)}, nil
+	}
+	if !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("line %d: unterminated flow sequence", lineNo)
+	}
+	n := &node{line: lineNo, kind: seqNode}
+	inner := strings.TrimSpace(value[1 : len(value)-1])
+	if inner == "" {
+		return n, nil
+	}
+	for _, item := range strings.Split(inner, ",") {
+		n.items = append(n.items, &node{line: lineNo, kind: scalarNode, value: unquote(strings.TrimSpace(item))})
+	}
+	return n, nil
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func isMapEntry(text string) bool {
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		return false
+	}
+	_, _, ok := splitKey(text)
+	return ok
+}
+
+// splitKey splits "key: value" on the first colon that is followed by a
+// space or ends the line, so values such as URLs keep their colons.
+func splitKey(text string) (string, string, bool) {
+	for i := 0; i < len(text); i++ {
+		if text[i] != ':' {
+			continue
+		}
+		if i+1 == len(text) || text[i+1] == ' ' {
+			key := strings.TrimSpace(text[:i])
+			if key == "" {
+				return "", "", false
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func unquote(value string) string {
+	return strings.Trim(value, "\"'")
+}
+
+func (n *node) str(key string) (string, error) {
+	if n.kind != scalarNode {
+		return "", fmt.Errorf("line %d: %s: expected a scalar", n.line, key)
+	}
+	return n.value, nil
+}
+
+func (n *node) intValue(key string) (int, error) {
+	s, err := n.str(key)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("line %d: %s: %w", n.line, key, err)
+	}
+	return v, nil
+}
+
+func (n *node) int64Value(key string) (int64, error) {
+	s, err := n.str(key)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("line %d: %s: %w", n.line, key, err)
+	}
+	return v, nil
+}
+
+func (n *node) floatValue(key string) (float64, error) {
+	s, err := n.str(key)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("line %d: %s: %w", n.line, key, err)
+	}
+	return v, nil
+}
+
+func (n *node) boolValue(key string) (bool, error) {
+	s, err := n.str(key)
+	if err != nil {
+		return false, err
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("line %d: %s: %w", n.line, key, err)
+	}
+	return v, nil
+}
+
+// stringList accepts either a sequence of scalars or a single scalar.
+func (n *node) stringList(key string) ([]string, error) {
+	if n.kind == scalarNode {
+		if n.value == "" {
+			return nil, nil
+		}
+		return []string{n.value}, nil
+	}
+	if n.kind != seqNode {
+		return nil, fmt.Errorf("line %d: %s: expected a list", n.line, key)
+	}
+	out := make([]string, 0, len(n.items))
+	for _, item := range n.items {
+		s, err := item.str(key)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (n *node) seq(key string) ([]*node, error) {
+	if n.kind == scalarNode && n.value == "" {
+		return nil, nil
+	}
+	if n.kind != seqNode {
+		return nil, fmt.Errorf("line %d: %s: expected a list", n.line, key)
+	}
+	return n.items, nil
+}
+
+func (n *node) mapping(key string) (*node, error) {
+	if n.kind != mapNode {
+		return nil, fmt.Errorf("line %d: %s: expected a mapping", n.line, key)
+	}
+	return n, nil
+}
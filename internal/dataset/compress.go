@@ -0,0 +1,45 @@
+package dataset
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"warpdrive-forge/internal/zstd"
+)
+
+// Codecs of compressed shards, as returned by Compression.
+const (
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+)
+
+// Compression returns the codec a shard is compressed with, judged by its
+// extension: CodecGzip for ".tar.gz", CodecZstd for ".tar.zst" and "" for a
+// plain TAR file.
+func Compression(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".tar.gz"):
+		return CodecGzip
+	case strings.HasSuffix(path, ".tar.zst"):
+		return CodecZstd
+	}
+	return ""
+}
+
+// decompress wraps r, the contents of a shard compressed with codec, in a
+// reader of the TAR stream.
+func decompress(codec string, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case CodecGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip: %w", err)
+		}
+		return zr, nil
+	case CodecZstd:
+		return io.NopCloser(zstd.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("unknown shard codec %q", codec)
+}
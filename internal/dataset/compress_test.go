@@ -0,0 +1,97 @@
+package dataset
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// zstdFrame wraps data in a Zstandard frame of raw blocks, which is all the
+// decoder needs to see to exercise the shard path.
+func zstdFrame(data []byte) []byte {
+	out := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x70} // magic, 16 MiB window
+	for {
+		n := min(len(data), 128<<10)
+		hdr := uint32(n) << 3
+		if n == len(data) {
+			hdr |= 1
+		}
+		out = append(out, byte(hdr), byte(hdr>>8), byte(hdr>>16))
+		out = append(out, data[:n]...)
+		data = data[n:]
+		if len(data) == 0 {
+			return out
+		}
+	}
+}
+
+func TestCompressedShardsMatchPlain(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "shard-000000.tar")
+	mustShard(t, plain, map[string]int{"a": 1, "b": 2, "c": 3})
+	data, err := os.ReadFile(plain)
+	if err != nil {
+		t.Fatalf("read shard: %v", err)
+	}
+	gz := &bytes.Buffer{}
+	zw := gzip.NewWriter(gz)
+	zw.Write(data)
+	zw.Close()
+	files := map[string][]byte{
+		filepath.Join(dir, "shard-000001.tar.gz"):  gz.Bytes(),
+		filepath.Join(dir, "shard-000002.tar.zst"): zstdFrame(data),
+		filepath.Join(dir, "shard-000003.tar.bz2"): data,
+	}
+	for path, contents := range files {
+		if err := os.WriteFile(path, contents, 0o644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+
+	shards, err := DiscoverShards(dir)
+	if err != nil || len(shards) != 3 || Compression(shards[1]) != CodecGzip || Compression(shards[2]) != CodecZstd {
+		t.Fatalf("DiscoverShards = %v, %v", shards, err)
+	}
+	stream := func(path string) ([]Sample, ShardStats) {
+		stats := &ShardStats{}
+		samples, errCh := streamShard(context.Background(), path, 0, stats, nil, nil, nil)
+		var got []Sample
+		for sample := range samples {
+			sample.Shard = ""
+			got = append(got, sample)
+		}
+		if err := <-errCh; err != nil {
+			t.Fatalf("stream %s: %v", path, err)
+		}
+		return got, *stats
+	}
+	want, plainStats := stream(plain)
+	if plainStats.Decompressed != 0 {
+		t.Fatalf("plain shard reported %d decompressed bytes", plainStats.Decompressed)
+	}
+	plainIndex, err := BuildIndex(plain)
+	if err != nil {
+		t.Fatalf("BuildIndex: %v", err)
+	}
+	for _, shard := range shards[1:] {
+		got, stats := stream(shard)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s streamed %+v, want %+v", shard, got, want)
+		}
+		if stats.Bytes != int64(len(files[shard])) || stats.Decompressed < int64(len(data))-2*512 {
+			t.Fatalf("%s: read %d and decompressed %d bytes of %d and %d", shard, stats.Bytes, stats.Decompressed, len(files[shard]), len(data))
+		}
+		ix, err := BuildIndex(shard)
+		if err != nil || !reflect.DeepEqual(ix.Members, plainIndex.Members) {
+			t.Fatalf("BuildIndex(%s) = %+v, %v", shard, ix, err)
+		}
+		if _, err := OpenIndexed(shard); !errors.Is(err, ErrCompressedShard) {
+			t.Fatalf("expected ErrCompressedShard, got %v", err)
+		}
+	}
+}
@@ -8,9 +8,10 @@ import (
     "sort"
 )
 
-var shardRegexp = regexp.MustCompile(`^shard-[0-9]{6,}\.tar$`)
+var shardRegexp = regexp.MustCompile(`^shard-[0-9]{6,}\.tar(\.gz|\.zst)?$`)
 
-// DiscoverShards returns absolute paths to shard TAR files beneath root.
+// DiscoverShards returns absolute paths to shard TAR files beneath root,
+// including gzip (.tar.gz) and zstd (.tar.zst) compressed ones.
 func DiscoverShards(root string) ([]string, error) {
     entries := make([]string, 0)
     err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
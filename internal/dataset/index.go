@@ -0,0 +1,363 @@
+package dataset
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// indexHeader starts every index sidecar, followed by a space and the size
+// of the shard the index was built from. Sidecars with an older header are
+// treated as stale.
+const indexHeader = "wdindex2"
+
+// invalidLabel is the optional fifth field of a sidecar line, marking a
+// label member whose payload does not parse.
+const invalidLabel = "invalid"
+
+var (
+	// ErrIndexStale indicates an index sidecar whose recorded shard size no
+	// longer matches the shard.
+	ErrIndexStale = errors.New("webdataset: index does not match shard")
+	// ErrNoSample indicates a key or ordinal that is not a complete sample
+	// of the shard.
+	ErrNoSample = errors.New("webdataset: no such sample")
+	// ErrCompressedShard indicates random access to a compressed shard.
+	ErrCompressedShard = errors.New("webdataset: no random access to a compressed shard")
+)
+
+// IndexEntry locates one member of a shard: Offset is the position of its
+// data within the TAR stream and Size its length in bytes. Invalid marks a
+// label member whose payload is not an integer; StreamShard never delivers
+// the sample it belongs to.
+type IndexEntry struct {
+	Key     string
+	Ext     string
+	Offset  int64
+	Size    int64
+	Invalid bool
+}
+
+// ShardIndex lists the members of a shard in file order. Samples are
+// numbered in the order StreamShard delivers them, so ordinal n is the
+// sample a resumed stream would produce after skipping n.
+type ShardIndex struct {
+	// ShardSize is the size of the shard the index was built from.
+	ShardSize int64
+	Members   []IndexEntry
+
+	// samples holds, per ordinal, the member indexes of the sample's image
+	// and label; byKey maps keys to ordinals.
+	samples [][2]int
+	byKey   map[string]int
+}
+
+// IndexPath returns the path of the index sidecar for shard.
+func IndexPath(shard string) string {
+	return shard + ".idx"
+}
+
+// BuildIndex reads the TAR headers of the shard at path. Member data other
+// than labels is skipped with Seek, so little more than the headers is
+// read, except in a compressed shard, which is decompressed in full and
+// whose offsets are positions in the TAR stream.
+func BuildIndex(path string) (*ShardIndex, error) {
+	return buildIndex(path, nil)
+}
+
+// buildIndex is BuildIndex, but when h is set the shard, which must be
+// uncompressed, is read through rather than seeked over and every byte of
+// it is written to h.
+func buildIndex(path string, h io.Writer) (*ShardIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open shard: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat shard: %w", err)
+	}
+	ix := &ShardIndex{ShardSize: info.Size()}
+	tr := tar.NewReader(f)
+	position := func() (int64, error) { return f.Seek(0, io.SeekCurrent) }
+	var hashed io.Reader
+	if h != nil {
+		counter := &countingReader{r: io.TeeReader(f, h)}
+		hashed = counter
+		tr = tar.NewReader(counter)
+		position = func() (int64, error) { return counter.bytes, nil }
+	} else if codec := Compression(path); codec != "" {
+		dec, err := decompress(codec, bufio.NewReader(f))
+		if err != nil {
+			return nil, fmt.Errorf("read shard: %w", err)
+		}
+		defer dec.Close()
+		counter := &countingReader{r: dec}
+		tr = tar.NewReader(counter)
+		position = func() (int64, error) { return counter.bytes, nil }
+	}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read tar: %w", err)
+		}
+		if hdr.FileInfo().IsDir() {
+			continue
+		}
+		offset, err := position()
+		if err != nil {
+			return nil, fmt.Errorf("seek shard: %w", err)
+		}
+		name := filepath.Base(hdr.Name)
+		ext := strings.ToLower(filepath.Ext(name))
+		m := IndexEntry{Key: strings.TrimSuffix(name, ext), Ext: ext, Offset: offset, Size: hdr.Size}
+		if ext == ".cls" {
+			payload, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("read label %s: %w", name, err)
+			}
+			_, err = parseLabel(payload)
+			m.Invalid = err != nil
+		}
+		ix.Members = append(ix.Members, m)
+	}
+	if hashed != nil {
+		// Hash the padding after the end of the archive too.
+		if _, err := io.Copy(io.Discard, hashed); err != nil {
+			return nil, fmt.Errorf("read shard: %w", err)
+		}
+	}
+	ix.resolve()
+	return ix, nil
+}
+
+// WriteIndex writes ix to the sidecar of shard, replacing it atomically.
+// The sidecar holds a header line with the shard size and one
+// "key<TAB>ext<TAB>offset<TAB>size" line per member, followed by
+// "<TAB>invalid" for a label that does not parse.
+func WriteIndex(shard string, ix *ShardIndex) error {
+	path := IndexPath(shard)
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	fmt.Fprintf(w, "%s %d\n", indexHeader, ix.ShardSize)
+	for _, m := range ix.Members {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d", m.Key, m.Ext, m.Offset, m.Size)
+		if m.Invalid {
+			fmt.Fprintf(w, "\t%s", invalidLabel)
+		}
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close index: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename index: %w", err)
+	}
+	return nil
+}
+
+// LoadIndex reads the sidecar of shard. It returns ErrIndexStale when the
+// shard's size differs from the one the index was built from, or when the
+// sidecar was written in an older format.
+func LoadIndex(shard string) (*ShardIndex, error) {
+	f, err := os.Open(IndexPath(shard))
+	if err != nil {
+		return nil, fmt.Errorf("open index: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	ix := &ShardIndex{}
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("read index %s: missing header", IndexPath(shard))
+	}
+	header, size, _ := strings.Cut(scanner.Text(), " ")
+	if header == "wdindex1" {
+		return nil, fmt.Errorf("%w: %s has the older %s format", ErrIndexStale, IndexPath(shard), header)
+	}
+	if header != indexHeader {
+		return nil, fmt.Errorf("read index %s: unsupported header %q", IndexPath(shard), header)
+	}
+	if ix.ShardSize, err = strconv.ParseInt(size, 10, 64); err != nil {
+		return nil, fmt.Errorf("read index %s: shard size: %w", IndexPath(shard), err)
+	}
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) == 5 && fields[4] != invalidLabel || len(fields) != 4 && len(fields) != 5 {
+			return nil, fmt.Errorf("read index %s: line %d: want 4 fields and an optional %q, got %q", IndexPath(shard), line, invalidLabel, scanner.Text())
+		}
+		m := IndexEntry{Key: fields[0], Ext: fields[1], Invalid: len(fields) == 5}
+		if m.Offset, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("read index %s: line %d: offset: %w", IndexPath(shard), line, err)
+		}
+		if m.Size, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+			return nil, fmt.Errorf("read index %s: line %d: size: %w", IndexPath(shard), line, err)
+		}
+		ix.Members = append(ix.Members, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+	info, err := os.Stat(shard)
+	if err != nil {
+		return nil, fmt.Errorf("stat shard: %w", err)
+	}
+	if info.Size() != ix.ShardSize {
+		return nil, fmt.Errorf("%w: %s was %d bytes, now %d", ErrIndexStale, shard, ix.ShardSize, info.Size())
+	}
+	ix.resolve()
+	return ix, nil
+}
+
+// resolve pairs images with labels with the rules of readShard under
+// sample skipping: a sample is complete at the member that supplies its
+// second half, a later image or label replaces an earlier one, an empty
+// image does not count, and a key whose label does not parse is dropped
+// for the rest of the shard. A key completed again later is a new sample;
+// byKey keeps its first ordinal.
+func (ix *ShardIndex) resolve() {
+	ix.samples = nil
+	ix.byKey = make(map[string]int)
+	type halves struct{ image, label int }
+	pending := make(map[string]*halves)
+	dropped := make(map[string]bool)
+	for i, m := range ix.Members {
+		if dropped[m.Key] {
+			continue
+		}
+		part := pending[m.Key]
+		if part == nil {
+			part = &halves{image: -1, label: -1}
+		}
+		switch m.Ext {
+		case ".jpg", ".jpeg", ".png":
+			part.image = i
+		case ".cls":
+			if m.Invalid {
+				delete(pending, m.Key)
+				dropped[m.Key] = true
+				continue
+			}
+			part.label = i
+		default:
+			continue
+		}
+		pending[m.Key] = part
+		if part.image >= 0 && ix.Members[part.image].Size > 0 && part.label >= 0 {
+			if _, seen := ix.byKey[m.Key]; !seen {
+				ix.byKey[m.Key] = len(ix.samples)
+			}
+			ix.samples = append(ix.samples, [2]int{part.image, part.label})
+			delete(pending, m.Key)
+		}
+	}
+}
+
+// Len returns the number of complete samples in the shard.
+func (ix *ShardIndex) Len() int {
+	return len(ix.samples)
+}
+
+// Key returns the key of sample n.
+func (ix *ShardIndex) Key(n int) string {
+	return ix.Members[ix.samples[n][0]].Key
+}
+
+// IndexedShard reads individual samples of a shard with ReadAt.
+type IndexedShard struct {
+	path  string
+	f     *os.File
+	index *ShardIndex
+}
+
+// OpenIndexed opens the shard at path with its sidecar index. A missing or
+// stale sidecar is rebuilt in memory from the TAR headers but not written.
+// Compressed shards cannot be read at an offset and return
+// ErrCompressedShard.
+func OpenIndexed(path string) (*IndexedShard, error) {
+	if codec := Compression(path); codec != "" {
+		return nil, fmt.Errorf("%w: %s is %s compressed", ErrCompressedShard, path, codec)
+	}
+	ix, err := LoadIndex(path)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrIndexStale) {
+		ix, err = BuildIndex(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open shard: %w", err)
+	}
+	return &IndexedShard{path: path, f: f, index: ix}, nil
+}
+
+// Index returns the shard's index.
+func (s *IndexedShard) Index() *ShardIndex {
+	return s.index
+}
+
+// SampleAt reads sample n, counted in StreamShard order.
+func (s *IndexedShard) SampleAt(n int) (Sample, error) {
+	if n < 0 || n >= s.index.Len() {
+		return Sample{}, fmt.Errorf("%w: %s has %d samples, want ordinal %d", ErrNoSample, s.path, s.index.Len(), n)
+	}
+	return s.read(s.index.samples[n])
+}
+
+// SampleByKey reads the sample with key.
+func (s *IndexedShard) SampleByKey(key string) (Sample, error) {
+	n, ok := s.index.byKey[key]
+	if !ok {
+		return Sample{}, fmt.Errorf("%w: %s has no sample %s", ErrNoSample, s.path, key)
+	}
+	return s.read(s.index.samples[n])
+}
+
+func (s *IndexedShard) read(members [2]int) (Sample, error) {
+	image, err := s.member(s.index.Members[members[0]])
+	if err != nil {
+		return Sample{}, err
+	}
+	labelEntry := s.index.Members[members[1]]
+	payload, err := s.member(labelEntry)
+	if err != nil {
+		return Sample{}, err
+	}
+	label, err := parseLabel(payload)
+	if err != nil {
+		return Sample{}, fmt.Errorf("parse label %s%s: %w", labelEntry.Key, labelEntry.Ext, err)
+	}
+	return Sample{Key: labelEntry.Key, Image: image, Label: label, Shard: s.path}, nil
+}
+
+func (s *IndexedShard) member(m IndexEntry) ([]byte, error) {
+	data := make([]byte, m.Size)
+	if _, err := s.f.ReadAt(data, m.Offset); err != nil {
+		return nil, fmt.Errorf("read %s%s at %d: %w", m.Key, m.Ext, m.Offset, err)
+	}
+	return data, nil
+}
+
+// Close closes the shard file.
+func (s *IndexedShard) Close() error {
+	return s.f.Close()
+}
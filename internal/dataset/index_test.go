@@ -0,0 +1,199 @@
+package dataset
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeOrderedShard(t *testing.T) string {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	addTarEntry(tw, "b.cls", []byte("2"))
+	addTarEntry(tw, "a.jpg", bytes.Repeat([]byte{0xa}, 700))
+	addTarEntry(tw, "a.json", []byte(`{}`))
+	addTarEntry(tw, "b.png", []byte("bee"))
+	addTarEntry(tw, "c.jpg", []byte("sea"))
+	addTarEntry(tw, "a.cls", []byte(" 1\n"))
+	addTarEntry(tw, "d.cls", []byte("4"))
+	tw.Close()
+	shard := filepath.Join(t.TempDir(), "shard-000000.tar")
+	if err := os.WriteFile(shard, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("write shard: %v", err)
+	}
+	return shard
+}
+
+// writeIrregularShard writes a shard exercising every pairing rule of
+// readShard: a key completed twice, a label that does not parse, an empty
+// image replaced later, an unknown extension and an image without a label.
+// Streamed with sample skipping it yields a/1, c/3, a/5 and e/7.
+func writeIrregularShard(t *testing.T, path string) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	addTarEntry(tw, "a.jpg", []byte("a1"))
+	addTarEntry(tw, "a.cls", []byte("1"))
+	addTarEntry(tw, "b.cls", []byte("x"))
+	addTarEntry(tw, "b.jpg", []byte("b"))
+	addTarEntry(tw, "c.jpg", nil)
+	addTarEntry(tw, "c.cls", []byte("3"))
+	addTarEntry(tw, "c.jpg", []byte("c"))
+	addTarEntry(tw, "a.jpg", []byte("a2"))
+	addTarEntry(tw, "d.json", []byte("{}"))
+	addTarEntry(tw, "d.jpg", []byte("d"))
+	addTarEntry(tw, "a.cls", []byte("5"))
+	addTarEntry(tw, "e.png", []byte("e"))
+	addTarEntry(tw, "e.cls", []byte(" 7\n"))
+	tw.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("write shard: %v", err)
+	}
+}
+
+// streamSkipping streams a shard the way the sampler does under
+// PolicySkipSample.
+func streamSkipping(t *testing.T, path string) []Sample {
+	t.Helper()
+	samples, errCh := streamShard(context.Background(), path, 0, nil, nil, func(string, error) error { return nil }, nil)
+	var out []Sample
+	for sample := range samples {
+		out = append(out, sample)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("stream %s: %v", path, err)
+	}
+	return out
+}
+
+func TestIndexOrdinalsFollowStream(t *testing.T) {
+	shard := filepath.Join(t.TempDir(), "shard-000000.tar")
+	writeIrregularShard(t, shard)
+	streamed := streamSkipping(t, shard)
+	if len(streamed) != 4 {
+		t.Fatalf("streamed %d samples, want 4", len(streamed))
+	}
+	ix, err := BuildIndex(shard)
+	if err != nil {
+		t.Fatalf("BuildIndex: %v", err)
+	}
+	if err := WriteIndex(shard, ix); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	s, err := OpenIndexed(shard)
+	if err != nil {
+		t.Fatalf("OpenIndexed: %v", err)
+	}
+	defer s.Close()
+	if s.Index().Len() != len(streamed) {
+		t.Fatalf("index has %d samples, stream delivered %d", s.Index().Len(), len(streamed))
+	}
+	for n, want := range streamed {
+		got, err := s.SampleAt(n)
+		if err != nil || got.Key != want.Key || got.Label != want.Label || !bytes.Equal(got.Image, want.Image) {
+			t.Fatalf("SampleAt(%d) = %s/%d/%q, %v; streamed %s/%d/%q", n, got.Key, got.Label, got.Image, err, want.Key, want.Label, want.Image)
+		}
+	}
+	if got, err := s.SampleByKey("a"); err != nil || got.Label != 1 {
+		t.Fatalf("SampleByKey(a) = %d, %v; want the first a", got.Label, err)
+	}
+	if _, err := s.SampleByKey("b"); !errors.Is(err, ErrNoSample) {
+		t.Fatalf("expected ErrNoSample for the unparsable label, got %v", err)
+	}
+}
+
+func TestIndexedShardMatchesStream(t *testing.T) {
+	shard := writeOrderedShard(t)
+	ix, err := BuildIndex(shard)
+	if err != nil {
+		t.Fatalf("BuildIndex: %v", err)
+	}
+	if len(ix.Members) != 7 || ix.Len() != 2 {
+		t.Fatalf("got %d members and %d samples, want 7 and 2", len(ix.Members), ix.Len())
+	}
+	if err := WriteIndex(shard, ix); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	loaded, err := LoadIndex(shard)
+	if err != nil {
+		t.Fatalf("LoadIndex: %v", err)
+	}
+	for i, m := range loaded.Members {
+		if m != ix.Members[i] {
+			t.Fatalf("member %d round-tripped as %+v, want %+v", i, m, ix.Members[i])
+		}
+	}
+
+	samplesCh, errCh := StreamShard(context.Background(), shard, 4)
+	var streamed []Sample
+	for sample := range samplesCh {
+		streamed = append(streamed, sample)
+	}
+	if err := <-errCh; err == nil {
+		t.Fatal("expected the stream to report the incomplete samples")
+	}
+
+	s, err := OpenIndexed(shard)
+	if err != nil {
+		t.Fatalf("OpenIndexed: %v", err)
+	}
+	defer s.Close()
+	for n, want := range streamed {
+		got, err := s.SampleAt(n)
+		if err != nil {
+			t.Fatalf("SampleAt(%d): %v", n, err)
+		}
+		if got.Key != want.Key || got.Label != want.Label || !bytes.Equal(got.Image, want.Image) || s.Index().Key(n) != want.Key {
+			t.Fatalf("sample %d: got %s/%d, streamed %s/%d", n, got.Key, got.Label, want.Key, want.Label)
+		}
+	}
+	got, err := s.SampleByKey("a")
+	if err != nil || got.Label != 1 || len(got.Image) != 700 {
+		t.Fatalf("SampleByKey(a) = %s/%d/%d bytes, %v", got.Key, got.Label, len(got.Image), err)
+	}
+	for _, err := range []error{errOf(s.SampleByKey("c")), errOf(s.SampleAt(2)), errOf(s.SampleAt(-1))} {
+		if !errors.Is(err, ErrNoSample) {
+			t.Fatalf("expected ErrNoSample, got %v", err)
+		}
+	}
+}
+
+func TestLoadIndexDetectsStaleSidecar(t *testing.T) {
+	shard := writeOrderedShard(t)
+	ix, err := BuildIndex(shard)
+	if err != nil {
+		t.Fatalf("BuildIndex: %v", err)
+	}
+	if err := WriteIndex(shard, ix); err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+	f, err := os.OpenFile(shard, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open shard: %v", err)
+	}
+	f.Write(make([]byte, 512))
+	f.Close()
+	if _, err := LoadIndex(shard); !errors.Is(err, ErrIndexStale) {
+		t.Fatalf("expected ErrIndexStale, got %v", err)
+	}
+	s, err := OpenIndexed(shard)
+	if err != nil {
+		t.Fatalf("OpenIndexed should rebuild a stale index: %v", err)
+	}
+	defer s.Close()
+	if sample, err := s.SampleByKey("b"); err != nil || string(sample.Image) != "bee" {
+		t.Fatalf("SampleByKey(b) = %q, %v", sample.Image, err)
+	}
+}
+
+func errOf(_ Sample, err error) error {
+	return err
+}
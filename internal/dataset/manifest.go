@@ -0,0 +1,280 @@
+package dataset
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestName is the manifest looked for in a root when none is
+// configured.
+const ManifestName = "manifest.jsonl"
+
+// ManifestEntry describes one shard of a root. Path is relative to the root
+// unless absolute. Samples counts the shard's complete samples; 0 means the
+// count is unknown. SHA256 and CRC32C are hex digests of the whole file.
+type ManifestEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Samples int64  `json:"samples"`
+	SHA256  string `json:"sha256,omitempty"`
+	CRC32C  string `json:"crc32c,omitempty"`
+}
+
+// Manifest lists the shards of a root so they can be found without walking
+// its directory tree. A ".json" manifest is an object with a "shards" array;
+// any other is JSON Lines, one ManifestEntry per line.
+type Manifest struct {
+	Shards []ManifestEntry `json:"shards"`
+}
+
+// LoadManifest reads the manifest at path.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open manifest: %w", err)
+	}
+	m := &Manifest{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, fmt.Errorf("read manifest %s: %w", path, err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, 1<<20)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			var entry ManifestEntry
+			if err := json.Unmarshal([]byte(text), &entry); err != nil {
+				return nil, fmt.Errorf("read manifest %s: line %d: %w", path, line, err)
+			}
+			m.Shards = append(m.Shards, entry)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("read manifest %s: %w", path, err)
+		}
+	}
+	for i, entry := range m.Shards {
+		if entry.Path == "" {
+			return nil, fmt.Errorf("read manifest %s: shard %d has no path", path, i)
+		}
+		if !isHexDigest(entry.SHA256, sha256.Size) || !isHexDigest(entry.CRC32C, crc32.Size) {
+			return nil, fmt.Errorf("read manifest %s: shard %s has a malformed checksum", path, entry.Path)
+		}
+	}
+	return m, nil
+}
+
+// WriteManifest writes m to path in the format its extension selects,
+// replacing any existing file atomically.
+func WriteManifest(path string, m *Manifest) error {
+	buf := &bytes.Buffer{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		enc := json.NewEncoder(buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(m); err != nil {
+			return fmt.Errorf("encode manifest: %w", err)
+		}
+	} else {
+		enc := json.NewEncoder(buf)
+		for _, entry := range m.Shards {
+			if err := enc.Encode(entry); err != nil {
+				return fmt.Errorf("encode manifest: %w", err)
+			}
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close manifest: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename manifest: %w", err)
+	}
+	return nil
+}
+
+// ShardPaths returns the manifest's shards resolved against root, sorted
+// the way DiscoverShards sorts them, so switching a root to a manifest does
+// not change the sample order.
+func (m *Manifest) ShardPaths(root string) []string {
+	paths := make([]string, 0, len(m.Shards))
+	for _, entry := range m.Shards {
+		paths = append(paths, m.resolve(root, entry))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// SampleCounts returns the sample count of every shard with a known one,
+// keyed by the path ShardPaths returns for it.
+func (m *Manifest) SampleCounts(root string) map[string]int64 {
+	counts := make(map[string]int64, len(m.Shards))
+	for _, entry := range m.Shards {
+		if entry.Samples > 0 {
+			counts[m.resolve(root, entry)] = entry.Samples
+		}
+	}
+	return counts
+}
+
+// Checksums returns the expected digest of every shard with one, keyed by
+// the path ShardPaths returns for it. CRC32C is preferred when an entry has
+// both: it is far cheaper to compute and catches a stale or truncated
+// object just as well.
+func (m *Manifest) Checksums(root string) map[string]Checksum {
+	sums := make(map[string]Checksum, len(m.Shards))
+	for _, entry := range m.Shards {
+		switch {
+		case entry.CRC32C != "":
+			sums[m.resolve(root, entry)] = Checksum{Algorithm: "crc32c", Hex: entry.CRC32C}
+		case entry.SHA256 != "":
+			sums[m.resolve(root, entry)] = Checksum{Algorithm: "sha256", Hex: entry.SHA256}
+		}
+	}
+	return sums
+}
+
+func (m *Manifest) resolve(root string, entry ManifestEntry) string {
+	if filepath.IsAbs(entry.Path) {
+		return filepath.Clean(entry.Path)
+	}
+	return filepath.Join(root, filepath.FromSlash(entry.Path))
+}
+
+// DiscoverRoot lists the shards of root from a manifest when there is one,
+// and otherwise by walking it like DiscoverMatching. manifest names the
+// file to use; when empty, ManifestName inside root is used if present. The
+// manifest is nil when the root was walked. filter, when set, also applies
+// to the manifest's entries, which are dropped from the returned manifest.
+func DiscoverRoot(root, manifest string, filter *ShardFilter) ([]string, *Manifest, error) {
+	if manifest == "" {
+		candidate := filepath.Join(root, ManifestName)
+		if _, err := os.Stat(candidate); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, nil, fmt.Errorf("discover shards: %w", err)
+			}
+			shards, err := DiscoverMatching(root, filter)
+			return shards, nil, err
+		}
+		manifest = candidate
+	}
+	m, err := LoadManifest(manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if filter != nil {
+		kept := m.Shards[:0]
+		for _, entry := range m.Shards {
+			rel := entry.Path
+			if filepath.IsAbs(entry.Path) {
+				if r, err := filepath.Rel(root, entry.Path); err == nil {
+					rel = filepath.ToSlash(r)
+				}
+			}
+			if filter.Match(path.Clean(rel)) {
+				kept = append(kept, entry)
+			}
+		}
+		m.Shards = kept
+	}
+	return m.ShardPaths(root), m, nil
+}
+
+// BuildManifest walks root and describes each of the shards filter selects
+// (see DiscoverMatching) from a single read of each: the sample count
+// matches what StreamShard delivers when bad samples are skipped, and the
+// checksums cover the whole file. Paths are relative to root. With
+// writeIndexes each uncompressed shard's index sidecar is written as well.
+// onShard, when set, is called after each shard.
+func BuildManifest(root string, filter *ShardFilter, writeIndexes bool, onShard func(ManifestEntry)) (*Manifest, error) {
+	shards, err := DiscoverMatching(root, filter)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	for _, shard := range shards {
+		entry, ix, err := describeShard(shard)
+		if err != nil {
+			return nil, err
+		}
+		if writeIndexes && ix != nil {
+			if err := WriteIndex(shard, ix); err != nil {
+				return nil, err
+			}
+		}
+		rel, err := filepath.Rel(root, shard)
+		if err != nil {
+			return nil, fmt.Errorf("manifest path: %w", err)
+		}
+		entry.Path = filepath.ToSlash(rel)
+		m.Shards = append(m.Shards, entry)
+		if onShard != nil {
+			onShard(entry)
+		}
+	}
+	return m, nil
+}
+
+// describeShard counts the samples of the shard at path and digests it in
+// one read. An uncompressed shard is indexed on the way and its index
+// returned; a compressed one, which cannot be read at an offset, is
+// streamed instead and its index is nil.
+func describeShard(path string) (ManifestEntry, *ShardIndex, error) {
+	sha, crc := sha256.New(), crc32.New(castagnoli)
+	h := io.MultiWriter(sha, crc)
+	var entry ManifestEntry
+	var ix *ShardIndex
+	if Compression(path) == "" {
+		var err error
+		if ix, err = buildIndex(path, h); err != nil {
+			return entry, nil, fmt.Errorf("index %s: %w", path, err)
+		}
+		entry.Size, entry.Samples = ix.ShardSize, int64(ix.Len())
+	} else {
+		stats := &ShardStats{}
+		skip := func(string, error) error { return nil }
+		count := func(Sample) error {
+			entry.Samples++
+			return nil
+		}
+		if err := readShard(context.Background(), path, defaultPendingCap, stats, nil, skip, h, count); err != nil {
+			return entry, nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		entry.Size = stats.Bytes
+	}
+	entry.SHA256, entry.CRC32C = hex.EncodeToString(sha.Sum(nil)), hex.EncodeToString(crc.Sum(nil))
+	return entry, ix, nil
+}
+
+// isHexDigest reports whether s is empty or size bytes in hex.
+func isHexDigest(s string, size int) bool {
+	if s == "" {
+		return true
+	}
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == size
+}
@@ -0,0 +1,144 @@
+package dataset
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeShardFile(t *testing.T, path string, samples int) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for i := 0; i < samples; i++ {
+		key := filepath.Base(path) + "-" + string(rune('a'+i))
+		addTarEntry(tw, key+".jpg", []byte("img"))
+		addTarEntry(tw, key+".cls", []byte("1"))
+	}
+	tw.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("write shard: %v", err)
+	}
+}
+
+func TestManifestMatchesDiscovery(t *testing.T) {
+	root := t.TempDir()
+	writeShardFile(t, filepath.Join(root, "b", "shard-000001.tar"), 3)
+	writeShardFile(t, filepath.Join(root, "a", "shard-000000.tar"), 2)
+
+	walked, m, err := DiscoverRoot(root, "", nil)
+	if err != nil || m != nil {
+		t.Fatalf("DiscoverRoot without a manifest = %v, %v", m, err)
+	}
+	built, err := BuildManifest(root, nil, true, nil)
+	if err != nil {
+		t.Fatalf("BuildManifest: %v", err)
+	}
+	if len(built.Shards) != 2 || built.Shards[0].Path != "a/shard-000000.tar" || built.Shards[0].Samples != 2 || built.Shards[1].Samples != 3 || len(built.Shards[0].SHA256) != 64 {
+		t.Fatalf("unexpected manifest: %+v", built.Shards)
+	}
+	if _, err := LoadIndex(walked[0]); err != nil {
+		t.Fatalf("expected an index sidecar: %v", err)
+	}
+
+	for _, name := range []string{filepath.Join(root, ManifestName), filepath.Join(t.TempDir(), "elsewhere.json")} {
+		if err := WriteManifest(name, built); err != nil {
+			t.Fatalf("WriteManifest(%s): %v", name, err)
+		}
+		loaded, err := LoadManifest(name)
+		if err != nil {
+			t.Fatalf("LoadManifest(%s): %v", name, err)
+		}
+		if !reflect.DeepEqual(loaded, built) {
+			t.Fatalf("%s round-tripped as %+v", name, loaded.Shards)
+		}
+	}
+
+	shards, m, err := DiscoverRoot(root, "", nil)
+	if err != nil || m == nil {
+		t.Fatalf("DiscoverRoot should find %s: %v", ManifestName, err)
+	}
+	if !reflect.DeepEqual(shards, walked) {
+		t.Fatalf("manifest shards %v, walked %v", shards, walked)
+	}
+	if counts := m.SampleCounts(root); counts[walked[0]] != 2 || counts[walked[1]] != 3 {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+	if sum := m.Checksums(root)[walked[0]]; sum.Algorithm != "crc32c" || sum.Hex != built.Shards[0].CRC32C || len(sum.Hex) != 8 {
+		t.Fatalf("expected the CRC32C to be preferred, got %+v", sum)
+	}
+}
+
+func TestManifestSamplesMatchStream(t *testing.T) {
+	root := t.TempDir()
+	irregular := filepath.Join(root, "shard-000000.tar")
+	writeIrregularShard(t, irregular)
+	writeShardFile(t, filepath.Join(root, "shard-000001.tar"), 3)
+	data, err := os.ReadFile(irregular)
+	if err != nil {
+		t.Fatalf("read shard: %v", err)
+	}
+	compressed := filepath.Join(root, "shard-000002.tar.zst")
+	if err := os.WriteFile(compressed, zstdFrame(data), 0o644); err != nil {
+		t.Fatalf("write shard: %v", err)
+	}
+
+	built, err := BuildManifest(root, nil, true, nil)
+	if err != nil {
+		t.Fatalf("BuildManifest: %v", err)
+	}
+	counts := built.SampleCounts(root)
+	sums := built.Checksums(root)
+	for i, shard := range built.ShardPaths(root) {
+		if streamed := int64(len(streamSkipping(t, shard))); counts[shard] != streamed {
+			t.Fatalf("manifest counts %d samples in %s, the stream delivers %d", counts[shard], shard, streamed)
+		}
+		sha, crc := fileSums(t, shard)
+		info, _ := os.Stat(shard)
+		if entry := built.Shards[i]; entry.SHA256 != sha || entry.CRC32C != crc || entry.Size != info.Size() {
+			t.Fatalf("manifest describes %s as %+v, want sha256 %s, crc32c %s and %d bytes", shard, entry, sha, crc, info.Size())
+		}
+		if sums[shard].Hex != crc {
+			t.Fatalf("expected the crc32c of %s, got %+v", shard, sums[shard])
+		}
+	}
+	if _, err := os.Stat(IndexPath(compressed)); err == nil {
+		t.Fatal("wrote an index sidecar for a compressed shard")
+	}
+	if _, err := LoadIndex(irregular); err != nil {
+		t.Fatalf("LoadIndex: %v", err)
+	}
+}
+
+func TestLoadManifestJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	data := "# generated by hand\n{\"path\": \"/abs/shard-000002.tar\"}\n\n{\"path\": \"x/shard-000001.tar\", \"samples\": 7}\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+	if got := m.ShardPaths("/wd/root"); !reflect.DeepEqual(got, []string{"/abs/shard-000002.tar", "/wd/root/x/shard-000001.tar"}) {
+		t.Fatalf("unexpected paths: %v", got)
+	}
+	if counts := m.SampleCounts("/wd/root"); len(counts) != 1 || counts["/wd/root/x/shard-000001.tar"] != 7 {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+
+	for _, bad := range []string{`{"samples": 3}`, `{"path": "a.tar", "crc32c": "abc"}`} {
+		if err := os.WriteFile(path, []byte(bad+"\n"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, err := LoadManifest(path); err == nil {
+			t.Fatalf("expected an error for %s", bad)
+		}
+	}
+}
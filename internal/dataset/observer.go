@@ -0,0 +1,55 @@
+package dataset
+
+import "time"
+
+// Observer receives sampler pipeline events for instrumentation. Methods
+// are called from worker and aggregator goroutines and must be safe for
+// concurrent use.
+type Observer interface {
+	// ShardOpened reports how long opening a shard file took.
+	ShardOpened(root, path string, latency time.Duration)
+	// SampleDelivered is called for each sample handed to the consumer.
+	SampleDelivered(root string)
+	// ShardDone reports the I/O for one pass over a shard once it has been
+	// fully consumed; err is non-nil when the shard ended with an error.
+	ShardDone(root, path string, stats ShardStats, err error)
+	// ShardSkipped is called after ShardDone when the error policy drops a
+	// failing shard instead of ending the stream.
+	ShardSkipped(root, path string, err error)
+	// SampleSkipped reports a sample dropped under PolicySkipSample.
+	SampleSkipped(root, path, key string, err error)
+	// ChecksumFailed reports a read of a shard that did not match its
+	// checksum; a shard that is re-read reports each failed read.
+	ChecksumFailed(root, path string, err error)
+}
+
+// ShardStats describes the I/O performed for one pass over a shard.
+type ShardStats struct {
+	// Bytes is the number of bytes read from the shard file.
+	Bytes int64
+	// Samples is the number of samples delivered to the consumer.
+	Samples int64
+	// Open is the time taken to open the file.
+	Open time.Duration
+	// TTFB is the time from the start of the open to the first byte read.
+	TTFB time.Duration
+	// ReadTime is the time spent inside file reads, excluding time blocked
+	// on a slow consumer.
+	ReadTime time.Duration
+	// Decompressed is the size of the TAR stream produced from a
+	// compressed shard, whose file size is counted in Bytes; it is zero for
+	// plain TAR shards.
+	Decompressed int64
+	// DecompressTime is the time spent decompressing, excluding the file
+	// reads in ReadTime.
+	DecompressTime time.Duration
+}
+
+type nopObserver struct{}
+
+func (nopObserver) ShardOpened(string, string, time.Duration)   {}
+func (nopObserver) SampleDelivered(string)                      {}
+func (nopObserver) ShardDone(string, string, ShardStats, error) {}
+func (nopObserver) ShardSkipped(string, string, error)          {}
+func (nopObserver) SampleSkipped(string, string, string, error) {}
+func (nopObserver) ChecksumFailed(string, string, error)        {}
@@ -0,0 +1,195 @@
+package dataset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Ordering decides how the sampler interleaves samples of shards that are
+// read concurrently.
+type Ordering int
+
+const (
+	// OrderStrict drains shards one at a time in job order. The stream is a
+	// pure function of the seed, but a slow shard holds back every shard
+	// queued behind it, even those other workers have already opened.
+	OrderStrict Ordering = iota
+	// OrderRelaxed emits samples from whichever open shard has one ready.
+	// SamplerOptions.ReorderWindow bounds how far ahead of the oldest
+	// unfinished shard the sampler may read.
+	OrderRelaxed
+)
+
+// ParseOrdering maps an ordering value to an Ordering.
+func ParseOrdering(s string) (Ordering, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "strict":
+		return OrderStrict, nil
+	case "relaxed":
+		return OrderRelaxed, nil
+	}
+	return OrderStrict, fmt.Errorf("unknown ordering %q (want strict or relaxed)", s)
+}
+
+func (o Ordering) String() string {
+	if o == OrderRelaxed {
+		return "relaxed"
+	}
+	return "strict"
+}
+
+// shardEvent is a sample, or the end of a shard when done is set, forwarded
+// from one open shard to the relaxed aggregator.
+type shardEvent struct {
+	id     int64
+	sample Sample
+	done   bool
+	err    error
+}
+
+// relaxedShard is an open shard admitted to the reorder window.
+type relaxedShard struct {
+	cursor    shardCursor
+	delivered int64
+}
+
+// runRelaxedAggregator delivers samples from every shard in the reorder
+// window as soon as they are decoded. A shard is admitted once its job ID is
+// below the oldest unfinished job plus window; window <= 0 admits up to
+// numWorkers shards at once, and window 1 degenerates to strict order. While
+// the window is full, apart from a missing oldest shard, no more shards are
+// taken from the workers, which then block as they do in strict order.
+//
+// A sample's state is the cursor of the oldest unfinished shard rather than
+// its own, because every later shard may already be partly delivered.
+// Resuming from it therefore never loses samples but may repeat some of the
+// ones delivered from later shards.
+//
+// Like runAggregator, it returns the error that ends the stream, if any.
+func runRelaxedAggregator(ctx context.Context, cursors <-chan shardCursor, producerErr <-chan error, out chan<- Sample, start SamplerState, lastEpoch int64, window, numWorkers int, handler *errorHandler) error {
+	obs := handler.obs
+	events := make(chan shardEvent)
+	waiting := make(map[int64]shardCursor)
+	open := make(map[int64]*relaxedShard)
+	finished := make(map[int64]bool)
+	oldest := start.JobID
+	state := start
+	// limit caps the shards held open or waiting for admission.
+	limit := window
+	if limit <= 0 {
+		limit = max(numWorkers, 1)
+	}
+
+	pump := func(cursor shardCursor) {
+		for sample := range cursor.samples {
+			select {
+			case <-ctx.Done():
+				return
+			case events <- shardEvent{id: cursor.job.id, sample: sample}:
+			}
+		}
+		err := <-cursor.errCh
+		for range cursor.errCh {
+			// Wait for the reader to exit so its stats are final.
+		}
+		select {
+		case <-ctx.Done():
+		case events <- shardEvent{id: cursor.job.id, done: true, err: err}:
+		}
+	}
+	admit := func() {
+		for id, cursor := range waiting {
+			if window > 0 && id >= oldest+int64(window) {
+				continue
+			}
+			delete(waiting, id)
+			open[id] = &relaxedShard{cursor: cursor}
+			go pump(cursor)
+		}
+	}
+	// resumeState is the cursor of the oldest unfinished shard. Until that
+	// shard has been opened the previous state, which is older still, stands.
+	resumeState := func() SamplerState {
+		if shard, ok := open[oldest]; ok {
+			state = shard.cursor.job.state(start.Seed, shard.delivered)
+		}
+		return state
+	}
+
+	for {
+		if cursors == nil && len(waiting) == 0 && len(open) == 0 {
+			select {
+			case err := <-producerErr:
+				return err
+			default:
+			}
+			return nil
+		}
+		// The oldest shard is always taken, since every other one waits
+		// for it to finish.
+		intake := cursors
+		_, oldestOpen := open[oldest]
+		_, oldestWaiting := waiting[oldest]
+		if len(open)+len(waiting) >= limit && (oldestOpen || oldestWaiting) {
+			intake = nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case cursor, ok := <-intake:
+			if !ok {
+				cursors = nil
+				continue
+			}
+			waiting[cursor.job.id] = cursor
+			admit()
+		case ev := <-events:
+			shard := open[ev.id]
+			job := shard.cursor.job
+			if ev.done {
+				if errors.Is(ev.err, context.Canceled) {
+					return nil
+				}
+				stats := *shard.cursor.stats
+				stats.Samples = shard.delivered - job.skip
+				if stats.Samples < 0 {
+					stats.Samples = 0
+				}
+				obs.ShardDone(job.root, job.path, stats, ev.err)
+				if ev.err != nil {
+					if err := handler.shardFailed(job.root, job.path, ev.err); err != nil {
+						return err
+					}
+				}
+				delete(open, ev.id)
+				finished[ev.id] = true
+				for finished[oldest] {
+					delete(finished, oldest)
+					oldest++
+				}
+				admit()
+				continue
+			}
+			shard.delivered++
+			if shard.delivered <= job.skip {
+				continue
+			}
+			sample := ev.sample
+			sample.Root = job.root
+			sample.State = resumeState()
+			sample.Epoch = job.epoch
+			if job.epoch > lastEpoch {
+				sample.EpochStart = true
+				lastEpoch = job.epoch
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case out <- sample:
+				obs.SampleDelivered(job.root)
+			}
+		}
+	}
+}
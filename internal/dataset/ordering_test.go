@@ -0,0 +1,187 @@
+package dataset
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRelaxedAggregatorBypassesSlowShard(t *testing.T) {
+	for _, tc := range []struct {
+		window    int
+		reordered bool
+	}{{window: 0, reordered: true}, {window: 2, reordered: true}, {window: 1, reordered: false}} {
+		ctx, cancel := context.WithCancel(context.Background())
+		cursors := make(chan shardCursor, 2)
+		out := make(chan Sample, 4)
+		slow, slowErr := fakeCursor(cursors, 0, 1)
+		fast, fastErr := fakeCursor(cursors, 1, 1)
+		close(cursors)
+		handler := &errorHandler{obs: nopObserver{}}
+		go func() {
+			defer close(out)
+			runRelaxedAggregator(ctx, cursors, nil, out, SamplerState{Seed: 1}, -1, tc.window, 2, handler)
+		}()
+
+		fast <- Sample{Key: "fast"}
+		close(fast)
+		fastErr <- nil
+		close(fastErr)
+		var keys []string
+		select {
+		case sample := <-out:
+			// The slow shard has delivered nothing, so resuming must start
+			// from its beginning.
+			if sample.State.JobID != 0 || sample.State.Offset != 0 {
+				t.Fatalf("window %d: %s has state %+v", tc.window, sample.Key, sample.State)
+			}
+			keys = append(keys, sample.Key)
+		case <-time.After(50 * time.Millisecond):
+		}
+		slow <- Sample{Key: "slow"}
+		close(slow)
+		slowErr <- nil
+		close(slowErr)
+		for sample := range out {
+			keys = append(keys, sample.Key)
+		}
+		cancel()
+		want := []string{"slow", "fast"}
+		if tc.reordered {
+			want = []string{"fast", "slow"}
+		}
+		if !reflect.DeepEqual(keys, want) {
+			t.Fatalf("window %d: got %v, want %v", tc.window, keys, want)
+		}
+	}
+}
+
+func TestSamplerRelaxedOrderingDeliversEverySample(t *testing.T) {
+	roots := mustRoots(t, t.TempDir(), 6, 4)
+	strict := SamplerOptions{Roots: roots, Seed: 5, NumWorkers: 3, Epochs: 2}
+	want := sampleKeys(drainSampler(t, strict))
+
+	relaxed := strict
+	relaxed.Ordering = OrderRelaxed
+	full := drainSampler(t, relaxed)
+	got := sampleKeys(full)
+	sort.Strings(got)
+	sorted := append([]string(nil), want...)
+	sort.Strings(sorted)
+	if !reflect.DeepEqual(got, sorted) {
+		t.Fatalf("relaxed stream delivered %v, want %v", got, sorted)
+	}
+
+	relaxed.ReorderWindow = 1
+	if got := sampleKeys(drainSampler(t, relaxed)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("window 1 should match strict order:\n%v\n%v", got, want)
+	}
+
+	// Resuming a relaxed stream may repeat samples but never loses one.
+	relaxed.ReorderWindow = 0
+	for _, cut := range []int{0, 9, 30} {
+		resumed := relaxed
+		state := full[cut].State
+		resumed.Resume = &state
+		seen := map[string]bool{}
+		for _, sample := range drainSampler(t, resumed) {
+			seen[fmt.Sprintf("%d/%s", sample.Epoch, sample.Key)] = true
+		}
+		for _, sample := range full[cut+1:] {
+			if !seen[fmt.Sprintf("%d/%s", sample.Epoch, sample.Key)] {
+				t.Fatalf("cut %d: resumed stream lost %s in epoch %d", cut, sample.Key, sample.Epoch)
+			}
+		}
+	}
+
+	shuffled := relaxed
+	shuffled.ShuffleSamples = 4
+	if _, _, err := StartSampler(context.Background(), shuffled); err == nil {
+		t.Fatal("expected relaxed ordering with a shuffle buffer to be rejected")
+	}
+}
+
+// openShardObserver tracks how many shards are open at once.
+type openShardObserver struct {
+	nopObserver
+	mu   sync.Mutex
+	open int
+	peak int
+}
+
+func (o *openShardObserver) ShardOpened(string, string, time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.open++
+	o.peak = max(o.peak, o.open)
+}
+
+func (o *openShardObserver) ShardDone(string, string, ShardStats, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.open--
+}
+
+func TestRelaxedOrderingBoundsOpenShards(t *testing.T) {
+	obs := &openShardObserver{}
+	opts := SamplerOptions{
+		Roots:      mustRoots(t, t.TempDir(), 40, 2),
+		Seed:       1,
+		NumWorkers: 2,
+		Epochs:     1,
+		Ordering:   OrderRelaxed,
+		Observer:   obs,
+	}
+	stream, errCh, err := StartSampler(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("StartSampler: %v", err)
+	}
+	delivered := 0
+	for range stream {
+		// A slow consumer gives the workers every chance to run ahead.
+		time.Sleep(time.Millisecond)
+		delivered++
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("sampler: %v", err)
+	}
+	if delivered != 80 {
+		t.Fatalf("delivered %d samples, want 80", delivered)
+	}
+	// The window holds NumWorkers shards and each worker and the cursor
+	// queue one more apiece; taking the oldest shard past a full window
+	// adds at most one per worker.
+	if limit := 4 * opts.NumWorkers; obs.peak > limit {
+		t.Fatalf("%d shards were open at once, want at most %d", obs.peak, limit)
+	}
+}
+
+func TestParseOrdering(t *testing.T) {
+	for in, want := range map[string]Ordering{"": OrderStrict, "strict": OrderStrict, "Relaxed": OrderRelaxed} {
+		got, err := ParseOrdering(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseOrdering(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := ParseOrdering("fifo"); err == nil {
+		t.Fatal("expected an error for an unknown ordering")
+	}
+}
+
+// fakeCursor queues a cursor for job id whose samples and result are fed by
+// the test.
+func fakeCursor(cursors chan<- shardCursor, id, epoch int64) (chan<- Sample, chan<- error) {
+	samples := make(chan Sample, 1)
+	errCh := make(chan error, 1)
+	cursors <- shardCursor{
+		job:     shardJob{id: id, root: "root", path: fmt.Sprintf("shard-%d.tar", id), epoch: epoch},
+		samples: samples,
+		errCh:   errCh,
+		stats:   &ShardStats{},
+	}
+	return samples, errCh
+}
@@ -0,0 +1,77 @@
+package dataset
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Partition decides how an epoch whose shard count is not a multiple of the
+// world size is split across ranks.
+type Partition int
+
+const (
+	// PartitionPad gives every rank the same number of shards by repeating
+	// shards from the start of the epoch's order.
+	PartitionPad Partition = iota
+	// PartitionDrop gives every rank the same number of shards by leaving
+	// the tail of the epoch's order unread. The order is reshuffled each
+	// epoch, so a different tail is dropped every time.
+	PartitionDrop
+	// PartitionWrap reads every shard exactly once. The split continues
+	// across epochs as if they were one long order, so the ranks that get
+	// one extra shard rotate from epoch to epoch.
+	PartitionWrap
+)
+
+// ParsePartition maps a partition value to a Partition.
+func ParsePartition(s string) (Partition, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "pad":
+		return PartitionPad, nil
+	case "drop":
+		return PartitionDrop, nil
+	case "wrap":
+		return PartitionWrap, nil
+	}
+	return PartitionPad, fmt.Errorf("unknown partition %q (want pad, drop or wrap)", s)
+}
+
+func (p Partition) String() string {
+	switch p {
+	case PartitionDrop:
+		return "drop"
+	case PartitionWrap:
+		return "wrap"
+	}
+	return "pad"
+}
+
+// partitionOrder returns the shards of one epoch's order that belong to
+// rank. Every rank builds the same order from the shared seed and takes
+// every world-th entry, so the ranks' shares are disjoint (apart from pad's
+// repeats) and interleave the roots the same way the full order does.
+func partitionOrder(order []orderEntry, rank, world int, mode Partition, epoch int64) []orderEntry {
+	n := len(order)
+	if world <= 1 || n == 0 {
+		return order
+	}
+	var part []orderEntry
+	switch mode {
+	case PartitionDrop:
+		for i := rank; i < n/world*world; i += world {
+			part = append(part, order[i])
+		}
+	case PartitionWrap:
+		// Position i of epoch e is position e*n+i of the endless order.
+		offset := int((epoch % int64(world)) * int64(n%world) % int64(world))
+		first := (rank - offset + world) % world
+		for i := first; i < n; i += world {
+			part = append(part, order[i])
+		}
+	default:
+		for i := rank; i < (n+world-1)/world*world; i += world {
+			part = append(part, order[i%n])
+		}
+	}
+	return part
+}
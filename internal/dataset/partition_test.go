@@ -0,0 +1,102 @@
+package dataset
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestPartitionOrderModes(t *testing.T) {
+	var order []orderEntry
+	for i := 0; i < 10; i++ {
+		order = append(order, orderEntry{root: "r", path: fmt.Sprintf("shard-%02d", i)})
+	}
+	const world = 3
+	for _, mode := range []Partition{PartitionPad, PartitionDrop, PartitionWrap} {
+		reads := map[string]int{}
+		total := 0
+		for epoch := int64(0); epoch < world; epoch++ {
+			counts := map[int]int{}
+			seen := map[string]int{}
+			for rank := 0; rank < world; rank++ {
+				part := partitionOrder(order, rank, world, mode, epoch)
+				counts[len(part)]++
+				for _, entry := range part {
+					seen[entry.path]++
+					reads[fmt.Sprintf("%d/%s", rank, entry.path)]++
+					total++
+				}
+			}
+			switch mode {
+			case PartitionPad:
+				if len(counts) != 1 || counts[4] != world || len(seen) != 10 {
+					t.Fatalf("pad epoch %d: counts %v, %d distinct shards", epoch, counts, len(seen))
+				}
+			case PartitionDrop:
+				if len(counts) != 1 || counts[3] != world || len(seen) != 9 {
+					t.Fatalf("drop epoch %d: counts %v, %d distinct shards", epoch, counts, len(seen))
+				}
+			case PartitionWrap:
+				if counts[3]+counts[4] != world || len(seen) != 10 {
+					t.Fatalf("wrap epoch %d: counts %v, %d distinct shards", epoch, counts, len(seen))
+				}
+			}
+			for path, n := range seen {
+				if n > 1 && mode != PartitionPad {
+					t.Fatalf("%s epoch %d: %s read %d times", mode, epoch, path, n)
+				}
+			}
+		}
+		if mode == PartitionWrap {
+			// Over world epochs every rank reads the same number of shards.
+			perRank := map[string]int{}
+			for key, n := range reads {
+				perRank[key[:1]] += n
+			}
+			for rank, n := range perRank {
+				if n != total/world {
+					t.Fatalf("wrap: rank %s read %d shards over %d epochs, want %d", rank, n, world, total/world)
+				}
+			}
+		}
+	}
+}
+
+func TestSamplerRanksReadDisjointShards(t *testing.T) {
+	roots := mustRoots(t, t.TempDir(), 7, 2)
+	var all []string
+	for rank := 0; rank < 3; rank++ {
+		opts := SamplerOptions{Roots: roots, Seed: 9, NumWorkers: 2, Epochs: 1, Rank: rank, WorldSize: 3, Partition: PartitionWrap}
+		samples := drainSampler(t, opts)
+		if want := 2 * len(EpochOrder(opts, 0)); len(samples) != want {
+			t.Fatalf("rank %d: got %d samples, EpochOrder implies %d", rank, len(samples), want)
+		}
+		all = append(all, sampleKeys(samples)...)
+	}
+	sort.Strings(all)
+	want := sampleKeys(drainSampler(t, SamplerOptions{Roots: roots, Seed: 9, NumWorkers: 2, Epochs: 1}))
+	sort.Strings(want)
+	if fmt.Sprint(all) != fmt.Sprint(want) {
+		t.Fatalf("ranks together read %v, want %v", all, want)
+	}
+
+	if _, _, err := StartSampler(context.Background(), SamplerOptions{Roots: roots, Rank: 3, WorldSize: 3}); err == nil {
+		t.Fatal("expected an error for a rank outside the world")
+	}
+	if _, _, err := StartSampler(context.Background(), SamplerOptions{Roots: roots, WorldSize: 8}); err == nil {
+		t.Fatal("expected an error for more ranks than shards")
+	}
+}
+
+func TestParsePartition(t *testing.T) {
+	for in, want := range map[string]Partition{"": PartitionPad, "pad": PartitionPad, "DROP": PartitionDrop, "wrap": PartitionWrap} {
+		got, err := ParsePartition(in)
+		if err != nil || got != want {
+			t.Fatalf("ParsePartition(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := ParsePartition("split"); err == nil {
+		t.Fatal("expected an error for an unknown partition")
+	}
+}
@@ -0,0 +1,305 @@
+package dataset
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// regexPrefix marks a pattern as a regular expression instead of a glob.
+const regexPrefix = "re:"
+
+// maxBraceExpansion bounds the names a single pattern may expand to.
+const maxBraceExpansion = 1 << 20
+
+// ShardFilter selects the shard files of a root by their path relative to
+// the root. Every pattern is a glob in path.Match syntax, after brace
+// expansion, or a regular expression when prefixed with "re:". A pattern
+// containing a slash is matched against the whole relative path, any other
+// against the last element only.
+type ShardFilter struct {
+	// patterns name shard files; when empty, the default shard-NNNNNN.tar
+	// naming applies to walked roots and manifests are taken as listed.
+	patterns []*pattern
+	// include and exclude are matched against the shard and each of its
+	// parent directories. A shard must match some include pattern, when
+	// there are any, and no exclude pattern.
+	include []*pattern
+	exclude []*pattern
+}
+
+// ParseShardFilter compiles the per-root shard patterns and include and
+// exclude filters. It returns nil when none are set.
+func ParseShardFilter(patterns, include, exclude []string) (*ShardFilter, error) {
+	if len(patterns) == 0 && len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	f := &ShardFilter{}
+	var err error
+	if f.patterns, err = compilePatterns("patterns", patterns); err != nil {
+		return nil, err
+	}
+	if f.include, err = compilePatterns("include", include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compilePatterns("exclude", exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Match reports whether the shard at rel, a slash-separated path relative
+// to the root, is selected. Without patterns, any name is accepted.
+func (f *ShardFilter) Match(rel string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.patterns) > 0 && !matchAny(f.patterns, rel) {
+		return false
+	}
+	return f.filtered(rel)
+}
+
+// filtered applies the include and exclude filters to rel.
+func (f *ShardFilter) filtered(rel string) bool {
+	included := len(f.include) == 0
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		if matchAny(f.exclude, p) {
+			return false
+		}
+		if !included && matchAny(f.include, p) {
+			included = true
+		}
+	}
+	return included
+}
+
+// DiscoverMatching walks root like DiscoverShards but selects shards with
+// filter. Excluded directories are not descended into. A nil filter
+// behaves like DiscoverShards.
+func DiscoverMatching(root string, filter *ShardFilter) ([]string, error) {
+	if filter == nil {
+		return DiscoverShards(root)
+	}
+	entries := make([]string, 0)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && matchAny(filter.exclude, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(filter.patterns) == 0 && !shardRegexp.MatchString(d.Name()) {
+			return nil
+		}
+		if filter.Match(rel) {
+			entries = append(entries, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("discover shards: %w", err)
+	}
+	sort.Strings(entries)
+	return entries, nil
+}
+
+// pattern is one compiled glob or regular expression.
+type pattern struct {
+	// full matches against the whole relative path rather than its last
+	// element.
+	full bool
+	re   *regexp.Regexp
+	// literal holds brace expansions without glob metacharacters, which
+	// match by lookup; globs holds the rest.
+	literal map[string]bool
+	globs   []string
+}
+
+func compilePatterns(field string, specs []string) ([]*pattern, error) {
+	out := make([]*pattern, 0, len(specs))
+	for _, spec := range specs {
+		p, err := compilePattern(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q: %w", field, spec, err)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func compilePattern(spec string) (*pattern, error) {
+	if spec == "" {
+		return nil, errors.New("empty pattern")
+	}
+	if expr, ok := strings.CutPrefix(spec, regexPrefix); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		return &pattern{full: strings.Contains(expr, "/"), re: re}, nil
+	}
+	names, err := ExpandBraces(spec)
+	if err != nil {
+		return nil, err
+	}
+	p := &pattern{full: strings.Contains(spec, "/"), literal: make(map[string]bool)}
+	for _, name := range names {
+		if !strings.ContainsAny(name, `*?[\`) {
+			p.literal[name] = true
+			continue
+		}
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, err
+		}
+		p.globs = append(p.globs, name)
+	}
+	return p, nil
+}
+
+func (p *pattern) match(rel string) bool {
+	if !p.full {
+		rel = path.Base(rel)
+	}
+	if p.re != nil {
+		return p.re.MatchString(rel)
+	}
+	if p.literal[rel] {
+		return true
+	}
+	for _, glob := range p.globs {
+		if ok, _ := path.Match(glob, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []*pattern, rel string) bool {
+	for _, p := range patterns {
+		if p.match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandBraces expands the brace groups of a WebDataset-style shard name:
+// "{000..099}" is a numeric range, zero-padded to the width of its bounds
+// when they have leading zeros, and "{a,b}" a list of alternatives. Groups
+// may repeat and nest; a pattern without braces expands to itself.
+func ExpandBraces(pattern string) ([]string, error) {
+	open := strings.IndexByte(pattern, '{')
+	if open < 0 {
+		if strings.IndexByte(pattern, '}') >= 0 {
+			return nil, errors.New("unmatched '}'")
+		}
+		return []string{pattern}, nil
+	}
+	// Find the brace closing the first group and the top-level commas in
+	// it.
+	depth, end := 0, -1
+	var commas []int
+	for i := open; i < len(pattern) && end < 0; i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				end = i
+			}
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		}
+	}
+	if end < 0 {
+		return nil, errors.New("unmatched '{'")
+	}
+	var alternatives []string
+	if len(commas) > 0 {
+		start := open + 1
+		for _, comma := range append(commas, end) {
+			alternatives = append(alternatives, pattern[start:comma])
+			start = comma + 1
+		}
+	} else {
+		var err error
+		if alternatives, err = expandRange(pattern[open+1 : end]); err != nil {
+			return nil, err
+		}
+	}
+
+	prefix := pattern[:open]
+	if strings.IndexByte(prefix, '}') >= 0 {
+		return nil, errors.New("unmatched '}'")
+	}
+	suffixes, err := ExpandBraces(pattern[end+1:])
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, alt := range alternatives {
+		// Alternatives may hold groups of their own.
+		inner, err := ExpandBraces(alt)
+		if err != nil {
+			return nil, err
+		}
+		if len(out)+len(inner)*len(suffixes) > maxBraceExpansion {
+			return nil, fmt.Errorf("expands to more than %d names", maxBraceExpansion)
+		}
+		for _, in := range inner {
+			for _, suffix := range suffixes {
+				out = append(out, prefix+in+suffix)
+			}
+		}
+	}
+	return out, nil
+}
+
+// expandRange expands the "lo..hi" body of a numeric brace group.
+func expandRange(body string) ([]string, error) {
+	loText, hiText, ok := strings.Cut(body, "..")
+	if !ok {
+		return nil, fmt.Errorf("brace group {%s} is neither a range nor a list", body)
+	}
+	lo, err := strconv.Atoi(loText)
+	if err != nil {
+		return nil, fmt.Errorf("brace range {%s}: %w", body, err)
+	}
+	hi, err := strconv.Atoi(hiText)
+	if err != nil {
+		return nil, fmt.Errorf("brace range {%s}: %w", body, err)
+	}
+	if lo < 0 || hi < lo {
+		return nil, fmt.Errorf("brace range {%s} must count up from 0 or more", body)
+	}
+	if hi-lo >= maxBraceExpansion {
+		return nil, fmt.Errorf("expands to more than %d names", maxBraceExpansion)
+	}
+	width := 0
+	if len(loText) > 1 && loText[0] == '0' || len(hiText) > 1 && hiText[0] == '0' {
+		width = max(len(loText), len(hiText))
+	}
+	out := make([]string, 0, hi-lo+1)
+	for n := lo; n <= hi; n++ {
+		out = append(out, fmt.Sprintf("%0*d", width, n))
+	}
+	return out, nil
+}
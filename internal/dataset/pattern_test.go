@@ -0,0 +1,98 @@
+package dataset
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandBraces(t *testing.T) {
+	cases := map[string][]string{
+		"shard-000001.tar":               {"shard-000001.tar"},
+		"shard-{000008..000010}.tar":     {"shard-000008.tar", "shard-000009.tar", "shard-000010.tar"},
+		"{train,val}-{0..1}.tar":         {"train-0.tar", "train-1.tar", "val-0.tar", "val-1.tar"},
+		"x-{a,b{1..2}}.tar":              {"x-a.tar", "x-b1.tar", "x-b2.tar"},
+		"{9..11}-of-{0002..0002}.tar.gz": {"9-of-0002.tar.gz", "10-of-0002.tar.gz", "11-of-0002.tar.gz"},
+	}
+	for pattern, want := range cases {
+		got, err := ExpandBraces(pattern)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("ExpandBraces(%q) = %v, %v; want %v", pattern, got, err, want)
+		}
+	}
+	for _, bad := range []string{"shard-{1..", "shard-}.tar", "shard-{5..2}.tar", "shard-{a..b}.tar", "shard-{x}.tar", "{0..9999999}"} {
+		if _, err := ExpandBraces(bad); err == nil {
+			t.Fatalf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestDiscoverMatchingFilters(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"a/train-00000-of-00003.tar",
+		"a/train-00001-of-00003.tar",
+		"b/train-00002-of-00003.tar",
+		"bad/train-00003-of-00003.tar",
+		"a/shard-000000.tar",
+		"a/notes.txt",
+	} {
+		writeShardFile(t, filepath.Join(root, name), 1)
+	}
+	paths := func(rels ...string) []string {
+		out := make([]string, len(rels))
+		for i, rel := range rels {
+			out[i] = filepath.Join(root, rel)
+		}
+		return out
+	}
+
+	cases := []struct {
+		patterns, include, exclude []string
+		want                       []string
+	}{
+		{nil, nil, []string{"bad"}, paths("a/shard-000000.tar")},
+		{[]string{"train-*.tar"}, nil, []string{"bad"}, paths("a/train-00000-of-00003.tar", "a/train-00001-of-00003.tar", "b/train-00002-of-00003.tar")},
+		{[]string{"train-{00001..00002}-of-00003.tar"}, nil, nil, paths("a/train-00001-of-00003.tar", "b/train-00002-of-00003.tar")},
+		{[]string{`re:^train-\d+-of-\d+\.tar$`}, []string{"b", "bad/*"}, nil, paths("b/train-00002-of-00003.tar", "bad/train-00003-of-00003.tar")},
+		{[]string{"a/*"}, nil, []string{"*.txt", "re:shard"}, paths("a/train-00000-of-00003.tar", "a/train-00001-of-00003.tar")},
+	}
+	for _, tc := range cases {
+		filter, err := ParseShardFilter(tc.patterns, tc.include, tc.exclude)
+		if err != nil {
+			t.Fatalf("ParseShardFilter: %v", err)
+		}
+		got, err := DiscoverMatching(root, filter)
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("patterns %v include %v exclude %v found %v, %v; want %v", tc.patterns, tc.include, tc.exclude, got, err, tc.want)
+		}
+	}
+
+	// Filters also apply to the shards a manifest lists.
+	filter, _ := ParseShardFilter([]string{"train-*"}, nil, []string{"bad"})
+	built, err := BuildManifest(root, filter, false, nil)
+	if err != nil || len(built.Shards) != 3 {
+		t.Fatalf("BuildManifest = %+v, %v", built, err)
+	}
+	if err := WriteManifest(filepath.Join(root, ManifestName), &Manifest{Shards: []ManifestEntry{
+		{Path: "a/train-00000-of-00003.tar", Samples: 1},
+		{Path: "bad/train-00003-of-00003.tar", Samples: 1},
+		{Path: filepath.Join(root, "b/train-00002-of-00003.tar"), Samples: 1},
+	}}); err != nil {
+		t.Fatalf("WriteManifest: %v", err)
+	}
+	shards, m, err := DiscoverRoot(root, "", filter)
+	if err != nil || !reflect.DeepEqual(shards, paths("a/train-00000-of-00003.tar", "b/train-00002-of-00003.tar")) || len(m.SampleCounts(root)) != 2 {
+		t.Fatalf("DiscoverRoot = %v, %v", shards, err)
+	}
+
+	if _, err := ParseShardFilter([]string{"re:("}, nil, nil); err == nil {
+		t.Fatal("expected an error for an invalid regexp")
+	}
+	if _, err := ParseShardFilter(nil, nil, []string{"[a-"}); err == nil {
+		t.Fatal("expected an error for an invalid glob")
+	}
+	if filter, err := ParseShardFilter(nil, nil, nil); filter != nil || err != nil {
+		t.Fatalf("expected no filter, got %v, %v", filter, err)
+	}
+}
@@ -0,0 +1,123 @@
+package dataset
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrorPolicy decides what the sampler does when a shard cannot be read.
+type ErrorPolicy int
+
+const (
+	// PolicyFail forwards the first shard error to the consumer.
+	PolicyFail ErrorPolicy = iota
+	// PolicySkipShard drops the rest of a failing shard and moves on to the
+	// next one. Samples already delivered from it are kept.
+	PolicySkipShard
+	// PolicySkipSample additionally drops individual samples with an
+	// unparsable label or a missing image/label half, keeping the rest of
+	// the shard. Errors that break the tar stream still skip the shard.
+	PolicySkipSample
+)
+
+// ParseErrorPolicy maps an error_policy value to an ErrorPolicy.
+func ParseErrorPolicy(s string) (ErrorPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "fail":
+		return PolicyFail, nil
+	case "skip_shard":
+		return PolicySkipShard, nil
+	case "skip_sample":
+		return PolicySkipSample, nil
+	}
+	return PolicyFail, fmt.Errorf("unknown error policy %q (want fail, skip_shard or skip_sample)", s)
+}
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case PolicySkipShard:
+		return "skip_shard"
+	case PolicySkipSample:
+		return "skip_sample"
+	}
+	return "fail"
+}
+
+// ErrBudgetExhausted is wrapped by the error that ends a run once more than
+// the allowed number of shard or sample errors has been skipped.
+var ErrBudgetExhausted = errors.New("error budget exhausted")
+
+// errorBudget counts skipped errors across all workers.
+type errorBudget struct {
+	mu    sync.Mutex
+	limit int
+	spent int
+}
+
+// spend records one skipped error and returns a non-nil error once the
+// budget is exceeded. A limit <= 0 never runs out.
+func (b *errorBudget) spend(err error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spent++
+	if b.limit > 0 && b.spent > b.limit {
+		return fmt.Errorf("sampler: %w after %d errors: %w", ErrBudgetExhausted, b.spent, err)
+	}
+	return nil
+}
+
+// errorHandler applies the ErrorPolicy for one sampler.
+type errorHandler struct {
+	policy     ErrorPolicy
+	budget     errorBudget
+	quarantine *Quarantine
+	obs        Observer
+	// verify checks shards against their checksums; nil disables it.
+	verify *Verifier
+}
+
+// shardFailed returns the error a shard ended with to forward to the
+// consumer, or nil when the shard is skipped. Only skipped shards are
+// quarantined: one that ends the run is read again after a restart.
+func (h *errorHandler) shardFailed(root, path string, err error) error {
+	if errors.Is(err, ErrChecksumMismatch) {
+		// The mismatch action replaces the error policy.
+		if h.verify == nil || h.verify.OnMismatch != MismatchSkip {
+			return err
+		}
+		return h.skipShard(root, path, err)
+	}
+	if h.policy == PolicyFail || errors.Is(err, ErrBudgetExhausted) {
+		return err
+	}
+	if berr := h.budget.spend(err); berr != nil {
+		return berr
+	}
+	return h.skipShard(root, path, err)
+}
+
+// skipShard quarantines a shard the policy skips.
+func (h *errorHandler) skipShard(root, path string, err error) error {
+	if qerr := h.quarantine.Add(path, err); qerr != nil {
+		return errors.Join(err, qerr)
+	}
+	h.obs.ShardSkipped(root, path, err)
+	return nil
+}
+
+// sampleSkipper returns the streamShard onSkip hook for a shard, or nil
+// when the policy does not skip samples.
+func (h *errorHandler) sampleSkipper(root, path string) func(key string, err error) error {
+	if h.policy != PolicySkipSample {
+		return nil
+	}
+	return func(key string, err error) error {
+		if berr := h.budget.spend(err); berr != nil {
+			return berr
+		}
+		h.obs.SampleSkipped(root, path, key, err)
+		return nil
+	}
+}
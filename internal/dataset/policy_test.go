@@ -0,0 +1,154 @@
+package dataset
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSamplerSkipsAndQuarantinesBadShard(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "shard-000000.tar")
+	bad := filepath.Join(dir, "shard-000001.tar")
+	mustShard(t, good, map[string]int{"a": 1, "b": 2})
+	if err := os.WriteFile(bad, bytes.Repeat([]byte("x"), 1024), 0o644); err != nil {
+		t.Fatalf("write bad shard: %v", err)
+	}
+	qpath := filepath.Join(dir, "quarantine.txt")
+	quarantine, err := LoadQuarantine(qpath)
+	if err != nil {
+		t.Fatalf("LoadQuarantine: %v", err)
+	}
+
+	opts := SamplerOptions{
+		Roots:       map[string][]string{"cac": {good, bad}},
+		Seed:        1,
+		ErrorPolicy: PolicySkipShard,
+		Quarantine:  quarantine,
+	}
+	for _, sample := range collectStream(t, opts, 6) {
+		if sample.Shard != good {
+			t.Fatalf("sample from unexpected shard %s", sample.Shard)
+		}
+	}
+
+	reloaded, err := LoadQuarantine(qpath)
+	if err != nil {
+		t.Fatalf("reload quarantine: %v", err)
+	}
+	if !reloaded.Contains(bad) || reloaded.Contains(good) || reloaded.Len() != 1 {
+		t.Fatalf("unexpected quarantine contents after reload")
+	}
+	if kept := reloaded.Filter([]string{good, bad}); len(kept) != 1 || kept[0] != good {
+		t.Fatalf("Filter kept %v", kept)
+	}
+
+	opts.Roots = map[string][]string{"cac": {bad}}
+	if _, _, err := StartSampler(context.Background(), opts); err == nil {
+		t.Fatal("expected error when every shard is quarantined")
+	}
+}
+
+func TestSamplerFailPolicyForwardsShardError(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "shard-000000.tar")
+	if err := os.WriteFile(bad, bytes.Repeat([]byte("x"), 1024), 0o644); err != nil {
+		t.Fatalf("write bad shard: %v", err)
+	}
+	qpath := filepath.Join(dir, "quarantine.txt")
+	quarantine, err := LoadQuarantine(qpath)
+	if err != nil {
+		t.Fatalf("LoadQuarantine: %v", err)
+	}
+	err = streamUntilError(t, SamplerOptions{Roots: map[string][]string{"cac": {bad}}, Seed: 1, Quarantine: quarantine})
+	if err == nil || !strings.Contains(err.Error(), "read tar") {
+		t.Fatalf("expected tar error, got %v", err)
+	}
+
+	// A shard that ends the run is read again after a restart.
+	if data, err := os.ReadFile(qpath); (err != nil && !os.IsNotExist(err)) || len(data) != 0 {
+		t.Fatalf("quarantine file holds %q, %v", data, err)
+	}
+	if reloaded, err := LoadQuarantine(qpath); err != nil || reloaded.Contains(bad) {
+		t.Fatalf("bad shard quarantined after a fail-policy error: %v", err)
+	}
+}
+
+func TestSamplerSkipSampleBudget(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shard-000000.tar")
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	addTarPayload(t, tw, "a.jpg", []byte("a"))
+	addTarPayload(t, tw, "a.cls", []byte("not-a-number"))
+	addTarPayload(t, tw, "b.jpg", []byte("b"))
+	addTarPayload(t, tw, "b.cls", []byte("3"))
+	addTarPayload(t, tw, "c.jpg", []byte("c"))
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("write shard: %v", err)
+	}
+
+	opts := SamplerOptions{
+		Roots:       map[string][]string{"cac": {path}},
+		Seed:        1,
+		ErrorPolicy: PolicySkipSample,
+	}
+	for _, sample := range collectStream(t, opts, 3) {
+		if sample.Key != "b" {
+			t.Fatalf("expected only sample b, got %s", sample.Key)
+		}
+	}
+
+	// Each pass drops two samples, so a budget of 3 runs out in the second.
+	opts.ErrorBudget = 3
+	err := streamUntilError(t, opts)
+	if !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("expected ErrBudgetExhausted, got %v", err)
+	}
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	for in, want := range map[string]ErrorPolicy{"": PolicyFail, "skip_shard": PolicySkipShard, "SKIP_SAMPLE": PolicySkipSample} {
+		got, err := ParseErrorPolicy(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseErrorPolicy(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := ParseErrorPolicy("retry"); err == nil {
+		t.Fatal("expected error for unknown policy")
+	}
+}
+
+func streamUntilError(t *testing.T, opts SamplerOptions) error {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, errCh, err := StartSampler(ctx, opts)
+	if err != nil {
+		t.Fatalf("StartSampler error: %v", err)
+	}
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-stream:
+			if !ok {
+				return <-errCh
+			}
+		case err := <-errCh:
+			if err != nil {
+				return err
+			}
+		case <-deadline:
+			t.Fatal("timed out waiting for sampler error")
+		}
+	}
+}
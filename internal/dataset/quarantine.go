@@ -0,0 +1,109 @@
+package dataset
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Quarantine is a persistent list of shards that failed to read. The file
+// holds one "path<TAB>reason" line per shard; blank lines and lines starting
+// with '#' are ignored.
+type Quarantine struct {
+	mu     sync.Mutex
+	path   string
+	shards map[string]bool
+}
+
+// LoadQuarantine reads the quarantine file at path. A missing file yields
+// an empty list that is created on the first Add.
+func LoadQuarantine(path string) (*Quarantine, error) {
+	q := &Quarantine{path: path, shards: make(map[string]bool)}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open quarantine: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		shard, _, _ := strings.Cut(line, "\t")
+		q.shards[shard] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read quarantine: %w", err)
+	}
+	return q, nil
+}
+
+// Contains reports whether shard is quarantined. A nil Quarantine contains
+// nothing.
+func (q *Quarantine) Contains(shard string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.shards[shard]
+}
+
+// Len returns the number of quarantined shards.
+func (q *Quarantine) Len() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.shards)
+}
+
+// Filter returns the shards that are not quarantined.
+func (q *Quarantine) Filter(shards []string) []string {
+	if q == nil {
+		return shards
+	}
+	kept := make([]string, 0, len(shards))
+	for _, shard := range shards {
+		if !q.Contains(shard) {
+			kept = append(kept, shard)
+		}
+	}
+	return kept
+}
+
+// Add quarantines shard and appends it to the file. Adding a shard that is
+// already listed is a no-op.
+func (q *Quarantine) Add(shard string, reason error) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.shards[shard] {
+		return nil
+	}
+	q.shards[shard] = true
+	f, err := os.OpenFile(q.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open quarantine: %w", err)
+	}
+	msg := ""
+	if reason != nil {
+		msg = strings.Join(strings.Fields(reason.Error()), " ")
+	}
+	if _, err := fmt.Fprintf(f, "%s\t%s\n", shard, msg); err != nil {
+		f.Close()
+		return fmt.Errorf("write quarantine: %w", err)
+	}
+	return f.Close()
+}
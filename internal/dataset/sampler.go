@@ -3,6 +3,8 @@ package dataset
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
 	"math/rand"
 	"sort"
 	"sync"
@@ -11,13 +13,60 @@ import (
 
 // SamplerOptions configures the multi-root sampler.
 type SamplerOptions struct {
-	Roots      map[string][]string
+	Roots map[string][]string
+	// Weights optionally sets the relative share of shards drawn from each
+	// root. When nil, roots are interleaved strictly round robin. Roots
+	// missing from a non-nil Weights map are not sampled.
+	Weights    map[string]float64
 	Seed       int64
 	NumWorkers int
 	PendingCap int
+	// Epochs is the number of passes over the shards before the stream
+	// closes; 0 streams forever. Each epoch is shuffled with EpochSeed.
+	Epochs int64
+	// ShuffleSamples and ShuffleBytes size the rolling shuffle buffer that
+	// mixes samples across consecutive shards; it holds as many samples as
+	// it took in before either limit was reached. Both 0 disables it and
+	// samples leave each shard in tar order.
+	ShuffleSamples int
+	ShuffleBytes   int64
+	// Ordering picks strict job order (the default) or relaxed delivery
+	// from whichever shard is ready. ReorderWindow caps how many shards,
+	// counted from the oldest unfinished one, relaxed ordering may read
+	// from at once; 0 means NumWorkers. Relaxed streams are not
+	// reproducible and cannot use the shuffle buffer.
+	Ordering      Ordering
+	ReorderWindow int
+	// Rank and WorldSize split every epoch's shard order across WorldSize
+	// processes that share the roots and seed; this sampler reads rank's
+	// share. Partition handles shard counts that do not divide evenly.
+	// WorldSize 0 or 1 reads every shard.
+	Rank      int
+	WorldSize int
+	Partition Partition
+	// Resume restarts the stream right after the sample that produced this
+	// state. It must come from a run with the same roots, weights and seed.
+	Resume *SamplerState
+	// Observer, when set, receives per-shard and per-sample events.
+	Observer Observer
+	// ErrorPolicy decides whether a shard or sample error ends the stream.
+	// ErrorBudget caps how many errors may be skipped before the stream
+	// fails with ErrBudgetExhausted; 0 means no limit.
+	ErrorPolicy ErrorPolicy
+	ErrorBudget int
+	// Quarantine, when set, records every shard skipped after an error; a
+	// shard whose error ends the stream is not recorded.
+	// Quarantined shards keep their place in the shuffled order, so seeds
+	// and resume cursors stay valid, but are never read.
+	Quarantine *Quarantine
+	// Verify, when set, checks each shard that has a checksum while it is
+	// read.
+	Verify *Verifier
 }
 
-// StartSampler launches the multi-root sampler pipeline.
+// StartSampler launches the multi-root sampler pipeline. An error is sent
+// only after the workers have exited, so opts may be reused once it has
+// been received.
 func StartSampler(parent context.Context, opts SamplerOptions) (<-chan Sample, <-chan error, error) {
 	if len(opts.Roots) == 0 {
 		return nil, nil, errors.New("sampler: no dataset roots provided")
@@ -29,14 +78,88 @@ func StartSampler(parent context.Context, opts SamplerOptions) (<-chan Sample, <
 	if total == 0 {
 		return nil, nil, errors.New("sampler: no shards discovered")
 	}
+	if total == countQuarantined(opts.Roots, opts.Quarantine) {
+		return nil, nil, errors.New("sampler: every shard is quarantined")
+	}
+	if opts.Weights != nil {
+		active := 0
+		for root, weight := range opts.Weights {
+			if _, ok := opts.Roots[root]; !ok {
+				return nil, nil, fmt.Errorf("sampler: weight for unknown root %s", root)
+			}
+			if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+				return nil, nil, fmt.Errorf("sampler: invalid weight %v for root %s", weight, root)
+			}
+			if weight > 0 && len(opts.Roots[root]) > 0 {
+				active++
+			}
+		}
+		if active == 0 {
+			return nil, nil, errors.New("sampler: no root with shards has a positive weight")
+		}
+	}
+	if opts.WorldSize <= 0 {
+		opts.WorldSize = 1
+	}
+	if opts.Rank < 0 || opts.Rank >= opts.WorldSize {
+		return nil, nil, fmt.Errorf("sampler: rank %d out of range for world size %d", opts.Rank, opts.WorldSize)
+	}
 	if opts.NumWorkers <= 0 {
 		opts.NumWorkers = 1
 	}
 	if opts.PendingCap <= 0 {
 		opts.PendingCap = defaultPendingCap
 	}
-	if opts.Seed == 0 {
-		opts.Seed = 42
+	opts.Seed = samplerSeed(opts.Seed)
+	if opts.Observer == nil {
+		opts.Observer = nopObserver{}
+	}
+
+	start := SamplerState{Seed: opts.Seed}
+	if opts.Resume != nil {
+		start = *opts.Resume
+		if start.Seed != opts.Seed {
+			return nil, nil, fmt.Errorf("sampler: resume state seed %d does not match seed %d", start.Seed, opts.Seed)
+		}
+	}
+	shuffle := shuffleBuffer{seed: opts.Seed, maxCount: opts.ShuffleSamples, maxBytes: opts.ShuffleBytes}
+	if opts.ShuffleSamples < 0 || opts.ShuffleBytes < 0 {
+		return nil, nil, errors.New("sampler: shuffle buffer size must be >= 0")
+	}
+	if opts.ReorderWindow < 0 {
+		return nil, nil, errors.New("sampler: reorder window must be >= 0")
+	}
+	if opts.Ordering == OrderRelaxed && shuffle.enabled() {
+		return nil, nil, errors.New("sampler: the shuffle buffer requires strict ordering")
+	}
+	if !shuffle.enabled() && start.ShuffleSlots != 0 {
+		return nil, nil, errors.New("sampler: resume state was written with a shuffle buffer")
+	}
+	if opts.WorldSize > 1 {
+		// Every epoch's order has the same length.
+		if n := len(buildEpochOrder(opts.Roots, opts.Weights, opts.Seed, 0)); n < opts.WorldSize {
+			return nil, nil, fmt.Errorf("sampler: %d shards cannot be split across %d ranks", n, opts.WorldSize)
+		}
+	}
+	buildOrder := func(epoch int64) []orderEntry {
+		return rankOrder(opts, epoch)
+	}
+	first := buildOrder(start.Epoch)
+	// lastEpoch is the epoch of the sample before the first one delivered,
+	// so a fresh stream flags its first sample as an epoch start.
+	lastEpoch := int64(-1)
+	if opts.Resume != nil {
+		if start.JobID > 0 || start.Offset > 0 {
+			lastEpoch = start.Epoch
+		}
+		if start.Index < 0 || start.Index >= len(first) || first[start.Index].path != start.Shard {
+			return nil, nil, fmt.Errorf("sampler: resume state does not match dataset (shard %s at index %d)", start.Shard, start.Index)
+		}
+	}
+
+	if opts.Resume == nil {
+		// Point the shuffle buffer's first block at the start of the stream.
+		start.Shard = first[0].path
 	}
 
 	ctx, cancel := context.WithCancel(parent)
@@ -46,47 +169,112 @@ func StartSampler(parent context.Context, opts SamplerOptions) (<-chan Sample, <
 	out := make(chan Sample, opts.NumWorkers*2)
 	errCh := make(chan error, opts.NumWorkers)
 
-	rng := rand.New(rand.NewSource(opts.Seed))
+	handler := &errorHandler{
+		policy:     opts.ErrorPolicy,
+		budget:     errorBudget{limit: opts.ErrorBudget},
+		quarantine: opts.Quarantine,
+		obs:        opts.Observer,
+		verify:     opts.Verify,
+	}
 
-	go produceJobs(ctx, jobs, opts.Roots, rng)
+	producerErr := make(chan error, 1)
+	go produceJobs(ctx, jobs, producerErr, buildOrder, first, start, opts.Epochs, opts.Quarantine)
 
-	var wg sync.WaitGroup
+	var workers sync.WaitGroup
 	for i := 0; i < opts.NumWorkers; i++ {
-		wg.Add(1)
+		workers.Add(1)
 		go func() {
-			defer wg.Done()
-			worker(ctx, jobs, cursors, opts.PendingCap)
+			defer workers.Done()
+			worker(ctx, jobs, cursors, opts.PendingCap, handler)
 		}()
 	}
 
 	go func() {
-		wg.Wait()
+		workers.Wait()
 		close(cursors)
 	}()
 
+	// finish reports the aggregator's error only once the workers have
+	// exited, so a caller that sees the stream end may reuse its options.
+	finish := func(err error) {
+		if err != nil {
+			cancel()
+		}
+		workers.Wait()
+		if err != nil {
+			errCh <- err
+		}
+	}
+
+	if opts.Ordering == OrderRelaxed {
+		go func() {
+			defer cancel()
+			defer close(out)
+			defer close(errCh)
+			finish(runRelaxedAggregator(ctx, cursors, producerErr, out, start, lastEpoch, opts.ReorderWindow, opts.NumWorkers, handler))
+		}()
+		return out, errCh, nil
+	}
+	if !shuffle.enabled() {
+		go func() {
+			defer cancel()
+			defer close(out)
+			defer close(errCh)
+			finish(runAggregator(ctx, cursors, producerErr, out, opts.Seed, start.JobID, lastEpoch, handler))
+		}()
+		return out, errCh, nil
+	}
+
+	// The aggregator closes errCh before its output, so a consumer that
+	// sees out closed can still read the final error.
+	ordered := make(chan Sample, opts.NumWorkers*2)
+	go func() {
+		defer close(ordered)
+		defer close(errCh)
+		finish(runAggregator(ctx, cursors, producerErr, ordered, opts.Seed, start.JobID, lastEpoch, handler))
+	}()
 	go func() {
 		defer cancel()
 		defer close(out)
-		defer close(errCh)
-		runAggregator(ctx, cursors, out, errCh)
+		shuffle.run(ctx, ordered, out, start)
 	}()
 
 	return out, errCh, nil
 }
 
 type shardJob struct {
-	id   int64
-	root string
-	path string
+	id    int64
+	root  string
+	path  string
+	epoch int64
+	index int
+	// skip is the number of leading samples already delivered before a
+	// resume.
+	skip int64
+}
+
+// state returns the sampler cursor after delivered samples of the job.
+func (j shardJob) state(seed int64, delivered int64) SamplerState {
+	return SamplerState{
+		Seed:   seed,
+		Epoch:  j.epoch,
+		Index:  j.index,
+		JobID:  j.id,
+		Shard:  j.path,
+		Offset: delivered,
+	}
 }
 
 type shardCursor struct {
-	id      int64
+	job     shardJob
 	samples <-chan Sample
 	errCh   <-chan error
+	// stats is filled in by the shard reader and must only be read after
+	// errCh has delivered.
+	stats *ShardStats
 }
 
-func worker(ctx context.Context, jobs <-chan shardJob, cursors chan<- shardCursor, pendingCap int) {
+func worker(ctx context.Context, jobs <-chan shardJob, cursors chan<- shardCursor, pendingCap int, handler *errorHandler) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -95,8 +283,20 @@ func worker(ctx context.Context, jobs <-chan shardJob, cursors chan<- shardCurso
 			if !ok {
 				return
 			}
-			samples, errCh := StreamShard(ctx, job.path, pendingCap)
-			cursor := shardCursor{id: job.id, samples: samples, errCh: errCh}
+			stats := &ShardStats{}
+			var samples <-chan Sample
+			var errCh <-chan error
+			check, err := handler.verify.check(job.path, func(err error) {
+				handler.obs.ChecksumFailed(job.root, job.path, err)
+			})
+			if err != nil {
+				samples, errCh = failedShard(err)
+			} else {
+				samples, errCh = streamShard(ctx, job.path, pendingCap, stats, func(latency time.Duration) {
+					handler.obs.ShardOpened(job.root, job.path, latency)
+				}, handler.sampleSkipper(job.root, job.path), check)
+			}
+			cursor := shardCursor{job: job, samples: samples, errCh: errCh, stats: stats}
 			select {
 			case <-ctx.Done():
 				return
@@ -106,76 +306,189 @@ func worker(ctx context.Context, jobs <-chan shardJob, cursors chan<- shardCurso
 	}
 }
 
-func runAggregator(ctx context.Context, cursors <-chan shardCursor, out chan<- Sample, errCh chan<- error) {
+// failedShard returns the channels of a shard that ends with err before
+// its first sample.
+func failedShard(err error) (<-chan Sample, <-chan error) {
+	samples := make(chan Sample)
+	close(samples)
+	errCh := make(chan error, 1)
+	errCh <- err
+	close(errCh)
+	return samples, errCh
+}
+
+// runAggregator delivers shards in job order. lastEpoch is the epoch of the
+// sample preceding the stream, used to flag Sample.EpochStart. When the
+// producer finishes, the stream ends with its error, if any. It returns the
+// error that ends the stream, or nil when ctx is done or the shards run out.
+func runAggregator(ctx context.Context, cursors <-chan shardCursor, producerErr <-chan error, out chan<- Sample, seed, nextID, lastEpoch int64, handler *errorHandler) error {
+	obs := handler.obs
 	pending := make(map[int64]shardCursor)
-	var nextID int64
 	for {
 		cursor, ok := pending[nextID]
 		if !ok {
-			var received bool
 			select {
 			case <-ctx.Done():
-				return
+				return nil
 			case cursor, ok = <-cursors:
 				if !ok {
-					return
+					select {
+					case err := <-producerErr:
+						return err
+					default:
+					}
+					return nil
 				}
-				pending[cursor.id] = cursor
-				received = true
-			}
-			if !received {
-				continue
+				pending[cursor.job.id] = cursor
 			}
 			continue
 		}
 
+		var delivered int64
 		for {
 			select {
 			case <-ctx.Done():
-				return
+				return nil
 			case sample, ok := <-cursor.samples:
 				if !ok {
 					goto shardDone
 				}
+				delivered++
+				if delivered <= cursor.job.skip {
+					continue
+				}
+				sample.Root = cursor.job.root
+				sample.State = cursor.job.state(seed, delivered)
+				sample.Epoch = cursor.job.epoch
+				if cursor.job.epoch != lastEpoch {
+					sample.EpochStart = true
+					lastEpoch = cursor.job.epoch
+				}
 				select {
 				case <-ctx.Done():
-					return
+					return nil
 				case out <- sample:
+					obs.SampleDelivered(cursor.job.root)
 				}
 			}
 		}
 
 	shardDone:
-		if err := <-cursor.errCh; err != nil && !errors.Is(err, context.Canceled) {
-			errCh <- err
-			return
+		err := <-cursor.errCh
+		for range cursor.errCh {
+			// Wait for the reader to exit so its stats are final.
+		}
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		stats := *cursor.stats
+		stats.Samples = delivered - cursor.job.skip
+		if stats.Samples < 0 {
+			stats.Samples = 0
+		}
+		obs.ShardDone(cursor.job.root, cursor.job.path, stats, err)
+		if err != nil {
+			if err := handler.shardFailed(cursor.job.root, cursor.job.path, err); err != nil {
+				return err
+			}
 		}
 		delete(pending, nextID)
 		nextID++
 	}
 }
 
-func produceJobs(ctx context.Context, jobs chan<- shardJob, roots map[string][]string, rng *rand.Rand) {
-	var jobID int64
-	for {
-		order := buildRoundRobinOrder(roots, rng)
-		if len(order) == 0 {
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(500 * time.Millisecond):
-			}
-			continue
+// produceJobs emits the shard jobs of each epoch in turn, skipping
+// quarantined shards, and closes jobs after the last epoch. If a whole epoch
+// yields nothing, which happens once every shard has been quarantined, it
+// reports that on errs and stops.
+func produceJobs(ctx context.Context, jobs chan<- shardJob, errs chan<- error, buildOrder func(int64) []orderEntry, order []orderEntry, start SamplerState, epochs int64, quarantine *Quarantine) {
+	defer close(jobs)
+	jobID := start.JobID
+	epoch := start.Epoch
+	index := start.Index
+	skip := start.Offset
+	for ; epochs <= 0 || epoch < epochs; epoch++ {
+		if order == nil {
+			order = buildOrder(epoch)
 		}
-		for _, entry := range order {
+		fullPass := index == 0
+		emitted := false
+		for ; index < len(order); index++ {
+			entry := order[index]
+			if quarantine.Contains(entry.path) {
+				skip = 0
+				continue
+			}
+			job := shardJob{
+				id:    jobID,
+				root:  entry.root,
+				path:  entry.path,
+				epoch: epoch,
+				index: index,
+				skip:  skip,
+			}
 			select {
 			case <-ctx.Done():
 				return
-			case jobs <- shardJob{id: jobID, root: entry.root, path: entry.path}:
+			case jobs <- job:
 				jobID++
+				skip = 0
+				emitted = true
+			}
+		}
+		if fullPass && !emitted {
+			errs <- errors.New("sampler: every shard is quarantined")
+			return
+		}
+		index = 0
+		order = nil
+	}
+}
+
+func countQuarantined(roots map[string][]string, quarantine *Quarantine) int {
+	count := 0
+	for _, shards := range roots {
+		for _, shard := range shards {
+			if quarantine.Contains(shard) {
+				count++
 			}
 		}
 	}
+	return count
+}
+
+// EpochOrder returns the shard paths of one epoch in the order the sampler
+// reads them (ignoring quarantine). It matches StartSampler for the same
+// roots, weights, seed and rank.
+func EpochOrder(opts SamplerOptions, epoch int64) []string {
+	opts.Seed = samplerSeed(opts.Seed)
+	order := rankOrder(opts, epoch)
+	paths := make([]string, len(order))
+	for i, entry := range order {
+		paths[i] = entry.path
+	}
+	return paths
+}
+
+// rankOrder is the share of one epoch's order read by opts.Rank.
+func rankOrder(opts SamplerOptions, epoch int64) []orderEntry {
+	order := buildEpochOrder(opts.Roots, opts.Weights, opts.Seed, epoch)
+	return partitionOrder(order, opts.Rank, opts.WorldSize, opts.Partition, epoch)
+}
+
+func samplerSeed(seed int64) int64 {
+	if seed == 0 {
+		return 42
+	}
+	return seed
+}
+
+func buildEpochOrder(roots map[string][]string, weights map[string]float64, seed, epoch int64) []orderEntry {
+	rng := rand.New(rand.NewSource(EpochSeed(seed, epoch)))
+	if weights != nil {
+		return buildWeightedOrder(roots, weights, rng)
+	}
+	return buildRoundRobinOrder(roots, rng)
 }
 
 type orderEntry struct {
@@ -184,21 +497,7 @@ type orderEntry struct {
 }
 
 func buildRoundRobinOrder(roots map[string][]string, rng *rand.Rand) []orderEntry {
-	rootNames := make([]string, 0, len(roots))
-	copied := make(map[string][]string, len(roots))
-	for root, shards := range roots {
-		if len(shards) == 0 {
-			continue
-		}
-		rootNames = append(rootNames, root)
-		copied[root] = append([]string(nil), shards...)
-		if rng != nil {
-			rng.Shuffle(len(copied[root]), func(i, j int) {
-				copied[root][i], copied[root][j] = copied[root][j], copied[root][i]
-			})
-		}
-	}
-	sort.Strings(rootNames)
+	rootNames, copied := shuffleRoots(roots, nil, rng)
 	var order []orderEntry
 	for {
 		advanced := false
@@ -217,3 +516,75 @@ func buildRoundRobinOrder(roots map[string][]string, rng *rand.Rand) []orderEntr
 	}
 	return order
 }
+
+// buildWeightedOrder draws one pass worth of shards (the total shard count
+// of all weighted roots), picking the root for each slot at random in
+// proportion to its weight. A root that runs out of shards mid-pass is
+// reshuffled and reused, so the mixture holds regardless of shard counts.
+func buildWeightedOrder(roots map[string][]string, weights map[string]float64, rng *rand.Rand) []orderEntry {
+	rootNames, copied := shuffleRoots(roots, weights, rng)
+	total := 0.0
+	length := 0
+	for _, root := range rootNames {
+		total += weights[root]
+		length += len(copied[root])
+	}
+	if length == 0 {
+		return nil
+	}
+	order := make([]orderEntry, 0, length)
+	next := make(map[string]int, len(rootNames))
+	for len(order) < length {
+		pick := rootNames[len(rootNames)-1]
+		r := rng.Float64() * total
+		for _, root := range rootNames {
+			r -= weights[root]
+			if r < 0 {
+				pick = root
+				break
+			}
+		}
+		shards := copied[pick]
+		idx := next[pick]
+		if idx == len(shards) {
+			shuffleStrings(shards, rng)
+			idx = 0
+		}
+		order = append(order, orderEntry{root: pick, path: shards[idx]})
+		next[pick] = idx + 1
+	}
+	return order
+}
+
+// shuffleRoots returns the sorted names of roots that have shards (and a
+// positive weight, when weights is non-nil) together with a shuffled copy of
+// each root's shard list. Roots are shuffled in name order so the result
+// depends only on the RNG state.
+func shuffleRoots(roots map[string][]string, weights map[string]float64, rng *rand.Rand) ([]string, map[string][]string) {
+	rootNames := make([]string, 0, len(roots))
+	for root, shards := range roots {
+		if len(shards) == 0 {
+			continue
+		}
+		if weights != nil && weights[root] <= 0 {
+			continue
+		}
+		rootNames = append(rootNames, root)
+	}
+	sort.Strings(rootNames)
+	copied := make(map[string][]string, len(rootNames))
+	for _, root := range rootNames {
+		copied[root] = append([]string(nil), roots[root]...)
+		shuffleStrings(copied[root], rng)
+	}
+	return rootNames, copied
+}
+
+func shuffleStrings(values []string, rng *rand.Rand) {
+	if rng == nil {
+		return
+	}
+	rng.Shuffle(len(values), func(i, j int) {
+		values[i], values[j] = values[j], values[i]
+	})
+}
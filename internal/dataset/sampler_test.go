@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"fmt"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -37,6 +38,46 @@ func TestBuildRoundRobinOrderDeterministic(t *testing.T) {
 	}
 }
 
+func TestBuildWeightedOrderRatio(t *testing.T) {
+	roots := map[string][]string{"small": nil, "large": nil}
+	for i := 0; i < 5; i++ {
+		roots["small"] = append(roots["small"], fmt.Sprintf("/small/shard-%06d.tar", i))
+	}
+	for i := 0; i < 500; i++ {
+		roots["large"] = append(roots["large"], fmt.Sprintf("/large/shard-%06d.tar", i))
+	}
+	weights := map[string]float64{"small": 0.7, "large": 0.3}
+
+	order1 := buildWeightedOrder(roots, weights, rand.New(rand.NewSource(9)))
+	order2 := buildWeightedOrder(roots, weights, rand.New(rand.NewSource(9)))
+	if !reflect.DeepEqual(order1, order2) {
+		t.Fatal("weighted order not deterministic for equal seeds")
+	}
+	if len(order1) != 505 {
+		t.Fatalf("expected 505 entries, got %d", len(order1))
+	}
+	small := 0
+	for _, entry := range order1 {
+		if entry.root == "small" {
+			small++
+		}
+	}
+	share := float64(small) / float64(len(order1))
+	if share < 0.6 || share > 0.8 {
+		t.Fatalf("expected ~70%% of shards from small root, got %.2f", share)
+	}
+}
+
+func TestSamplerRejectsUnknownWeight(t *testing.T) {
+	_, _, err := StartSampler(context.Background(), SamplerOptions{
+		Roots:   map[string][]string{"a": {"/a/shard-000000.tar"}},
+		Weights: map[string]float64{"b": 1},
+	})
+	if err == nil {
+		t.Fatal("expected error for weight on unknown root")
+	}
+}
+
 func TestSamplerDeterministicStream(t *testing.T) {
 	temp := t.TempDir()
 	rootA := filepath.Join(temp, "rootA")
@@ -67,6 +108,140 @@ func TestSamplerDeterministicStream(t *testing.T) {
 	}
 }
 
+func TestSamplerResumeContinuesStream(t *testing.T) {
+	roots := mustRoots(t, t.TempDir(), 4, 3)
+	opts := SamplerOptions{Roots: roots, Seed: 5, NumWorkers: 3}
+
+	full := collectStream(t, opts, 20)
+	for _, cut := range []int{0, 2, 3, 11, 12} {
+		resumed := opts
+		state := full[cut].State
+		resumed.Resume = &state
+		rest := collectStream(t, resumed, len(full)-cut-1)
+		for i, sample := range rest {
+			if want := full[cut+1+i]; sample.Key != want.Key || sample.State != want.State {
+				t.Fatalf("cut %d: sample %d = %s %+v, want %s %+v", cut, i, sample.Key, sample.State, want.Key, want.State)
+			}
+		}
+	}
+
+	bad := opts
+	bad.Resume = &SamplerState{Seed: 5, Shard: "/missing.tar"}
+	if _, _, err := StartSampler(context.Background(), bad); err == nil {
+		t.Fatal("expected error resuming from a state that does not match the dataset")
+	}
+}
+
+func TestSamplerFiniteEpochs(t *testing.T) {
+	roots := mustRoots(t, t.TempDir(), 4, 2)
+	opts := SamplerOptions{Roots: roots, Seed: 3, NumWorkers: 2, Epochs: 3}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, errCh, err := StartSampler(ctx, opts)
+	if err != nil {
+		t.Fatalf("StartSampler: %v", err)
+	}
+	var samples []Sample
+	for sample := range stream {
+		samples = append(samples, sample)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("sampler error: %v", err)
+	}
+	if len(samples) != 3*8 {
+		t.Fatalf("expected 24 samples over 3 epochs, got %d", len(samples))
+	}
+	for i, sample := range samples {
+		epoch := int64(i / 8)
+		if sample.State.Epoch != epoch || sample.EpochStart != (i%8 == 0) {
+			t.Fatalf("sample %d: epoch %d start %v", i, sample.State.Epoch, sample.EpochStart)
+		}
+		order := EpochOrder(opts, epoch)
+		if want := order[(i%8)/2]; sample.Shard != want {
+			t.Fatalf("sample %d from %s, EpochOrder says %s", i, sample.Shard, want)
+		}
+	}
+	reshuffled := false
+	for epoch := int64(1); epoch < 10; epoch++ {
+		if !reflect.DeepEqual(EpochOrder(opts, 0), EpochOrder(opts, epoch)) {
+			reshuffled = true
+		}
+	}
+	if !reshuffled {
+		t.Fatal("expected epochs to be reshuffled")
+	}
+}
+
+func TestSamplerReportsShardIO(t *testing.T) {
+	temp := t.TempDir()
+	path := filepath.Join(temp, "cac", "shard-000000.tar")
+	mustShard(t, path, map[string]int{"a": 1, "b": 2})
+	obs := &recordingObserver{done: make(chan ShardStats, 8)}
+	opts := SamplerOptions{Roots: map[string][]string{"cac": {path}}, Seed: 1, Observer: obs, Epochs: 1}
+
+	samples := drainSampler(t, opts)
+	for _, sample := range samples {
+		if sample.Root != "cac" || sample.Shard != path {
+			t.Fatalf("sample not tagged with its source: root=%q shard=%q", sample.Root, sample.Shard)
+		}
+	}
+	select {
+	case stats := <-obs.done:
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		if stats.Bytes != info.Size() || stats.Samples != 2 || stats.TTFB <= 0 {
+			t.Fatalf("unexpected shard stats %+v (file size %d)", stats, info.Size())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for ShardDone")
+	}
+}
+
+type recordingObserver struct {
+	nopObserver
+	done chan ShardStats
+}
+
+func (o *recordingObserver) ShardOpened(string, string, time.Duration) {}
+func (o *recordingObserver) SampleDelivered(string)                    {}
+func (o *recordingObserver) ShardDone(_, _ string, stats ShardStats, _ error) {
+	select {
+	case o.done <- stats:
+	default:
+	}
+}
+
+func collectStream(t *testing.T, opts SamplerOptions, count int) []Sample {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, errCh, err := StartSampler(ctx, opts)
+	if err != nil {
+		t.Fatalf("StartSampler error: %v", err)
+	}
+	out := make([]Sample, 0, count)
+	deadline := time.After(2 * time.Second)
+	for len(out) < count {
+		select {
+		case sample, ok := <-stream:
+			if !ok {
+				t.Fatalf("stream closed early; collected %d samples", len(out))
+			}
+			out = append(out, sample)
+		case err := <-errCh:
+			if err != nil {
+				t.Fatalf("sampler reported error: %v", err)
+			}
+		case <-deadline:
+			t.Fatal("timed out waiting for samples")
+		}
+	}
+	return out
+}
+
 func collectSamples(t *testing.T, opts SamplerOptions, count int) []string {
 	ctx, cancel := context.WithCancel(context.Background())
 	stream, errCh, err := StartSampler(ctx, opts)
@@ -120,6 +295,25 @@ func mustShard(t *testing.T, path string, samples map[string]int) {
 	}
 }
 
+// mustRoots writes count shards of perShard samples under dir, alternating
+// between roots "root0" and "root1". Shard i holds keys "s<i>_<j>" with
+// label j.
+func mustRoots(t *testing.T, dir string, count, perShard int) map[string][]string {
+	t.Helper()
+	roots := map[string][]string{}
+	for i := 0; i < count; i++ {
+		root := fmt.Sprintf("root%d", i%2)
+		path := filepath.Join(dir, root, fmt.Sprintf("shard-%06d.tar", i))
+		samples := map[string]int{}
+		for j := 0; j < perShard; j++ {
+			samples[fmt.Sprintf("s%d_%d", i, j)] = j
+		}
+		mustShard(t, path, samples)
+		roots[root] = append(roots[root], path)
+	}
+	return roots
+}
+
 func addTarPayload(t *testing.T, tw *tar.Writer, name string, data []byte) {
 	t.Helper()
 	hdr := &tar.Header{Name: name, Size: int64(len(data)), Mode: 0o644}
@@ -130,3 +324,20 @@ func addTarPayload(t *testing.T, tw *tar.Writer, name string, data []byte) {
 		t.Fatalf("write data: %v", err)
 	}
 }
+
+// drainSampler reads a finite sampler to the end.
+func drainSampler(t *testing.T, opts SamplerOptions) []Sample {
+	t.Helper()
+	stream, errCh, err := StartSampler(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("StartSampler: %v", err)
+	}
+	var out []Sample
+	for sample := range stream {
+		out = append(out, sample)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("sampler error: %v", err)
+	}
+	return out
+}
@@ -0,0 +1,185 @@
+package dataset
+
+import (
+	"context"
+	"math/rand"
+)
+
+// shuffleBuffer mixes samples from consecutive shards with a rolling
+// buffer. Each epoch it fills N slots from the in-order stream, N being the
+// sample limit or the count at which the byte limit is reached. Every later
+// sample then replaces the sample of a randomly picked slot, which is
+// emitted, and at the end of the epoch the buffer is drained in random
+// order, so epochs never mix.
+//
+// The slot replaced at each step is a hash of the seed, the epoch and the
+// step number, so which in-order samples the buffer holds after any step
+// can be recomputed without reading them. A sample's resume state is the
+// in-order cursor before the oldest sample still buffered plus the slot
+// count, steps and drained slots of the epoch; a resume reads on from that
+// cursor and refills only the samples that were still buffered.
+type shuffleBuffer struct {
+	seed     int64
+	maxCount int
+	maxBytes int64
+}
+
+func (b shuffleBuffer) enabled() bool {
+	return b.maxCount > 0 || b.maxBytes > 0
+}
+
+// slot returns the slot that step of epoch replaces.
+func (b shuffleBuffer) slot(epoch, step int64, slots int) int {
+	return int(uint64(EpochSeed(EpochSeed(^b.seed, epoch), step)) % uint64(slots))
+}
+
+// drainOrder returns the order in which the slots are emitted at the end of
+// epoch.
+func (b shuffleBuffer) drainOrder(epoch int64, slots int) []int {
+	return rand.New(rand.NewSource(EpochSeed(^b.seed, ^epoch))).Perm(slots)
+}
+
+// buffered is a sample held in a slot, with its position in the epoch's
+// in-order stream.
+type buffered struct {
+	sample Sample
+	pos    int64
+	held   bool
+}
+
+// run shuffles in into out, starting from the state of the last sample
+// delivered before a resume, or the zero shuffle state of a fresh stream.
+func (b shuffleBuffer) run(ctx context.Context, in <-chan Sample, out chan<- Sample, start SamplerState) {
+	epoch := start.Epoch
+	n := start.ShuffleSlots
+	steps := start.ShuffleSteps
+	drained := start.ShuffleDrained
+	pos := start.ShufflePos
+	// last is the in-order cursor after the latest sample taken in.
+	last := start
+	last.ShuffleSlots, last.ShuffleSteps, last.ShuffleDrained, last.ShufflePos = 0, 0, 0, 0
+	var slots []buffered
+	var size int64
+	// keep maps the position of every sample still buffered at a resume to
+	// its slot; arrivals before steps+n that it lacks were already emitted.
+	var keep map[int64]int
+	if n > 0 {
+		holder := make([]int64, n)
+		for k := range holder {
+			holder[k] = int64(k)
+		}
+		for s := int64(0); s < steps; s++ {
+			holder[b.slot(epoch, s, n)] = s + int64(n)
+		}
+		keep = make(map[int64]int, n)
+		for k, p := range holder {
+			keep[p] = k
+		}
+		slots = make([]buffered, n)
+	}
+	emitted := steps + int64(drained)
+	oldest := -1
+
+	findOldest := func() {
+		oldest = -1
+		for k, s := range slots {
+			if s.held && (oldest < 0 || s.pos < slots[oldest].pos) {
+				oldest = k
+			}
+		}
+	}
+	// state is the resume state after the current step.
+	state := func() SamplerState {
+		if oldest < 0 {
+			return last
+		}
+		// The in-order cursor before the oldest buffered sample.
+		st := slots[oldest].sample.State
+		st.Offset--
+		st.ShuffleSlots, st.ShuffleSteps, st.ShuffleDrained, st.ShufflePos = n, steps, drained, slots[oldest].pos
+		return st
+	}
+	emit := func(sample Sample, st SamplerState) bool {
+		sample.EpochStart = emitted == 0
+		sample.State = st
+		emitted++
+		select {
+		case <-ctx.Done():
+			return false
+		case out <- sample:
+			return true
+		}
+	}
+	drain := func() bool {
+		if n == 0 {
+			n = len(slots)
+		}
+		order := b.drainOrder(epoch, n)
+		// Slots drained before a resume were refilled by the replay.
+		for _, k := range order[:drained] {
+			slots[k] = buffered{}
+		}
+		findOldest()
+		for i, k := range order {
+			if i < drained {
+				continue
+			}
+			sample := slots[k].sample
+			slots[k] = buffered{}
+			drained = i + 1
+			if k == oldest {
+				findOldest()
+			}
+			if !emit(sample, state()) {
+				return false
+			}
+		}
+		slots, keep, oldest = nil, nil, -1
+		n, steps, drained, pos, size, emitted = 0, 0, 0, 0, 0, 0
+		return true
+	}
+
+	for sample := range in {
+		if sample.Epoch != epoch {
+			if !drain() {
+				return
+			}
+			epoch = sample.Epoch
+		}
+		last = sample.State
+		p := pos
+		pos++
+		switch {
+		case keep != nil && p < steps+int64(n):
+			// Replaying up to the resume point.
+			if k, ok := keep[p]; ok {
+				slots[k] = buffered{sample: sample, pos: p, held: true}
+			}
+			if p == steps+int64(n)-1 {
+				keep = nil
+				findOldest()
+			}
+		case n == 0:
+			slots = append(slots, buffered{sample: sample, pos: p, held: true})
+			size += int64(len(sample.Image))
+			if (b.maxCount > 0 && len(slots) >= b.maxCount) || (b.maxBytes > 0 && size >= b.maxBytes) {
+				n = len(slots)
+				findOldest()
+			}
+		default:
+			k := b.slot(epoch, steps, n)
+			prev := slots[k].sample
+			slots[k] = buffered{sample: sample, pos: p, held: true}
+			steps++
+			if k == oldest {
+				findOldest()
+			}
+			if !emit(prev, state()) {
+				return
+			}
+		}
+	}
+	if ctx.Err() == nil {
+		drain()
+	}
+}
@@ -0,0 +1,116 @@
+package dataset
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSamplerShuffleBufferResumes(t *testing.T) {
+	roots := mustRoots(t, t.TempDir(), 4, 5)
+	opts := SamplerOptions{Roots: roots, Seed: 11, NumWorkers: 3, Epochs: 2, ShuffleSamples: 8}
+
+	full := drainSampler(t, opts)
+	if len(full) != 40 {
+		t.Fatalf("expected 40 samples, got %d", len(full))
+	}
+	if again := drainSampler(t, opts); !sameKeys(full, again) {
+		t.Fatal("shuffled stream is not deterministic")
+	}
+
+	// The first block must hold samples from more than one shard and must
+	// not be in tar order.
+	shards := map[string]bool{}
+	for _, sample := range full[:8] {
+		shards[sample.Shard] = true
+	}
+	unshuffled := opts
+	unshuffled.ShuffleSamples = 0
+	if len(shards) < 2 || reflect.DeepEqual(sampleKeys(full), sampleKeys(drainSampler(t, unshuffled))) {
+		t.Fatalf("shuffle buffer did not mix shards: %v", shards)
+	}
+
+	// The buffer rolls rather than working in blocks: later samples overtake
+	// ones taken in before them, and early samples linger past the first
+	// buffer's worth of output.
+	inOrder := map[string]int{}
+	for i, sample := range drainSampler(t, unshuffled)[:20] {
+		inOrder[sample.Key] = i
+	}
+	overtaken, lingered := false, false
+	for i, sample := range full[:20] {
+		overtaken = overtaken || i < 8 && inOrder[sample.Key] >= 8
+		lingered = lingered || i >= 8 && inOrder[sample.Key] < 8
+	}
+	if !overtaken || !lingered {
+		t.Fatalf("expected samples to cross the first 8 outputs both ways, got overtaken=%t lingered=%t", overtaken, lingered)
+	}
+
+	starts := 0
+	for i, sample := range full {
+		if sample.EpochStart {
+			starts++
+			if i != 0 && i != 20 {
+				t.Fatalf("epoch start flagged at sample %d", i)
+			}
+		}
+		if sample.Epoch != int64(i/20) {
+			t.Fatalf("sample %d has epoch %d", i, sample.Epoch)
+		}
+	}
+	if starts != 2 {
+		t.Fatalf("expected 2 epoch starts, got %d", starts)
+	}
+
+	for cut := range full[:len(full)-1] {
+		resumed := opts
+		state := full[cut].State
+		resumed.Resume = &state
+		rest := drainSampler(t, resumed)
+		if !sameKeys(full[cut+1:], rest) {
+			t.Fatalf("cut %d: resumed stream diverged", cut)
+		}
+	}
+}
+
+func TestSamplerShuffleBufferBytesResumes(t *testing.T) {
+	roots := mustRoots(t, t.TempDir(), 4, 5)
+	opts := SamplerOptions{Roots: roots, Seed: 5, NumWorkers: 2, Epochs: 2}
+	probe := drainSampler(t, opts)
+	// Every image is 4 bytes, so the byte limit sizes the buffer at the
+	// sixth sample.
+	opts.ShuffleBytes = int64(6 * len(probe[0].Image))
+	full := drainSampler(t, opts)
+	if len(full) != 40 || full[0].State.ShuffleSlots != 6 {
+		t.Fatalf("expected 40 samples from a 6-slot buffer, got %d and %d slots", len(full), full[0].State.ShuffleSlots)
+	}
+	for cut := range full[:len(full)-1] {
+		resumed := opts
+		state := full[cut].State
+		resumed.Resume = &state
+		if rest := drainSampler(t, resumed); !sameKeys(full[cut+1:], rest) {
+			t.Fatalf("cut %d: resumed stream diverged", cut)
+		}
+	}
+}
+
+func sampleKeys(samples []Sample) []string {
+	keys := make([]string, len(samples))
+	for i, sample := range samples {
+		keys[i] = sample.Key
+	}
+	return keys
+}
+
+// sameKeys reports whether a and b hold the same samples with the same
+// resume states.
+func sameKeys(a, b []Sample) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || a[i].State != b[i].State {
+			return false
+		}
+	}
+	return true
+}
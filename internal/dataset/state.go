@@ -0,0 +1,40 @@
+package dataset
+
+// SamplerState is a serializable cursor into the sampler's output stream.
+// Every Sample carries the state immediately after itself; passing that
+// value back as SamplerOptions.Resume restarts the stream with the next
+// sample, reproducing the remainder of the original sequence exactly.
+type SamplerState struct {
+	// Seed is the sampler seed the state was produced with.
+	Seed int64 `json:"seed"`
+	// Epoch is the zero-based pass over the shards that Shard belongs to.
+	Epoch int64 `json:"epoch"`
+	// Index is the position of Shard within the epoch's shard order.
+	Index int `json:"index"`
+	// JobID is the global sequence number of Shard.
+	JobID int64 `json:"job_id"`
+	// Shard is the path of the shard currently being read.
+	Shard string `json:"shard"`
+	// Offset is the number of samples of Shard already delivered.
+	Offset int64 `json:"offset"`
+	// ShuffleSlots, ShuffleSteps and ShuffleDrained are the size of the
+	// shuffle buffer, the samples it has exchanged and the slots it has
+	// drained in this epoch. With shuffling enabled the fields above point
+	// at the in-order position before the oldest sample still buffered,
+	// which is sample ShufflePos of the epoch.
+	ShuffleSlots   int   `json:"shuffle_slots"`
+	ShuffleSteps   int64 `json:"shuffle_steps"`
+	ShuffleDrained int   `json:"shuffle_drained"`
+	ShufflePos     int64 `json:"shuffle_pos"`
+}
+
+// EpochSeed derives the seed that shuffles epoch from the sampler seed. Each
+// epoch's order depends only on (seed, epoch), so it can be recomputed
+// without replaying earlier epochs.
+func EpochSeed(seed, epoch int64) int64 {
+	// splitmix64 finaliser over seed and epoch.
+	z := uint64(seed) + uint64(epoch+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
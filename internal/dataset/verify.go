@@ -0,0 +1,151 @@
+package dataset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// ErrChecksumMismatch is wrapped by the error of a shard whose contents do
+// not match its expected checksum.
+var ErrChecksumMismatch = errors.New("webdataset: checksum mismatch")
+
+// Checksum is the expected digest of a shard file.
+type Checksum struct {
+	// Algorithm is "sha256" or "crc32c" (Castagnoli).
+	Algorithm string
+	// Hex is the digest, hex-encoded; a CRC32C is 8 digits, big-endian.
+	Hex string
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func (c Checksum) newHash() hash.Hash {
+	if c.Algorithm == "crc32c" {
+		return crc32.New(castagnoli)
+	}
+	return sha256.New()
+}
+
+// verify compares the digest accumulated in h with c.
+func (c Checksum) verify(path string, h hash.Hash) error {
+	got := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(got, c.Hex) {
+		return fmt.Errorf("%w: %s has %s %s, want %s", ErrChecksumMismatch, path, c.Algorithm, got, c.Hex)
+	}
+	return nil
+}
+
+// MismatchAction decides what the sampler does with a shard that fails
+// verification.
+type MismatchAction int
+
+const (
+	// MismatchFail ends the stream with the mismatch, whatever the error
+	// policy.
+	MismatchFail MismatchAction = iota
+	// MismatchSkip drops the shard, as PolicySkipShard would, without
+	// spending the error budget.
+	MismatchSkip
+	// MismatchReread reads the shard again, up to Verifier.Rereads times,
+	// and fails if it still does not match.
+	MismatchReread
+)
+
+// ParseMismatchAction maps a checksum_mismatch value to a MismatchAction.
+func ParseMismatchAction(s string) (MismatchAction, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "fail":
+		return MismatchFail, nil
+	case "skip":
+		return MismatchSkip, nil
+	case "reread":
+		return MismatchReread, nil
+	}
+	return MismatchFail, fmt.Errorf("unknown checksum mismatch action %q (want fail, skip or reread)", s)
+}
+
+func (a MismatchAction) String() string {
+	switch a {
+	case MismatchSkip:
+		return "skip"
+	case MismatchReread:
+		return "reread"
+	}
+	return "fail"
+}
+
+const defaultRereads = 2
+
+// Verifier checks shards against their expected checksums while they are
+// streamed. A shard's samples are only delivered once the whole file has
+// been read and matched, so each worker holds up to one shard in memory.
+type Verifier struct {
+	// Checksums holds the expected digest of shards by path, typically from
+	// the roots' manifests. A shard without one is checked against its
+	// ".sha256" sidecar when there is one, and read unverified otherwise.
+	Checksums map[string]Checksum
+	// OnMismatch is the action taken when a shard does not match.
+	OnMismatch MismatchAction
+	// Rereads caps the extra reads under MismatchReread (default 2).
+	Rereads int
+}
+
+// ChecksumPath returns the path of the SHA-256 sidecar for shard.
+func ChecksumPath(shard string) string {
+	return shard + ".sha256"
+}
+
+// checksum returns the expected digest of path, or false when it has none.
+func (v *Verifier) checksum(path string) (Checksum, bool, error) {
+	if sum, ok := v.Checksums[path]; ok {
+		return sum, true, nil
+	}
+	data, err := os.ReadFile(ChecksumPath(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Checksum{}, false, nil
+	}
+	if err != nil {
+		return Checksum{}, false, fmt.Errorf("read checksum: %w", err)
+	}
+	// Accept sha256sum output: the digest, then optionally the file name.
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return Checksum{}, false, fmt.Errorf("read checksum %s: not a SHA-256 digest", ChecksumPath(path))
+	}
+	return Checksum{Algorithm: "sha256", Hex: fields[0]}, true, nil
+}
+
+// check returns the streamShard verification for path, or nil when the
+// shard has no checksum. onMismatch is called for every failed read.
+func (v *Verifier) check(path string, onMismatch func(error)) (*shardCheck, error) {
+	if v == nil {
+		return nil, nil
+	}
+	sum, ok, err := v.checksum(path)
+	if err != nil || !ok {
+		return nil, err
+	}
+	c := &shardCheck{sum: sum, onMismatch: onMismatch}
+	if v.OnMismatch == MismatchReread {
+		c.rereads = v.Rereads
+		if c.rereads <= 0 {
+			c.rereads = defaultRereads
+		}
+	}
+	return c, nil
+}
+
+// shardCheck verifies one shard while streamShard reads it.
+type shardCheck struct {
+	sum Checksum
+	// rereads is the number of extra reads allowed after a mismatch.
+	rereads    int
+	onMismatch func(error)
+}
@@ -0,0 +1,215 @@
+package dataset
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type checksumObserver struct {
+	nopObserver
+	mu      sync.Mutex
+	failed  []string
+	skipped []string
+}
+
+func (o *checksumObserver) ChecksumFailed(_, path string, _ error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.failed = append(o.failed, path)
+}
+
+func (o *checksumObserver) ShardSkipped(_, path string, _ error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.skipped = append(o.skipped, path)
+}
+
+func fileSums(t *testing.T, path string) (string, string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read shard: %v", err)
+	}
+	sha := sha256.Sum256(data)
+	crc := crc32.New(castagnoli)
+	crc.Write(data)
+	return hex.EncodeToString(sha[:]), hex.EncodeToString(crc.Sum(nil))
+}
+
+func TestSamplerVerifiesChecksums(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "shard-000000.tar")
+	bad := filepath.Join(dir, "shard-000001.tar")
+	sidecar := filepath.Join(dir, "shard-000002.tar")
+	mustShard(t, good, map[string]int{"a": 1, "b": 2})
+	mustShard(t, bad, map[string]int{"c": 3})
+	mustShard(t, sidecar, map[string]int{"d": 4})
+	_, goodCRC := fileSums(t, good)
+	sideSHA, _ := fileSums(t, sidecar)
+	if err := os.WriteFile(ChecksumPath(sidecar), []byte(sideSHA+"  shard-000002.tar\n"), 0o644); err != nil {
+		t.Fatalf("write sidecar: %v", err)
+	}
+	sums := map[string]Checksum{
+		good: {Algorithm: "crc32c", Hex: goodCRC},
+		bad:  {Algorithm: "sha256", Hex: hex.EncodeToString(make([]byte, sha256.Size))},
+	}
+	opts := SamplerOptions{
+		Roots:    map[string][]string{"cac": {good, bad, sidecar}},
+		Seed:     1,
+		Epochs:   1,
+		Observer: &checksumObserver{},
+		Verify:   &Verifier{Checksums: sums},
+	}
+	if err := streamUntilError(t, opts); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+
+	obs := &checksumObserver{}
+	opts.Observer = obs
+	opts.Verify = &Verifier{Checksums: sums, OnMismatch: MismatchSkip}
+	opts.ErrorPolicy = PolicyFail
+	samples := drainSampler(t, opts)
+	if len(samples) != 3 {
+		t.Fatalf("expected the 3 samples of the verified shards, got %d", len(samples))
+	}
+	for _, sample := range samples {
+		if sample.Shard == bad {
+			t.Fatalf("delivered sample %s from the mismatching shard", sample.Key)
+		}
+	}
+	if len(obs.failed) != 1 || obs.failed[0] != bad || len(obs.skipped) != 1 {
+		t.Fatalf("expected one failure and skip for %s, got %v and %v", bad, obs.failed, obs.skipped)
+	}
+}
+
+func TestStreamShardRereadsMismatch(t *testing.T) {
+	dir := t.TempDir()
+	shard := filepath.Join(dir, "shard-000000.tar")
+	mustShard(t, shard, map[string]int{"a": 1})
+	want, _ := fileSums(t, shard)
+	stale := filepath.Join(dir, "stale.tar")
+	mustShard(t, stale, map[string]int{"a": 9})
+	data, _ := os.ReadFile(stale)
+	fresh, _ := os.ReadFile(shard)
+	if err := os.WriteFile(shard, data, 0o644); err != nil {
+		t.Fatalf("write stale shard: %v", err)
+	}
+
+	// The first read sees stale bytes; the cache is refreshed before the
+	// re-read.
+	mismatches := 0
+	check := &shardCheck{sum: Checksum{Algorithm: "sha256", Hex: want}, rereads: 2, onMismatch: func(error) {
+		mismatches++
+		os.WriteFile(shard, fresh, 0o644)
+	}}
+	stats := &ShardStats{}
+	samples, errCh := streamShard(context.Background(), shard, 0, stats, nil, nil, check)
+	var got []Sample
+	for sample := range samples {
+		got = append(got, sample)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+	if mismatches != 1 || len(got) != 1 || got[0].Label != 1 {
+		t.Fatalf("expected one mismatch then the fresh sample, got %d mismatches and %+v", mismatches, got)
+	}
+	if stats.Bytes != int64(len(data)+len(fresh)) {
+		t.Fatalf("expected both reads counted, got %d bytes", stats.Bytes)
+	}
+}
+
+func TestStreamShardReportsSkipsOfAcceptedReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	shard := filepath.Join(dir, "shard-000000.tar")
+	writeIrregularShard(t, shard)
+	want, _ := fileSums(t, shard)
+	fresh, _ := os.ReadFile(shard)
+	stale := filepath.Join(dir, "stale.tar")
+	writeIrregularShard(t, stale)
+	data, _ := os.ReadFile(stale)
+	// Flip a byte of the end-of-archive padding so the stale copy parses the
+	// same but hashes differently.
+	data[len(data)-1] ^= 1
+	if err := os.WriteFile(shard, data, 0o644); err != nil {
+		t.Fatalf("write stale shard: %v", err)
+	}
+
+	var skipped []string
+	check := &shardCheck{sum: Checksum{Algorithm: "sha256", Hex: want}, rereads: 1, onMismatch: func(error) {
+		os.WriteFile(shard, fresh, 0o644)
+	}}
+	samples, errCh := streamShard(context.Background(), shard, 0, nil, nil, func(key string, _ error) error {
+		skipped = append(skipped, key)
+		return nil
+	}, check)
+	var got []Sample
+	for sample := range samples {
+		got = append(got, sample)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+	if len(got) != 4 || len(skipped) != 2 {
+		t.Fatalf("expected 4 samples and the 2 skips of one read, got %d samples and skips %v", len(got), skipped)
+	}
+}
+
+func TestStreamShardTruncatedIsMismatch(t *testing.T) {
+	dir := t.TempDir()
+	shard := filepath.Join(dir, "shard-000000.tar")
+	mustShard(t, shard, map[string]int{"a": 1, "b": 2})
+	want, _ := fileSums(t, shard)
+	full, _ := os.ReadFile(shard)
+	truncate := func() {
+		if err := os.WriteFile(shard, full[:600], 0o644); err != nil {
+			t.Fatalf("truncate shard: %v", err)
+		}
+	}
+	stream := func(check *shardCheck) ([]Sample, error) {
+		samples, errCh := streamShard(context.Background(), shard, 0, nil, nil, nil, check)
+		var got []Sample
+		for sample := range samples {
+			got = append(got, sample)
+		}
+		return got, <-errCh
+	}
+
+	// A truncated read is a mismatch, so it is read again once the cache
+	// has been refreshed.
+	truncate()
+	mismatches := 0
+	got, err := stream(&shardCheck{sum: Checksum{Algorithm: "sha256", Hex: want}, rereads: 1, onMismatch: func(error) {
+		mismatches++
+		os.WriteFile(shard, full, 0o644)
+	}})
+	if err != nil || mismatches != 1 || len(got) != 2 {
+		t.Fatalf("expected one mismatch then both samples, got %d mismatches, %d samples and %v", mismatches, len(got), err)
+	}
+
+	truncate()
+	mismatches = 0
+	got, err = stream(&shardCheck{sum: Checksum{Algorithm: "sha256", Hex: want}, rereads: 1, onMismatch: func(error) { mismatches++ }})
+	if !errors.Is(err, ErrChecksumMismatch) || mismatches != 2 || len(got) != 0 {
+		t.Fatalf("expected ErrChecksumMismatch after two mismatches, got %v after %d with %d samples", err, mismatches, len(got))
+	}
+}
+
+func TestParseMismatchAction(t *testing.T) {
+	for in, want := range map[string]MismatchAction{"": MismatchFail, "skip": MismatchSkip, "REREAD": MismatchReread} {
+		got, err := ParseMismatchAction(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseMismatchAction(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := ParseMismatchAction("ignore"); err == nil {
+		t.Fatal("expected error for unknown action")
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Sample represents a paired record from a WebDataset shard.
@@ -18,6 +20,19 @@ type Sample struct {
 	Key   string
 	Image []byte
 	Label int
+	// Root is the name of the training root the sample came from; it is
+	// only set on samples delivered by StartSampler.
+	Root string
+	// Shard is the path of the shard the sample was read from.
+	Shard string
+	// State is the sampler cursor right after this sample; it is only set
+	// on samples delivered by StartSampler.
+	State SamplerState
+	// Epoch is the pass the sample was drawn in, and EpochStart marks the
+	// first sample StartSampler delivers from each epoch, i.e. the boundary
+	// after the previous epoch's last sample.
+	Epoch      int64
+	EpochStart bool
 }
 
 // ErrPendingOverflow indicates the pairing map exceeded the configured bound.
@@ -27,9 +42,24 @@ const defaultPendingCap = 1024
 
 // StreamShard streams paired samples from the shard at path.
 func StreamShard(ctx context.Context, path string, pendingCap int) (<-chan Sample, <-chan error) {
+	return streamShard(ctx, path, pendingCap, nil, nil, nil, nil)
+}
+
+// streamShard is StreamShard with optional I/O accounting, sample skipping
+// and verification: stats, when non-nil, is filled in before the error
+// channel is closed, and onOpen is called as soon as the file is open. When
+// onSkip is set, a sample with an unparsable label or a missing half is
+// passed to it and dropped instead of failing the shard; a non-nil return
+// from onSkip ends the shard with that error. When check is set, the
+// shard's samples are held back until the digest of the whole file has
+// been checked.
+func streamShard(ctx context.Context, path string, pendingCap int, stats *ShardStats, onOpen func(time.Duration), onSkip func(key string, err error) error, check *shardCheck) (<-chan Sample, <-chan error) {
 	if pendingCap <= 0 {
 		pendingCap = defaultPendingCap
 	}
+	if stats == nil {
+		stats = &ShardStats{}
+	}
 	out := make(chan Sample)
 	errCh := make(chan error, 1)
 
@@ -37,105 +67,284 @@ func StreamShard(ctx context.Context, path string, pendingCap int) (<-chan Sampl
 		defer close(out)
 		defer close(errCh)
 
-		f, err := os.Open(path)
-		if err != nil {
-			errCh <- fmt.Errorf("open shard: %w", err)
+		send := func(sample Sample) error {
+			if ctx == nil {
+				out <- sample
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- sample:
+				return nil
+			}
+		}
+		if check == nil {
+			if err := readShard(ctx, path, pendingCap, stats, onOpen, onSkip, nil, send); err != nil {
+				errCh <- err
+			}
 			return
 		}
-		defer f.Close()
-
-		tr := tar.NewReader(bufio.NewReader(f))
-		pending := make(map[string]*partial)
-
-		for {
-			if ctx != nil {
-				select {
-				case <-ctx.Done():
-					errCh <- ctx.Err()
-					return
-				default:
+		for attempt := 0; ; attempt++ {
+			// held replays the pass in order once it has been verified, so
+			// samples skipped on a rejected pass are not reported, nor
+			// charged to the error budget, once per read.
+			var held []func() error
+			skip := onSkip
+			if onSkip != nil {
+				skip = func(key string, err error) error {
+					held = append(held, func() error { return onSkip(key, err) })
+					return nil
 				}
 			}
-
-			hdr, err := tr.Next()
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			if err != nil {
-				errCh <- fmt.Errorf("read tar: %w", err)
+			h := check.sum.newHash()
+			read := stats.Bytes
+			err := readShard(ctx, path, pendingCap, stats, onOpen, skip, h, func(sample Sample) error {
+				held = append(held, func() error { return send(sample) })
+				return nil
+			})
+			// A read that failed after the file was opened may be of a
+			// truncated or corrupt copy, so the checksum decides whether it
+			// counts as a mismatch.
+			if err != nil && (stats.Bytes == read || ctx != nil && ctx.Err() != nil) {
+				errCh <- err
 				return
 			}
-			if hdr.FileInfo().IsDir() {
-				continue
+			mismatch := check.sum.verify(path, h)
+			if err != nil && mismatch == nil {
+				for _, deliver := range held {
+					if derr := deliver(); derr != nil {
+						err = derr
+						break
+					}
+				}
+				errCh <- err
+				return
 			}
-			name := filepath.Base(hdr.Name)
-			ext := strings.ToLower(filepath.Ext(name))
-			key := strings.TrimSuffix(name, ext)
-
-			switch ext {
-			case ".jpg", ".jpeg", ".png":
-				data, err := io.ReadAll(tr)
+			if mismatch != nil {
 				if err != nil {
-					errCh <- fmt.Errorf("read image %s: %w", name, err)
-					return
+					mismatch = fmt.Errorf("%w: %w", mismatch, err)
 				}
-				part := pending[key]
-				if part == nil {
-					part = &partial{}
-					pending[key] = part
+				if check.onMismatch != nil {
+					check.onMismatch(mismatch)
 				}
-				part.image = data
-			case ".cls":
-				payload, err := io.ReadAll(tr)
-				if err != nil {
-					errCh <- fmt.Errorf("read label %s: %w", name, err)
-					return
+				if attempt < check.rereads {
+					continue
 				}
-				trimmed := strings.TrimSpace(string(payload))
-				label, err := strconv.Atoi(trimmed)
-				if err != nil {
-					errCh <- fmt.Errorf("parse label %s: %w", name, err)
+				errCh <- mismatch
+				return
+			}
+			for _, deliver := range held {
+				if err := deliver(); err != nil {
+					errCh <- err
 					return
 				}
-				part := pending[key]
-				if part == nil {
-					part = &partial{}
-					pending[key] = part
-				}
-				part.label = &label
-			default:
-				// ignore unknown extension
-				continue
 			}
+			return
+		}
+	}()
 
-			if len(pending) > pendingCap {
-				errCh <- ErrPendingOverflow
-				return
+	return out, errCh
+}
+
+// readShard reads the shard at path once, passing each complete sample to
+// emit, and decompresses it on the way when Compression reports a codec.
+// The I/O is added to stats. When h is set, every byte of the file,
+// including the padding after the end of the archive, is written to it,
+// even when the read fails after the file was opened.
+func readShard(ctx context.Context, path string, pendingCap int, stats *ShardStats, onOpen func(time.Duration), onSkip func(key string, err error) error, h io.Writer, emit func(Sample) error) (err error) {
+	openStart := time.Now()
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open shard: %w", err)
+	}
+	defer f.Close()
+	openTime := time.Since(openStart)
+	if onOpen != nil {
+		onOpen(openTime)
+	}
+	counter := &countingReader{r: f, start: openStart}
+	// inflated counts the TAR stream of a compressed shard.
+	var inflated *countingReader
+	defer func() {
+		// A re-read keeps the open and first-byte times of the first pass.
+		if stats.Bytes == 0 {
+			stats.Open = openTime
+			stats.TTFB = counter.ttfb
+		}
+		stats.Bytes += counter.bytes
+		stats.ReadTime += counter.readTime
+		if inflated != nil {
+			stats.Decompressed += inflated.bytes
+			// Reads of the decompressor include the file reads it made.
+			stats.DecompressTime += max(inflated.readTime-counter.readTime, 0)
+		}
+	}()
+
+	var src io.Reader = counter
+	if h != nil {
+		src = io.TeeReader(counter, h)
+	}
+	raw := bufio.NewReader(src)
+	if h != nil {
+		defer func() {
+			// A truncated or corrupt file fails part way through; hash the
+			// rest so the caller can tell it from a mismatch.
+			if err != nil && (ctx == nil || ctx.Err() == nil) {
+				io.Copy(io.Discard, raw)
+			}
+		}()
+	}
+	br := raw
+	if codec := Compression(path); codec != "" {
+		dec, err := decompress(codec, raw)
+		if err != nil {
+			return fmt.Errorf("read shard: %w", err)
+		}
+		defer dec.Close()
+		inflated = &countingReader{r: dec, start: openStart}
+		br = bufio.NewReader(inflated)
+	}
+	tr := tar.NewReader(br)
+	pending := make(map[string]*partial)
+	// dropped holds keys skipped via onSkip so their other half is
+	// ignored rather than reported as incomplete.
+	dropped := make(map[string]bool)
+
+	for {
+		if ctx != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
 			}
+		}
 
-			if part := pending[key]; part != nil && part.ready() {
-				sample := Sample{Key: key, Image: part.image, Label: *part.label}
-				delete(pending, key)
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+		if hdr.FileInfo().IsDir() {
+			continue
+		}
+		name := filepath.Base(hdr.Name)
+		ext := strings.ToLower(filepath.Ext(name))
+		key := strings.TrimSuffix(name, ext)
+		if dropped[key] {
+			continue
+		}
 
-				if ctx != nil {
-					select {
-					case <-ctx.Done():
-						errCh <- ctx.Err()
-						return
-					case out <- sample:
-					}
-				} else {
-					out <- sample
+		switch ext {
+		case ".jpg", ".jpeg", ".png":
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return fmt.Errorf("read image %s: %w", name, err)
+			}
+			part := pending[key]
+			if part == nil {
+				part = &partial{}
+				pending[key] = part
+			}
+			part.image = data
+		case ".cls":
+			payload, err := io.ReadAll(tr)
+			if err != nil {
+				return fmt.Errorf("read label %s: %w", name, err)
+			}
+			label, err := parseLabel(payload)
+			if err != nil {
+				err = fmt.Errorf("parse label %s: %w", name, err)
+				if onSkip == nil {
+					return err
+				}
+				if err := onSkip(key, err); err != nil {
+					return err
 				}
+				delete(pending, key)
+				dropped[key] = true
+				continue
 			}
+			part := pending[key]
+			if part == nil {
+				part = &partial{}
+				pending[key] = part
+			}
+			part.label = &label
+		default:
+			// ignore unknown extension
+			continue
 		}
 
-		if len(pending) > 0 {
-			errCh <- fmt.Errorf("%d samples incomplete", len(pending))
+		if len(pending) > pendingCap {
+			return ErrPendingOverflow
 		}
-	}()
 
-	return out, errCh
+		if part := pending[key]; part != nil && part.ready() {
+			sample := Sample{Key: key, Image: part.image, Label: *part.label, Shard: path}
+			delete(pending, key)
+			if err := emit(sample); err != nil {
+				return err
+			}
+		}
+	}
+	if h != nil {
+		// Drain the rest of the TAR stream, then anything the decompressor
+		// left in the file.
+		if _, err := io.Copy(io.Discard, br); err != nil {
+			return fmt.Errorf("read shard: %w", err)
+		}
+		if _, err := io.Copy(io.Discard, raw); err != nil {
+			return fmt.Errorf("read shard: %w", err)
+		}
+	}
+
+	if len(pending) > 0 && onSkip != nil {
+		keys := make([]string, 0, len(pending))
+		for key := range pending {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := onSkip(key, fmt.Errorf("sample %s incomplete", key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d samples incomplete", len(pending))
+	}
+	return nil
+}
+
+// parseLabel parses the payload of a .cls member.
+func parseLabel(payload []byte) (int, error) {
+	return strconv.Atoi(strings.TrimSpace(string(payload)))
+}
+
+// countingReader measures bytes read, time to first byte and time spent
+// inside Read.
+type countingReader struct {
+	r        io.Reader
+	start    time.Time
+	bytes    int64
+	ttfb     time.Duration
+	readTime time.Duration
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	begin := time.Now()
+	n, err := c.r.Read(p)
+	end := time.Now()
+	c.readTime += end.Sub(begin)
+	if n > 0 && c.bytes == 0 {
+		c.ttfb = end.Sub(c.start)
+	}
+	c.bytes += int64(n)
+	return n, err
 }
 
 type partial struct {
@@ -0,0 +1,198 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format selects how events are encoded.
+type Format int
+
+const (
+	// Text renders "event key=value ..." through the standard log package.
+	Text Format = iota
+	// JSON renders {"time":..., "level":..., "event":..., fields...}.
+	JSON
+)
+
+// ParseFormat maps a -log-format value to a Format.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "text":
+		return Text, nil
+	case "json":
+		return JSON, nil
+	}
+	return Text, fmt.Errorf("unknown log format %q (want text or json)", s)
+}
+
+func (f Format) String() string {
+	if f == JSON {
+		return "json"
+	}
+	return "text"
+}
+
+var (
+	mu     sync.Mutex
+	format = Text
+	now    = time.Now
+)
+
+// SetFormat switches the process-wide encoding.
+func SetFormat(f Format) {
+	mu.Lock()
+	format = f
+	mu.Unlock()
+}
+
+// Field is one key/value pair of an event.
+type Field struct {
+	Key   string
+	Value any
+	// prec is the number of decimals for float values; -1 means shortest.
+	prec int
+}
+
+// String returns a string field.
+func String(key, value string) Field { return Field{Key: key, Value: value} }
+
+// Int returns an integer field.
+func Int(key string, value int) Field { return Field{Key: key, Value: int64(value)} }
+
+// Int64 returns an integer field.
+func Int64(key string, value int64) Field { return Field{Key: key, Value: value} }
+
+// Bool returns a boolean field.
+func Bool(key string, value bool) Field { return Field{Key: key, Value: value} }
+
+// Float returns a float field rounded to prec decimals in both encodings.
+func Float(key string, value float64, prec int) Field {
+	return Field{Key: key, Value: value, prec: prec}
+}
+
+// Err returns an "error" field; a nil error renders as an empty string.
+func Err(err error) Field {
+	if err == nil {
+		return Field{Key: "error", Value: ""}
+	}
+	return Field{Key: "error", Value: err.Error()}
+}
+
+// Any returns a field rendered with encoding/json (compact JSON in text
+// mode).
+func Any(key string, value any) Field { return Field{Key: key, Value: value} }
+
+// Info logs an event.
+func Info(event string, fields ...Field) { emit("info", event, fields) }
+
+// Error logs an event at error level.
+func Error(event string, err error, fields ...Field) {
+	emit("error", event, append(fields, Err(err)))
+}
+
+// Fatal logs an error event and exits with status 1.
+func Fatal(event string, err error, fields ...Field) {
+	Error(event, err, fields...)
+	os.Exit(1)
+}
+
+func emit(level, event string, fields []Field) {
+	mu.Lock()
+	defer mu.Unlock()
+	if format == JSON {
+		writeJSON(level, event, fields)
+		return
+	}
+	log.Print(renderText(event, fields))
+}
+
+// renderText prints the event name as a leading bare token unless the
+// first field already names it (e.g. "step=100 ...").
+func renderText(event string, fields []Field) string {
+	var b strings.Builder
+	if len(fields) == 0 || fields[0].Key != event {
+		b.WriteString(event)
+	}
+	for _, f := range fields {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(textValue(f))
+	}
+	return b.String()
+}
+
+func textValue(f Field) string {
+	switch v := f.Value.(type) {
+	case string:
+		if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+			return strconv.Quote(v)
+		}
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return formatFloat(v, f.prec)
+	}
+	data, err := json.Marshal(f.Value)
+	if err != nil {
+		return fmt.Sprintf("%v", f.Value)
+	}
+	return string(data)
+}
+
+func formatFloat(v float64, prec int) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	if prec < 0 {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+func writeJSON(level, event string, fields []Field) {
+	var b strings.Builder
+	b.WriteString(`{"time":`)
+	writeJSONValue(&b, now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSONValue(&b, level)
+	b.WriteString(`,"event":`)
+	writeJSONValue(&b, event)
+	for _, f := range fields {
+		b.WriteByte(',')
+		writeJSONValue(&b, f.Key)
+		b.WriteByte(':')
+		if v, ok := f.Value.(float64); ok {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				writeJSONValue(&b, formatFloat(v, -1))
+			} else {
+				b.WriteString(formatFloat(v, f.prec))
+			}
+			continue
+		}
+		writeJSONValue(&b, f.Value)
+	}
+	b.WriteString("}\n")
+	_, _ = log.Writer().Write([]byte(b.String()))
+}
+
+func writeJSONValue(b *strings.Builder, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprintf("%v", v))
+	}
+	b.Write(data)
+}
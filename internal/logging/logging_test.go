@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func capture(t *testing.T, f Format, fn func()) string {
+	t.Helper()
+	var buf bytes.Buffer
+	prevOut, prevFlags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	SetFormat(f)
+	t.Cleanup(func() {
+		log.SetOutput(prevOut)
+		log.SetFlags(prevFlags)
+		SetFormat(Text)
+	})
+	fn()
+	return buf.String()
+}
+
+func TestTextFormat(t *testing.T) {
+	out := capture(t, Text, func() {
+		Info("step", Int("step", 100), Float("images_per_sec", 920.44, 1), Float("loss", 1.83, 4))
+		Error("root_skipped", errors.New("no shards discovered"), String("root", "weu"))
+	})
+	want := "step=100 images_per_sec=920.4 loss=1.8300\n" +
+		"root_skipped root=weu error=\"no shards discovered\"\n"
+	if out != want {
+		t.Fatalf("unexpected text output:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	prevNow := now
+	now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	defer func() { now = prevNow }()
+
+	out := capture(t, JSON, func() {
+		Info("step", Int("step", 100), Float("data_ms", 0.125, 2), Any("roots", []string{"cac"}))
+	})
+	var event map[string]any
+	if err := json.Unmarshal([]byte(out), &event); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out)
+	}
+	if event["time"] != "2024-01-02T03:04:05Z" || event["event"] != "step" || event["level"] != "info" {
+		t.Fatalf("unexpected envelope: %v", event)
+	}
+	if event["step"] != float64(100) || event["data_ms"] != 0.12 {
+		t.Fatalf("unexpected fields: %v", event)
+	}
+	if !strings.HasSuffix(out, "}\n") || strings.Count(out, "\n") != 1 {
+		t.Fatalf("expected one object per line, got %q", out)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat("JSON"); err != nil || f != JSON {
+		t.Fatalf("ParseFormat(JSON) = %v, %v", f, err)
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"math"
+	"time"
+)
+
+// Buckets grow by 2^(1/8) from 1µs, so a reported quantile is within ~4.5%
+// of the true value; the last bucket absorbs anything above ~134s.
+const (
+	bucketsPerOctave = 8
+	latencyOctaves   = 27
+	latencyBuckets   = latencyOctaves*bucketsPerOctave + 2
+)
+
+// LatencyHistogram is a fixed-size, log-bucketed latency histogram. Two
+// histograms merge by adding bucket counts, so per-window histograms can be
+// rolled up into a run-wide one without keeping raw samples.
+type LatencyHistogram struct {
+	counts [latencyBuckets]uint64
+	count  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// Observe records one latency.
+func (h *LatencyHistogram) Observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketIndex(d)]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+}
+
+// Merge adds all observations from other.
+func (h *LatencyHistogram) Merge(other *LatencyHistogram) {
+	if other.count == 0 {
+		return
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.sum += other.sum
+}
+
+// Reset clears all observations.
+func (h *LatencyHistogram) Reset() {
+	*h = LatencyHistogram{}
+}
+
+// Count returns the number of observations.
+func (h *LatencyHistogram) Count() uint64 { return h.count }
+
+// Max returns the largest observation.
+func (h *LatencyHistogram) Max() time.Duration { return h.max }
+
+// Quantile returns an estimate of the q-quantile (0 <= q <= 1), clamped to
+// the observed min and max.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return h.min
+	}
+	if q >= 1 {
+		return h.max
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			v := bucketValue(i)
+			if v < h.min {
+				v = h.min
+			}
+			if v > h.max {
+				v = h.max
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+// Summary returns the standard percentiles in milliseconds.
+func (h *LatencyHistogram) Summary() LatencySummary {
+	return LatencySummary{
+		P50MS: durationMS(h.Quantile(0.50)),
+		P90MS: durationMS(h.Quantile(0.90)),
+		P99MS: durationMS(h.Quantile(0.99)),
+		MaxMS: durationMS(h.max),
+	}
+}
+
+// LatencySummary is a loggable view of a LatencyHistogram.
+type LatencySummary struct {
+	P50MS float64
+	P90MS float64
+	P99MS float64
+	MaxMS float64
+}
+
+func bucketIndex(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us < 1 {
+		return 0
+	}
+	idx := 1 + int(math.Floor(math.Log2(us)*bucketsPerOctave))
+	if idx >= latencyBuckets {
+		idx = latencyBuckets - 1
+	}
+	return idx
+}
+
+// bucketValue returns the geometric midpoint of bucket i.
+func bucketValue(i int) time.Duration {
+	if i == 0 {
+		return 0
+	}
+	us := math.Exp2((float64(i) - 0.5) / bucketsPerOctave)
+	return time.Duration(us * float64(time.Microsecond))
+}
+
+func durationMS(d time.Duration) float64 {
+	return d.Seconds() * 1000
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogramQuantiles(t *testing.T) {
+	var h LatencyHistogram
+	for i := 1; i <= 1000; i++ {
+		h.Observe(time.Duration(i) * time.Millisecond)
+	}
+	for _, tc := range []struct {
+		q    float64
+		want float64
+	}{{0.5, 500}, {0.9, 900}, {0.99, 990}} {
+		got := durationMS(h.Quantile(tc.q))
+		if math.Abs(got-tc.want)/tc.want > 0.05 {
+			t.Fatalf("q%.2f = %.1fms, want ~%.0fms", tc.q, got, tc.want)
+		}
+	}
+	if h.Max() != time.Second {
+		t.Fatalf("max = %v", h.Max())
+	}
+}
+
+func TestLatencyHistogramMerge(t *testing.T) {
+	var a, b, all LatencyHistogram
+	for i := 1; i <= 100; i++ {
+		d := time.Duration(i*i) * time.Microsecond
+		all.Observe(d)
+		if i%2 == 0 {
+			a.Observe(d)
+		} else {
+			b.Observe(d)
+		}
+	}
+	a.Merge(&b)
+	if a != all {
+		t.Fatal("merged histogram differs from directly built one")
+	}
+}
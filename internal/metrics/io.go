@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// IOTotals accumulates shard I/O counters.
+type IOTotals struct {
+	// Shards counts completed shard passes.
+	Shards   int64
+	Bytes    int64
+	Samples  int64
+	TTFB     time.Duration
+	MaxTTFB  time.Duration
+	ReadTime time.Duration
+}
+
+func (t *IOTotals) add(bytes, samples int64, ttfb, readTime time.Duration) {
+	t.Shards++
+	t.Bytes += bytes
+	t.Samples += samples
+	t.TTFB += ttfb
+	if ttfb > t.MaxTTFB {
+		t.MaxTTFB = ttfb
+	}
+	t.ReadTime += readTime
+}
+
+// AvgTTFBMS is the mean time to first byte per shard pass.
+func (t IOTotals) AvgTTFBMS() float64 {
+	if t.Shards == 0 {
+		return 0
+	}
+	return durationMS(t.TTFB) / float64(t.Shards)
+}
+
+// ReadMBPerSec is the read throughput while inside file reads.
+func (t IOTotals) ReadMBPerSec() float64 {
+	if t.ReadTime <= 0 {
+		return 0
+	}
+	return float64(t.Bytes) / (1 << 20) / t.ReadTime.Seconds()
+}
+
+// ShardIO is the run-wide I/O for one shard.
+type ShardIO struct {
+	Root  string
+	Shard string
+	IOTotals
+}
+
+// IOStats accumulates shard I/O per root, both for the current window and
+// for the whole run, and per shard for the whole run. It is safe for
+// concurrent use.
+type IOStats struct {
+	mu     sync.Mutex
+	window map[string]*IOTotals
+	roots  map[string]*IOTotals
+	shards map[string]*ShardIO
+}
+
+// Record adds one completed shard pass.
+func (s *IOStats) Record(root, shard string, bytes, samples int64, ttfb, readTime time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// TakeWindow may have reset window before the first Record, so each map
+	// is created on its own.
+	if s.window == nil {
+		s.window = make(map[string]*IOTotals)
+	}
+	if s.roots == nil {
+		s.roots = make(map[string]*IOTotals)
+		s.shards = make(map[string]*ShardIO)
+	}
+	for _, totals := range []map[string]*IOTotals{s.window, s.roots} {
+		t, ok := totals[root]
+		if !ok {
+			t = &IOTotals{}
+			totals[root] = t
+		}
+		t.add(bytes, samples, ttfb, readTime)
+	}
+	sh, ok := s.shards[shard]
+	if !ok {
+		sh = &ShardIO{Root: root, Shard: shard}
+		s.shards[shard] = sh
+	}
+	sh.add(bytes, samples, ttfb, readTime)
+}
+
+// TakeWindow returns per-root totals since the previous call and resets
+// them.
+func (s *IOStats) TakeWindow() map[string]IOTotals {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]IOTotals, len(s.window))
+	for root, t := range s.window {
+		out[root] = *t
+	}
+	s.window = make(map[string]*IOTotals)
+	return out
+}
+
+// Roots returns per-root totals for the whole run.
+func (s *IOStats) Roots() map[string]IOTotals {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]IOTotals, len(s.roots))
+	for root, t := range s.roots {
+		out[root] = *t
+	}
+	return out
+}
+
+// Shards returns per-shard totals for the whole run, slowest (by total read
+// time plus time to first byte) first.
+func (s *IOStats) Shards() []ShardIO {
+	s.mu.Lock()
+	out := make([]ShardIO, 0, len(s.shards))
+	for _, sh := range s.shards {
+		out = append(out, *sh)
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		ci := out[i].ReadTime + out[i].TTFB
+		cj := out[j].ReadTime + out[j].TTFB
+		if ci != cj {
+			return ci > cj
+		}
+		return out[i].Shard < out[j].Shard
+	})
+	return out
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIOStatsWindowAndRun(t *testing.T) {
+	var s IOStats
+	s.Record("cac", "/cac/shard-000000.tar", 1<<20, 10, 2*time.Millisecond, 100*time.Millisecond)
+	s.Record("wus3", "/wus3/shard-000001.tar", 1<<20, 10, 40*time.Millisecond, 400*time.Millisecond)
+
+	window := s.TakeWindow()
+	if window["cac"].Samples != 10 || window["wus3"].Shards != 1 {
+		t.Fatalf("unexpected window: %+v", window)
+	}
+	if got := window["cac"].ReadMBPerSec(); got < 9.9 || got > 10.1 {
+		t.Fatalf("expected 10 MB/s, got %.2f", got)
+	}
+	if len(s.TakeWindow()) != 0 {
+		t.Fatal("window was not reset")
+	}
+
+	s.Record("cac", "/cac/shard-000000.tar", 1<<20, 10, 4*time.Millisecond, 100*time.Millisecond)
+	roots := s.Roots()
+	if roots["cac"].Shards != 2 || roots["cac"].AvgTTFBMS() != 3 || roots["cac"].MaxTTFB != 4*time.Millisecond {
+		t.Fatalf("unexpected run totals: %+v", roots["cac"])
+	}
+	shards := s.Shards()
+	if len(shards) != 2 || shards[0].Root != "wus3" {
+		t.Fatalf("expected slowest shard first, got %+v", shards)
+	}
+}
+
+func TestIOStatsTakeWindowBeforeRecord(t *testing.T) {
+	var s IOStats
+	if window := s.TakeWindow(); len(window) != 0 {
+		t.Fatalf("expected empty window, got %+v", window)
+	}
+	s.Record("cac", "/cac/shard-000000.tar", 1<<20, 10, time.Millisecond, 10*time.Millisecond)
+	if window := s.TakeWindow(); window["cac"].Samples != 10 {
+		t.Fatalf("unexpected window: %+v", window)
+	}
+}
@@ -0,0 +1,334 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are latency buckets in seconds, spanning cache hits on local
+// NVMe through cold cross-region fetches.
+var DefBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds metrics and renders them in the Prometheus text
+// exposition format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []collector
+	names   map[string]bool
+}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, c)
+}
+
+// Counter registers a monotonically increasing metric.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{family: newFamily(name, help, "counter", labels)}
+	r.register(name, v)
+	return v
+}
+
+// Gauge registers a metric that can go up and down.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{family: newFamily(name, help, "gauge", labels)}
+	r.register(name, v)
+	return v
+}
+
+// Histogram registers a cumulative histogram with the given upper bounds.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	v := &HistogramVec{family: newFamily(name, help, "histogram", labels), bounds: bounds}
+	r.register(name, v)
+	return v
+}
+
+// WriteText renders all registered metrics.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]collector(nil), r.metrics...)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry for Prometheus scrapes.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WriteText(w)
+	})
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu    sync.Mutex
+	order []string
+}
+
+func newFamily(name, help, kind string, labels []string) family {
+	return family{name: name, help: help, kind: kind, labels: labels}
+}
+
+// key validates label values and returns the rendered label set, which is
+// also used as the series key.
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i, label := range f.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func (f *family) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+func series(name, labels, extra string) string {
+	switch {
+	case labels == "" && extra == "":
+		return name
+	case labels == "":
+		return name + "{" + extra + "}"
+	case extra == "":
+		return name + "{" + labels + "}"
+	}
+	return name + "{" + labels + "," + extra + "}"
+}
+
+// CounterVec is a counter family partitioned by label values.
+type CounterVec struct {
+	family
+	values map[string]*Counter
+}
+
+// With returns the counter for the given label values.
+func (v *CounterVec) With(values ...string) *Counter {
+	key := v.key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.values == nil {
+		v.values = make(map[string]*Counter)
+	}
+	c, ok := v.values[key]
+	if !ok {
+		c = &Counter{}
+		v.values[key] = c
+		v.order = append(v.order, key)
+	}
+	return c
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.header(w)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range v.order {
+		fmt.Fprintf(w, "%s %s\n", series(v.name, key, ""), formatFloat(v.values[key].Value()))
+	}
+}
+
+// Counter is a single counter series.
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Inc adds one.
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.value += delta
+	c.mu.Unlock()
+}
+
+// Value returns the current count.
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// GaugeVec is a gauge family partitioned by label values.
+type GaugeVec struct {
+	family
+	values map[string]*Gauge
+}
+
+// With returns the gauge for the given label values.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	key := v.key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.values == nil {
+		v.values = make(map[string]*Gauge)
+	}
+	g, ok := v.values[key]
+	if !ok {
+		g = &Gauge{}
+		v.values[key] = g
+		v.order = append(v.order, key)
+	}
+	return g
+}
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	v.header(w)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range v.order {
+		fmt.Fprintf(w, "%s %s\n", series(v.name, key, ""), formatFloat(v.values[key].Value()))
+	}
+}
+
+// Gauge is a single gauge series.
+type Gauge struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Set replaces the gauge value.
+func (g *Gauge) Set(value float64) {
+	g.mu.Lock()
+	g.value = value
+	g.mu.Unlock()
+}
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+// HistogramVec is a histogram family partitioned by label values.
+type HistogramVec struct {
+	family
+	bounds []float64
+	values map[string]*Histogram
+}
+
+// With returns the histogram for the given label values.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	key := v.key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.values == nil {
+		v.values = make(map[string]*Histogram)
+	}
+	h, ok := v.values[key]
+	if !ok {
+		h = &Histogram{bounds: v.bounds, counts: make([]uint64, len(v.bounds))}
+		v.values[key] = h
+		v.order = append(v.order, key)
+	}
+	return h
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.header(w)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range v.order {
+		h := v.values[key]
+		h.mu.Lock()
+		var cumulative uint64
+		for i, bound := range h.bounds {
+			cumulative += h.counts[i]
+			le := `le="` + formatFloat(bound) + `"`
+			fmt.Fprintf(w, "%s %d\n", series(v.name+"_bucket", key, le), cumulative)
+		}
+		fmt.Fprintf(w, "%s %d\n", series(v.name+"_bucket", key, `le="+Inf"`), h.count)
+		fmt.Fprintf(w, "%s %s\n", series(v.name+"_sum", key, ""), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s %d\n", series(v.name+"_count", key, ""), h.count)
+		h.mu.Unlock()
+	}
+}
+
+// Histogram is a single histogram series.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := sort.SearchFloat64s(h.bounds, value)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += value
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("forge_samples_total", "Samples.", "root").With("cac").Add(3)
+	reg.Gauge("forge_loss", "Loss.").With().Set(1.5)
+	h := reg.Histogram("forge_wait_seconds", "Wait.", []float64{0.1, 1}).With()
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	var buf bytes.Buffer
+	if err := reg.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE forge_samples_total counter\n",
+		`forge_samples_total{root="cac"} 3` + "\n",
+		"forge_loss 1.5\n",
+		"# TYPE forge_wait_seconds histogram\n",
+		`forge_wait_seconds_bucket{le="0.1"} 1` + "\n",
+		`forge_wait_seconds_bucket{le="1"} 2` + "\n",
+		`forge_wait_seconds_bucket{le="+Inf"} 3` + "\n",
+		"forge_wait_seconds_sum 2.55\n",
+		"forge_wait_seconds_count 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRegistryEscapesLabels(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("c", "help", "path").With(`a"b\c`).Inc()
+	var buf bytes.Buffer
+	if err := reg.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	if !strings.Contains(buf.String(), `c{path="a\"b\\c"} 1`) {
+		t.Fatalf("label not escaped:\n%s", buf.String())
+	}
+}
@@ -2,13 +2,25 @@ package metrics
 
 import "time"
 
-// Window accumulates timing stats across multiple steps.
+// Window accumulates timing stats across multiple steps. Latency
+// histograms are kept both for the current window and for the whole run.
 type Window struct {
 	samples  int
 	data     time.Duration
 	compute  time.Duration
 	steps    int
 	lastLoss float64
+
+	// ioWait, prep and stalls describe how the window's batches were built;
+	// see RecordPipeline.
+	ioWait time.Duration
+	prep   time.Duration
+	stalls int
+
+	dataHist       LatencyHistogram
+	computeHist    LatencyHistogram
+	runDataHist    LatencyHistogram
+	runComputeHist LatencyHistogram
 }
 
 // Record adds a new measurement to the window.
@@ -18,9 +30,23 @@ func (w *Window) Record(batchSize int, dataTime, computeTime time.Duration, loss
 	w.compute += computeTime
 	w.steps++
 	w.lastLoss = loss
+	w.dataHist.Observe(dataTime)
+	w.computeHist.Observe(computeTime)
+}
+
+// RecordPipeline adds how one step's batch was built: the time spent
+// waiting for its samples and preprocessing them, and whether the step
+// found no ready batch and had to wait for one.
+func (w *Window) RecordPipeline(ioWait, prep time.Duration, stalled bool) {
+	w.ioWait += ioWait
+	w.prep += prep
+	if stalled {
+		w.stalls++
+	}
 }
 
-// Snapshot returns aggregated metrics and resets the window.
+// Snapshot returns aggregated metrics and resets the window. The window's
+// histograms are folded into the run-wide ones first.
 func (w *Window) Snapshot() Snapshot {
 	snap := Snapshot{}
 	total := w.data + w.compute
@@ -30,13 +56,28 @@ func (w *Window) Snapshot() Snapshot {
 	if w.steps > 0 {
 		snap.AvgDataMS = (w.data.Seconds() * 1000) / float64(w.steps)
 		snap.AvgComputeMS = (w.compute.Seconds() * 1000) / float64(w.steps)
+		snap.AvgIOMS = (w.ioWait.Seconds() * 1000) / float64(w.steps)
+		snap.AvgPrepMS = (w.prep.Seconds() * 1000) / float64(w.steps)
 	}
+	snap.Stalls = w.stalls
 	snap.LastLoss = w.lastLoss
+	snap.Data = w.dataHist.Summary()
+	snap.Compute = w.computeHist.Summary()
+
+	w.runDataHist.Merge(&w.dataHist)
+	w.runComputeHist.Merge(&w.computeHist)
+	snap.RunData = w.runDataHist.Summary()
+	snap.RunCompute = w.runComputeHist.Summary()
 
 	w.samples = 0
 	w.data = 0
 	w.compute = 0
 	w.steps = 0
+	w.ioWait = 0
+	w.prep = 0
+	w.stalls = 0
+	w.dataHist.Reset()
+	w.computeHist.Reset()
 	return snap
 }
 
@@ -46,4 +87,15 @@ type Snapshot struct {
 	AvgDataMS    float64
 	AvgComputeMS float64
 	LastLoss     float64
+	// AvgIOMS and AvgPrepMS are the mean time to gather and to preprocess
+	// a batch, and Stalls the number of steps that waited for one.
+	AvgIOMS   float64
+	AvgPrepMS float64
+	Stalls    int
+	// Data and Compute cover the window; RunData and RunCompute cover every
+	// step since the Window was created.
+	Data       LatencySummary
+	Compute    LatencySummary
+	RunData    LatencySummary
+	RunCompute LatencySummary
 }
@@ -21,3 +21,45 @@ func TestWindowSnapshot(t *testing.T) {
 		t.Fatalf("expected last loss 0.8, got %.2f", snap.LastLoss)
 	}
 }
+
+func TestWindowPercentiles(t *testing.T) {
+	var w Window
+	for i := 0; i < 99; i++ {
+		w.Record(1, time.Millisecond, time.Millisecond, 0)
+	}
+	w.Record(1, 2*time.Second, time.Millisecond, 0)
+	snap := w.Snapshot()
+	if math.Abs(snap.Data.P50MS-1) > 0.05 {
+		t.Fatalf("expected p50 ~1ms, got %.3f", snap.Data.P50MS)
+	}
+	if snap.Data.MaxMS != 2000 {
+		t.Fatalf("expected max 2000ms, got %.3f", snap.Data.MaxMS)
+	}
+	if snap.AvgDataMS < 20 {
+		t.Fatalf("average should be skewed by the stall, got %.3f", snap.AvgDataMS)
+	}
+
+	w.Record(1, 5*time.Millisecond, time.Millisecond, 0)
+	snap = w.Snapshot()
+	if snap.Data.MaxMS != 5 {
+		t.Fatalf("window max should reset, got %.3f", snap.Data.MaxMS)
+	}
+	if snap.RunData.MaxMS != 2000 {
+		t.Fatalf("run max should persist, got %.3f", snap.RunData.MaxMS)
+	}
+}
+
+func TestWindowPipeline(t *testing.T) {
+	var w Window
+	w.Record(8, time.Millisecond, time.Millisecond, 0)
+	w.RecordPipeline(4*time.Millisecond, 2*time.Millisecond, true)
+	w.Record(8, time.Millisecond, time.Millisecond, 0)
+	w.RecordPipeline(2*time.Millisecond, 4*time.Millisecond, false)
+	snap := w.Snapshot()
+	if snap.AvgIOMS != 3 || snap.AvgPrepMS != 3 || snap.Stalls != 1 {
+		t.Fatalf("unexpected pipeline stats: io %.2f prep %.2f stalls %d", snap.AvgIOMS, snap.AvgPrepMS, snap.Stalls)
+	}
+	if snap = w.Snapshot(); snap.Stalls != 0 || snap.AvgIOMS != 0 {
+		t.Fatalf("pipeline stats were not reset: %+v", snap)
+	}
+}
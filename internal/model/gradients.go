@@ -0,0 +1,47 @@
+package model
+
+// Gradients are loss gradients summed over a batch, with one slice per
+// parameter tensor in the model's own order.
+type Gradients struct {
+	Params [][]float64
+	// Loss is the summed cross-entropy and Samples the number of inputs
+	// that contributed to Params and Loss.
+	Loss    float64
+	Samples int
+}
+
+// Add accumulates other into g. Both must come from the same model; g
+// takes other's shape when it is empty.
+func (g *Gradients) Add(other Gradients) {
+	if g.Params == nil {
+		g.Params = make([][]float64, len(other.Params))
+		for i, p := range other.Params {
+			g.Params[i] = make([]float64, len(p))
+		}
+	}
+	for i, p := range other.Params {
+		dst := g.Params[i]
+		for j, v := range p {
+			dst[j] += v
+		}
+	}
+	g.Loss += other.Loss
+	g.Samples += other.Samples
+}
+
+// Mean returns g with Params and Loss divided by Samples. It returns g
+// unchanged when no sample contributed.
+func (g Gradients) Mean() Gradients {
+	if g.Samples == 0 {
+		return g
+	}
+	inv := 1 / float64(g.Samples)
+	mean := Gradients{Params: make([][]float64, len(g.Params)), Loss: g.Loss * inv, Samples: g.Samples}
+	for i, p := range g.Params {
+		mean.Params[i] = make([]float64, len(p))
+		for j, v := range p {
+			mean.Params[i][j] = v * inv
+		}
+	}
+	return mean
+}
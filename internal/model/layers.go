@@ -0,0 +1,397 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Shape is the channels, height and width of an activation. Activations are
+// stored flat in channel-major order, so element (c, y, x) is at
+// (c*H+y)*W+x.
+type Shape struct {
+	C int `json:"c"`
+	H int `json:"h"`
+	W int `json:"w"`
+}
+
+// Size is the number of elements in an activation of this shape.
+func (s Shape) Size() int { return s.C * s.H * s.W }
+
+func (s Shape) String() string { return fmt.Sprintf("%dx%dx%d", s.C, s.H, s.W) }
+
+// Layer is one stage of a Network. Layers keep no per-sample state, so the
+// gradients of several batch slices can be computed concurrently.
+type Layer interface {
+	// OutputShape is the shape of the activations Forward returns.
+	OutputShape() Shape
+	// Forward returns the layer's output for in, plus whatever Backward
+	// needs besides the input (the argmax positions of max pooling).
+	Forward(in []float64) (out []float64, tape []int)
+	// Backward adds the parameter gradients of one sample to grads, which
+	// is laid out like Params, and returns the gradient of the loss with
+	// respect to in.
+	Backward(in []float64, tape []int, gradOut []float64, grads [][]float64) []float64
+	// Params returns the live parameter tensors; nil for layers without
+	// any.
+	Params() [][]float64
+}
+
+// LayerSpec describes one layer of a Network. Type is "dense", "conv2d",
+// "max_pool", "avg_pool", "relu" or "flatten"; the other fields apply as
+// noted and are ignored by the rest.
+type LayerSpec struct {
+	Type string `json:"type"`
+	// Units is the output width of dense.
+	Units int `json:"units,omitempty"`
+	// Filters, Kernel and Padding shape conv2d: Filters output channels
+	// from a Kernel x Kernel window over input zero-padded by Padding.
+	Filters int `json:"filters,omitempty"`
+	Kernel  int `json:"kernel,omitempty"`
+	Padding int `json:"padding,omitempty"`
+	// Size is the pooling window (default 2).
+	Size int `json:"size,omitempty"`
+	// Stride defaults to 1 for conv2d and to Size for pooling.
+	Stride int `json:"stride,omitempty"`
+}
+
+func (s LayerSpec) String() string {
+	switch s.Type {
+	case "dense":
+		return fmt.Sprintf("dense(%d)", s.Units)
+	case "conv2d":
+		return fmt.Sprintf("conv2d(%d,%dx%d,s%d,p%d)", s.Filters, s.Kernel, s.Kernel, s.Stride, s.Padding)
+	case "max_pool", "avg_pool":
+		return fmt.Sprintf("%s(%d,s%d)", s.Type, s.Size, s.Stride)
+	}
+	return s.Type
+}
+
+// withDefaults returns spec with its type lower-cased and zero strides and
+// pooling sizes replaced by their defaults.
+func (s LayerSpec) withDefaults() LayerSpec {
+	s.Type = strings.ToLower(s.Type)
+	switch s.Type {
+	case "conv2d":
+		if s.Stride == 0 {
+			s.Stride = 1
+		}
+	case "max_pool", "avg_pool":
+		if s.Size == 0 {
+			s.Size = 2
+		}
+		if s.Stride == 0 {
+			s.Stride = s.Size
+		}
+	}
+	return s
+}
+
+// NewLayer builds the layer described by spec for inputs of shape in,
+// drawing its initial weights from rng.
+func NewLayer(spec LayerSpec, in Shape, rng *rand.Rand) (Layer, error) {
+	if in.Size() <= 0 {
+		return nil, fmt.Errorf("model: %s: empty input shape %s", spec.Type, in)
+	}
+	spec = spec.withDefaults()
+	switch spec.Type {
+	case "dense":
+		if spec.Units <= 0 {
+			return nil, fmt.Errorf("model: dense: units must be > 0 (got %d)", spec.Units)
+		}
+		l := &dense{in: in.Size(), out: spec.Units}
+		l.weights = heInit(rng, l.in*l.out, l.in)
+		l.bias = make([]float64, l.out)
+		return l, nil
+	case "conv2d":
+		if spec.Filters <= 0 || spec.Kernel <= 0 {
+			return nil, fmt.Errorf("model: conv2d: filters and kernel must be > 0 (got %d, %d)", spec.Filters, spec.Kernel)
+		}
+		if spec.Padding < 0 || spec.Stride < 0 {
+			return nil, fmt.Errorf("model: conv2d: padding and stride must be >= 0")
+		}
+		out, err := windowShape(in, spec.Kernel, spec.Stride, spec.Padding)
+		if err != nil {
+			return nil, fmt.Errorf("model: conv2d: %w", err)
+		}
+		out.C = spec.Filters
+		l := &conv2D{in: in, out: out, kernel: spec.Kernel, stride: spec.Stride, pad: spec.Padding}
+		fanIn := in.C * spec.Kernel * spec.Kernel
+		l.weights = heInit(rng, out.C*fanIn, fanIn)
+		l.bias = make([]float64, out.C)
+		return l, nil
+	case "max_pool", "avg_pool":
+		if spec.Size < 0 || spec.Stride < 0 {
+			return nil, fmt.Errorf("model: %s: size and stride must be >= 0", spec.Type)
+		}
+		out, err := windowShape(in, spec.Size, spec.Stride, 0)
+		if err != nil {
+			return nil, fmt.Errorf("model: %s: %w", spec.Type, err)
+		}
+		return &pool{in: in, out: out, size: spec.Size, stride: spec.Stride, max: spec.Type == "max_pool"}, nil
+	case "relu":
+		return relu{shape: in}, nil
+	case "flatten":
+		return flatten{out: Shape{C: in.Size(), H: 1, W: 1}}, nil
+	}
+	return nil, fmt.Errorf("model: unknown layer type %q (want dense, conv2d, max_pool, avg_pool, relu or flatten)", spec.Type)
+}
+
+// windowShape is the output shape of sliding a size x size window with the
+// given stride over in padded by pad on every side.
+func windowShape(in Shape, size, stride, pad int) (Shape, error) {
+	h, w := in.H+2*pad, in.W+2*pad
+	if size > h || size > w {
+		return Shape{}, fmt.Errorf("window %d does not fit input %s", size, in)
+	}
+	return Shape{C: in.C, H: (h-size)/stride + 1, W: (w-size)/stride + 1}, nil
+}
+
+// heInit draws n weights uniformly from the He range for ReLU networks
+// with fanIn inputs per unit.
+func heInit(rng *rand.Rand, n, fanIn int) []float64 {
+	limit := math.Sqrt(6 / float64(fanIn))
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = (rng.Float64()*2 - 1) * limit
+	}
+	return weights
+}
+
+// dense is a fully connected layer over the flattened input.
+type dense struct {
+	in, out int
+	weights []float64 // out rows of in
+	bias    []float64
+}
+
+func (l *dense) OutputShape() Shape { return Shape{C: l.out, H: 1, W: 1} }
+
+func (l *dense) Params() [][]float64 { return [][]float64{l.weights, l.bias} }
+
+func (l *dense) Forward(in []float64) ([]float64, []int) {
+	out := make([]float64, l.out)
+	for o := range out {
+		sum := l.bias[o]
+		row := l.weights[o*l.in : (o+1)*l.in]
+		for i, x := range in {
+			sum += row[i] * x
+		}
+		out[o] = sum
+	}
+	return out, nil
+}
+
+func (l *dense) Backward(in []float64, _ []int, gradOut []float64, grads [][]float64) []float64 {
+	gradW, gradB := grads[0], grads[1]
+	gradIn := make([]float64, l.in)
+	for o, g := range gradOut {
+		if g == 0 {
+			continue
+		}
+		gradB[o] += g
+		row := l.weights[o*l.in : (o+1)*l.in]
+		gradRow := gradW[o*l.in : (o+1)*l.in]
+		for i, x := range in {
+			gradRow[i] += g * x
+			gradIn[i] += g * row[i]
+		}
+	}
+	return gradIn
+}
+
+// conv2D is a 2D convolution (strictly, a cross-correlation) with
+// zero padding.
+type conv2D struct {
+	in, out             Shape
+	kernel, stride, pad int
+	weights             []float64 // [filter][channel][ky][kx]
+	bias                []float64
+}
+
+func (l *conv2D) OutputShape() Shape { return l.out }
+
+func (l *conv2D) Params() [][]float64 { return [][]float64{l.weights, l.bias} }
+
+func (l *conv2D) Forward(in []float64) ([]float64, []int) {
+	out := make([]float64, l.out.Size())
+	k := l.kernel
+	for o := 0; o < l.out.C; o++ {
+		for y := 0; y < l.out.H; y++ {
+			for x := 0; x < l.out.W; x++ {
+				sum := l.bias[o]
+				for c := 0; c < l.in.C; c++ {
+					for ky := 0; ky < k; ky++ {
+						iy := y*l.stride + ky - l.pad
+						if iy < 0 || iy >= l.in.H {
+							continue
+						}
+						w := l.weights[((o*l.in.C+c)*k+ky)*k:]
+						row := in[(c*l.in.H+iy)*l.in.W:]
+						for kx := 0; kx < k; kx++ {
+							ix := x*l.stride + kx - l.pad
+							if ix < 0 || ix >= l.in.W {
+								continue
+							}
+							sum += w[kx] * row[ix]
+						}
+					}
+				}
+				out[(o*l.out.H+y)*l.out.W+x] = sum
+			}
+		}
+	}
+	return out, nil
+}
+
+func (l *conv2D) Backward(in []float64, _ []int, gradOut []float64, grads [][]float64) []float64 {
+	gradW, gradB := grads[0], grads[1]
+	gradIn := make([]float64, l.in.Size())
+	k := l.kernel
+	for o := 0; o < l.out.C; o++ {
+		for y := 0; y < l.out.H; y++ {
+			for x := 0; x < l.out.W; x++ {
+				g := gradOut[(o*l.out.H+y)*l.out.W+x]
+				if g == 0 {
+					continue
+				}
+				gradB[o] += g
+				for c := 0; c < l.in.C; c++ {
+					for ky := 0; ky < k; ky++ {
+						iy := y*l.stride + ky - l.pad
+						if iy < 0 || iy >= l.in.H {
+							continue
+						}
+						wStart := ((o*l.in.C+c)*k + ky) * k
+						inStart := (c*l.in.H + iy) * l.in.W
+						for kx := 0; kx < k; kx++ {
+							ix := x*l.stride + kx - l.pad
+							if ix < 0 || ix >= l.in.W {
+								continue
+							}
+							gradW[wStart+kx] += g * in[inStart+ix]
+							gradIn[inStart+ix] += g * l.weights[wStart+kx]
+						}
+					}
+				}
+			}
+		}
+	}
+	return gradIn
+}
+
+// pool takes the maximum or the mean of every size x size window, per
+// channel.
+type pool struct {
+	in, out      Shape
+	size, stride int
+	max          bool
+}
+
+func (l *pool) OutputShape() Shape { return l.out }
+
+func (l *pool) Params() [][]float64 { return nil }
+
+func (l *pool) Forward(in []float64) ([]float64, []int) {
+	out := make([]float64, l.out.Size())
+	var argmax []int
+	if l.max {
+		argmax = make([]int, len(out))
+	}
+	inv := 1 / float64(l.size*l.size)
+	for c := 0; c < l.out.C; c++ {
+		for y := 0; y < l.out.H; y++ {
+			for x := 0; x < l.out.W; x++ {
+				idx := (c*l.out.H+y)*l.out.W + x
+				best, sum := -1, 0.0
+				for dy := 0; dy < l.size; dy++ {
+					row := (c*l.in.H + y*l.stride + dy) * l.in.W
+					for dx := 0; dx < l.size; dx++ {
+						i := row + x*l.stride + dx
+						if best < 0 || in[i] > in[best] {
+							best = i
+						}
+						sum += in[i]
+					}
+				}
+				if l.max {
+					out[idx], argmax[idx] = in[best], best
+				} else {
+					out[idx] = sum * inv
+				}
+			}
+		}
+	}
+	return out, argmax
+}
+
+func (l *pool) Backward(_ []float64, argmax []int, gradOut []float64, _ [][]float64) []float64 {
+	gradIn := make([]float64, l.in.Size())
+	if l.max {
+		for idx, g := range gradOut {
+			gradIn[argmax[idx]] += g
+		}
+		return gradIn
+	}
+	inv := 1 / float64(l.size*l.size)
+	for c := 0; c < l.out.C; c++ {
+		for y := 0; y < l.out.H; y++ {
+			for x := 0; x < l.out.W; x++ {
+				g := gradOut[(c*l.out.H+y)*l.out.W+x] * inv
+				for dy := 0; dy < l.size; dy++ {
+					row := (c*l.in.H + y*l.stride + dy) * l.in.W
+					for dx := 0; dx < l.size; dx++ {
+						gradIn[row+x*l.stride+dx] += g
+					}
+				}
+			}
+		}
+	}
+	return gradIn
+}
+
+// relu clamps negative activations to zero.
+type relu struct {
+	shape Shape
+}
+
+func (l relu) OutputShape() Shape { return l.shape }
+
+func (l relu) Params() [][]float64 { return nil }
+
+func (l relu) Forward(in []float64) ([]float64, []int) {
+	out := make([]float64, len(in))
+	for i, x := range in {
+		if x > 0 {
+			out[i] = x
+		}
+	}
+	return out, nil
+}
+
+func (l relu) Backward(in []float64, _ []int, gradOut []float64, _ [][]float64) []float64 {
+	gradIn := make([]float64, len(in))
+	for i, x := range in {
+		if x > 0 {
+			gradIn[i] = gradOut[i]
+		}
+	}
+	return gradIn
+}
+
+// flatten reshapes its input into a vector. Activations are already flat,
+// so only the shape changes.
+type flatten struct {
+	out Shape
+}
+
+func (l flatten) OutputShape() Shape { return l.out }
+
+func (l flatten) Params() [][]float64 { return nil }
+
+func (l flatten) Forward(in []float64) ([]float64, []int) { return in, nil }
+
+func (l flatten) Backward(_ []float64, _ []int, gradOut []float64, _ [][]float64) []float64 {
+	return gradOut
+}
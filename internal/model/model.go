@@ -6,7 +6,33 @@ type Batch struct {
 	Labels []int
 }
 
+// Evaluation is the result of scoring a batch without updating weights.
+type Evaluation struct {
+	// Loss is the mean cross-entropy over the scored samples.
+	Loss float64
+	// Predictions holds the top-1 class per input; inputs of the wrong size
+	// are predicted as -1 and excluded from Loss.
+	Predictions []int
+}
+
 // Model defines the minimal training functionality required by the demo.
 type Model interface {
 	TrainStep(batch Batch) float64
+	// Predict returns class probabilities for one input.
+	Predict(input []float64) []float64
+	// Evaluate scores a batch without changing the model.
+	Evaluate(batch Batch) Evaluation
+}
+
+// Differentiable is a Model whose gradient computation is separate from its
+// parameter update, so gradients of several batch slices can be combined
+// and handed to an Optimizer.
+type Differentiable interface {
+	Model
+	// ComputeGradients returns the gradients summed over batch without
+	// changing the model. It is safe to call concurrently.
+	ComputeGradients(batch Batch) Gradients
+	// Params returns the model's parameter tensors, in the order of
+	// Gradients.Params. An Optimizer updates them in place.
+	Params() [][]float64
 }
@@ -0,0 +1,220 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Network is a stack of layers ending in a dense classifier with softmax
+// cross-entropy.
+type Network struct {
+	input      Shape
+	numClasses int
+	// specs are the configured layers; layers also holds the classifier.
+	specs  []LayerSpec
+	layers []Layer
+	lr     float64
+}
+
+// NewNetwork builds the layers in specs for inputs of shape input, followed
+// by a dense layer to numClasses. With no specs it is a linear classifier
+// like SimpleCNN.
+func NewNetwork(specs []LayerSpec, input Shape, numClasses int, lr float64, seed int64) (*Network, error) {
+	if numClasses <= 0 {
+		return nil, fmt.Errorf("model: classes must be > 0 (got %d)", numClasses)
+	}
+	if lr <= 0 {
+		lr = 0.01
+	}
+	n := &Network{input: input, numClasses: numClasses, lr: lr}
+	for _, spec := range specs {
+		n.specs = append(n.specs, spec.withDefaults())
+	}
+	rng := rand.New(rand.NewSource(seed))
+	shape := input
+	for i, spec := range append(n.specs, LayerSpec{Type: "dense", Units: numClasses}) {
+		layer, err := NewLayer(spec, shape, rng)
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", i, err)
+		}
+		n.layers = append(n.layers, layer)
+		shape = layer.OutputShape()
+	}
+	return n, nil
+}
+
+// String lists the layers, classifier included, with their output shapes.
+func (n *Network) String() string {
+	parts := []string{n.input.String()}
+	for i, layer := range n.layers {
+		spec := LayerSpec{Type: "dense", Units: n.numClasses}
+		if i < len(n.specs) {
+			spec = n.specs[i]
+		}
+		parts = append(parts, fmt.Sprintf("%s:%s", spec, layer.OutputShape()))
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// TrainStep executes one SGD step per sample and returns average loss.
+func (n *Network) TrainStep(batch Batch) float64 {
+	if len(batch.Inputs) == 0 {
+		return 0
+	}
+	params := n.Params()
+	grads := shapeLike(nil, params)
+	totalLoss := 0.0
+	for i, input := range batch.Inputs {
+		loss, ok := n.sampleGradient(input, batch.Labels[i], grads)
+		if !ok {
+			continue
+		}
+		totalLoss += loss
+		for t, p := range params {
+			g := grads[t]
+			for j := range p {
+				p[j] -= n.lr * g[j]
+				g[j] = 0
+			}
+		}
+	}
+	return totalLoss / float64(len(batch.Inputs))
+}
+
+// ComputeGradients returns the parameter gradients summed over batch.
+// Inputs of the wrong size are skipped.
+func (n *Network) ComputeGradients(batch Batch) Gradients {
+	grads := Gradients{Params: shapeLike(nil, n.Params())}
+	for i, input := range batch.Inputs {
+		loss, ok := n.sampleGradient(input, batch.Labels[i], grads.Params)
+		if !ok {
+			continue
+		}
+		grads.Loss += loss
+		grads.Samples++
+	}
+	return grads
+}
+
+// SetLearningRate changes the rate used by TrainStep.
+func (n *Network) SetLearningRate(lr float64) {
+	if lr > 0 {
+		n.lr = lr
+	}
+}
+
+// Params returns the live parameter tensors of every layer in order.
+func (n *Network) Params() [][]float64 {
+	var params [][]float64
+	for _, layer := range n.layers {
+		params = append(params, layer.Params()...)
+	}
+	return params
+}
+
+// sampleGradient adds the gradients of one sample's loss to grads and
+// returns the loss, or false when the input has the wrong size.
+func (n *Network) sampleGradient(input []float64, label int, grads [][]float64) (float64, bool) {
+	acts, tapes := n.forward(input)
+	if acts == nil {
+		return 0, false
+	}
+	probs := softmax(acts[len(acts)-1])
+	label = wrapLabel(label, n.numClasses)
+	loss := -math.Log(math.Max(probs[label], 1e-9))
+	probs[label] -= 1
+
+	grad := probs
+	offset := len(grads)
+	for i := len(n.layers) - 1; i >= 0; i-- {
+		layer := n.layers[i]
+		offset -= len(layer.Params())
+		grad = layer.Backward(acts[i], tapes[i], grad, grads[offset:])
+	}
+	return loss, true
+}
+
+// forward returns the input and the output of every layer, with the tape
+// each layer recorded, or nil when the input has the wrong size.
+func (n *Network) forward(input []float64) ([][]float64, [][]int) {
+	if len(input) != n.input.Size() {
+		return nil, nil
+	}
+	acts := make([][]float64, 1, len(n.layers)+1)
+	acts[0] = input
+	tapes := make([][]int, len(n.layers))
+	for i, layer := range n.layers {
+		var out []float64
+		out, tapes[i] = layer.Forward(acts[i])
+		acts = append(acts, out)
+	}
+	return acts, tapes
+}
+
+// Predict returns softmax class probabilities for input, or nil when the
+// input has the wrong size.
+func (n *Network) Predict(input []float64) []float64 {
+	acts, _ := n.forward(input)
+	if acts == nil {
+		return nil
+	}
+	return softmax(acts[len(acts)-1])
+}
+
+// Evaluate returns the mean loss and top-1 predictions for batch.
+func (n *Network) Evaluate(batch Batch) Evaluation {
+	return evaluate(batch, n.numClasses, n.Predict)
+}
+
+// NetworkState is a serializable copy of the network layout and
+// parameters.
+type NetworkState struct {
+	Input      Shape       `json:"input"`
+	NumClasses int         `json:"num_classes"`
+	Layers     []LayerSpec `json:"layers"`
+	Params     [][]float64 `json:"params"`
+	LR         float64     `json:"lr"`
+}
+
+// State returns a copy of the current parameters.
+func (n *Network) State() NetworkState {
+	return NetworkState{
+		Input:      n.input,
+		NumClasses: n.numClasses,
+		Layers:     append([]LayerSpec(nil), n.specs...),
+		Params:     copyTensors(n.Params()),
+		LR:         n.lr,
+	}
+}
+
+// LoadState replaces the parameters with st, which must come from a
+// network of the same layout.
+func (n *Network) LoadState(st NetworkState) error {
+	if st.Input != n.input || st.NumClasses != n.numClasses || len(st.Layers) != len(n.specs) {
+		return fmt.Errorf("model: state layout %s/%d classes/%d layers does not match network %s/%d classes/%d layers",
+			st.Input, st.NumClasses, len(st.Layers), n.input, n.numClasses, len(n.specs))
+	}
+	for i, spec := range st.Layers {
+		if spec != n.specs[i] {
+			return fmt.Errorf("model: state layer %d is %s, network has %s", i, spec, n.specs[i])
+		}
+	}
+	params := n.Params()
+	if len(st.Params) != len(params) {
+		return fmt.Errorf("model: state has %d parameter tensors, want %d", len(st.Params), len(params))
+	}
+	for i, p := range params {
+		if len(st.Params[i]) != len(p) {
+			return fmt.Errorf("model: state tensor %d has %d values, want %d", i, len(st.Params[i]), len(p))
+		}
+	}
+	for i, p := range params {
+		copy(p, st.Params[i])
+	}
+	if st.LR > 0 {
+		n.lr = st.LR
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func testNetworkBatch(shape Shape, n int) Batch {
+	rng := rand.New(rand.NewSource(3))
+	batch := Batch{}
+	for i := 0; i < n; i++ {
+		input := make([]float64, shape.Size())
+		for j := range input {
+			input[j] = rng.Float64()
+		}
+		batch.Inputs = append(batch.Inputs, input)
+		batch.Labels = append(batch.Labels, i%3)
+	}
+	return batch
+}
+
+var testSpecs = []LayerSpec{
+	{Type: "conv2d", Filters: 3, Kernel: 3, Padding: 1},
+	{Type: "relu"},
+	{Type: "max_pool"},
+	{Type: "conv2d", Filters: 2, Kernel: 2, Stride: 2},
+	{Type: "avg_pool", Size: 1},
+	{Type: "flatten"},
+	{Type: "dense", Units: 5},
+	{Type: "relu"},
+}
+
+func TestNetworkGradientsMatchFiniteDifferences(t *testing.T) {
+	shape := Shape{C: 2, H: 8, W: 8}
+	net, err := NewNetwork(testSpecs, shape, 3, 0.1, 1)
+	if err != nil {
+		t.Fatalf("NewNetwork: %v", err)
+	}
+	batch := testNetworkBatch(shape, 3)
+	grads := net.ComputeGradients(batch)
+	if grads.Samples != 3 {
+		t.Fatalf("got %d samples, want 3", grads.Samples)
+	}
+	loss := func() float64 { return net.Evaluate(batch).Loss * 3 }
+
+	const eps = 1e-6
+	for i, p := range net.Params() {
+		for _, j := range []int{0, len(p) / 2, len(p) - 1} {
+			orig := p[j]
+			p[j] = orig + eps
+			up := loss()
+			p[j] = orig - eps
+			down := loss()
+			p[j] = orig
+			want := (up - down) / (2 * eps)
+			if got := grads.Params[i][j]; math.Abs(got-want) > 1e-5*math.Max(1, math.Abs(want)) {
+				t.Fatalf("tensor %d[%d]: gradient %g, finite difference %g", i, j, got, want)
+			}
+		}
+	}
+}
+
+func TestNetworkTrainStepReducesLoss(t *testing.T) {
+	shape := Shape{C: 2, H: 8, W: 8}
+	net, err := NewNetwork(testSpecs, shape, 3, 0.05, 1)
+	if err != nil {
+		t.Fatalf("NewNetwork: %v", err)
+	}
+	batch := testNetworkBatch(shape, 6)
+	first := net.Evaluate(batch).Loss
+	for i := 0; i < 30; i++ {
+		net.TrainStep(batch)
+	}
+	if last := net.Evaluate(batch).Loss; last >= first {
+		t.Fatalf("loss went from %f to %f", first, last)
+	}
+}
+
+func TestNetworkStateRoundTrip(t *testing.T) {
+	shape := Shape{C: 1, H: 6, W: 6}
+	src, _ := NewNetwork(testSpecs[:3], shape, 3, 0.1, 1)
+	batch := testNetworkBatch(shape, 2)
+	src.TrainStep(batch)
+
+	dst, _ := NewNetwork(testSpecs[:3], shape, 3, 0.5, 9)
+	if err := dst.LoadState(src.State()); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if a, b := src.TrainStep(batch), dst.TrainStep(batch); a != b {
+		t.Fatalf("restored network diverged: %f vs %f", a, b)
+	}
+	other, _ := NewNetwork(testSpecs[:2], shape, 3, 0.1, 1)
+	if err := dst.LoadState(other.State()); err == nil {
+		t.Fatal("expected a layout mismatch error")
+	}
+}
+
+func TestNetworkRejectsBadSpecs(t *testing.T) {
+	shape := Shape{C: 1, H: 4, W: 4}
+	for _, specs := range [][]LayerSpec{
+		{{Type: "conv2d", Filters: 2, Kernel: 5}},
+		{{Type: "dense"}},
+		{{Type: "max_pool", Size: 3}, {Type: "max_pool", Size: 3}},
+		{{Type: "dropout"}},
+	} {
+		if _, err := NewNetwork(specs, shape, 3, 0.1, 1); err == nil {
+			t.Fatalf("expected an error for %v", specs)
+		}
+	}
+	if net, _ := NewNetwork(nil, shape, 3, 0.1, 1); net.Predict(make([]float64, 5)) != nil {
+		t.Fatal("expected nil prediction for a wrong-sized input")
+	}
+}
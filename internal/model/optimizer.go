@@ -0,0 +1,222 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Optimizer updates parameters in place from their gradients.
+type Optimizer interface {
+	// Step applies one update. params and grads share the layout of
+	// Differentiable.Params; grads are normally a Gradients.Mean.
+	Step(params, grads [][]float64)
+	// SetLearningRate changes the rate used by later steps.
+	SetLearningRate(lr float64)
+	// State returns a copy of the optimizer's accumulated state.
+	State() OptimizerState
+	// LoadState restores state written by an optimizer of the same kind.
+	LoadState(st OptimizerState) error
+}
+
+// OptimizerConfig selects and tunes an optimizer. Zero Beta1, Beta2 and
+// Epsilon take the usual Adam defaults.
+type OptimizerConfig struct {
+	// Name is "sgd", "adam" or "adamw".
+	Name string  `yaml:"name" json:"name"`
+	LR   float64 `yaml:"lr" json:"lr"`
+	// Momentum and Nesterov apply to sgd only.
+	Momentum float64 `yaml:"momentum" json:"momentum"`
+	Nesterov bool    `yaml:"nesterov" json:"nesterov"`
+	Beta1    float64 `yaml:"beta1" json:"beta1"`
+	Beta2    float64 `yaml:"beta2" json:"beta2"`
+	Epsilon  float64 `yaml:"epsilon" json:"epsilon"`
+	// WeightDecay is an L2 penalty added to the gradients for sgd and adam,
+	// and decoupled from the gradients (applied to the weights directly)
+	// for adamw.
+	WeightDecay float64 `yaml:"weight_decay" json:"weight_decay"`
+}
+
+// OptimizerState is the serializable state of an Optimizer. Slots hold
+// per-parameter buffers such as momentum, keyed by name.
+type OptimizerState struct {
+	Name  string                 `json:"name"`
+	Steps int64                  `json:"steps"`
+	Slots map[string][][]float64 `json:"slots,omitempty"`
+}
+
+// NewOptimizer builds the optimizer described by cfg.
+func NewOptimizer(cfg OptimizerConfig) (Optimizer, error) {
+	if cfg.LR <= 0 {
+		return nil, fmt.Errorf("optimizer: learning rate must be > 0 (got %g)", cfg.LR)
+	}
+	if cfg.WeightDecay < 0 {
+		return nil, fmt.Errorf("optimizer: weight decay must be >= 0 (got %g)", cfg.WeightDecay)
+	}
+	switch name := strings.ToLower(cfg.Name); name {
+	case "sgd":
+		if cfg.Momentum < 0 || cfg.Momentum >= 1 {
+			return nil, fmt.Errorf("optimizer: momentum must be in [0, 1) (got %g)", cfg.Momentum)
+		}
+		if cfg.Nesterov && cfg.Momentum == 0 {
+			return nil, fmt.Errorf("optimizer: nesterov requires momentum")
+		}
+		return &sgd{cfg: cfg}, nil
+	case "adam", "adamw":
+		if cfg.Beta1 == 0 {
+			cfg.Beta1 = 0.9
+		}
+		if cfg.Beta2 == 0 {
+			cfg.Beta2 = 0.999
+		}
+		if cfg.Epsilon == 0 {
+			cfg.Epsilon = 1e-8
+		}
+		if cfg.Beta1 < 0 || cfg.Beta1 >= 1 || cfg.Beta2 < 0 || cfg.Beta2 >= 1 {
+			return nil, fmt.Errorf("optimizer: betas must be in [0, 1) (got %g, %g)", cfg.Beta1, cfg.Beta2)
+		}
+		return &adam{cfg: cfg, decoupled: name == "adamw"}, nil
+	}
+	return nil, fmt.Errorf("optimizer: unknown optimizer %q (want sgd, adam or adamw)", cfg.Name)
+}
+
+// sgd is stochastic gradient descent with optional (Nesterov) momentum.
+type sgd struct {
+	cfg      OptimizerConfig
+	steps    int64
+	velocity [][]float64
+}
+
+func (o *sgd) Step(params, grads [][]float64) {
+	o.steps++
+	if o.cfg.Momentum > 0 {
+		o.velocity = shapeLike(o.velocity, params)
+	}
+	mu, lr, wd := o.cfg.Momentum, o.cfg.LR, o.cfg.WeightDecay
+	for i, p := range params {
+		for j, g := range grads[i] {
+			g += wd * p[j]
+			if mu > 0 {
+				v := mu*o.velocity[i][j] + g
+				o.velocity[i][j] = v
+				if o.cfg.Nesterov {
+					g += mu * v
+				} else {
+					g = v
+				}
+			}
+			p[j] -= lr * g
+		}
+	}
+}
+
+func (o *sgd) SetLearningRate(lr float64) { o.cfg.LR = lr }
+
+func (o *sgd) State() OptimizerState {
+	st := OptimizerState{Name: "sgd", Steps: o.steps}
+	if o.velocity != nil {
+		st.Slots = map[string][][]float64{"velocity": copyTensors(o.velocity)}
+	}
+	return st
+}
+
+func (o *sgd) LoadState(st OptimizerState) error {
+	if st.Name != "sgd" {
+		return fmt.Errorf("optimizer: state for %q cannot be loaded into sgd", st.Name)
+	}
+	o.steps = st.Steps
+	o.velocity = copyTensors(st.Slots["velocity"])
+	return nil
+}
+
+// adam is Adam with bias correction; decoupled selects AdamW's weight
+// decay.
+type adam struct {
+	cfg       OptimizerConfig
+	decoupled bool
+	steps     int64
+	m, v      [][]float64
+}
+
+func (o *adam) name() string {
+	if o.decoupled {
+		return "adamw"
+	}
+	return "adam"
+}
+
+func (o *adam) Step(params, grads [][]float64) {
+	o.steps++
+	o.m = shapeLike(o.m, params)
+	o.v = shapeLike(o.v, params)
+	b1, b2 := o.cfg.Beta1, o.cfg.Beta2
+	lr, wd, eps := o.cfg.LR, o.cfg.WeightDecay, o.cfg.Epsilon
+	c1 := 1 - math.Pow(b1, float64(o.steps))
+	c2 := 1 - math.Pow(b2, float64(o.steps))
+	for i, p := range params {
+		m, v := o.m[i], o.v[i]
+		for j, g := range grads[i] {
+			if o.decoupled {
+				p[j] -= lr * wd * p[j]
+			} else {
+				g += wd * p[j]
+			}
+			m[j] = b1*m[j] + (1-b1)*g
+			v[j] = b2*v[j] + (1-b2)*g*g
+			p[j] -= lr * (m[j] / c1) / (math.Sqrt(v[j]/c2) + eps)
+		}
+	}
+}
+
+func (o *adam) SetLearningRate(lr float64) { o.cfg.LR = lr }
+
+func (o *adam) State() OptimizerState {
+	st := OptimizerState{Name: o.name(), Steps: o.steps}
+	if o.m != nil {
+		st.Slots = map[string][][]float64{"m": copyTensors(o.m), "v": copyTensors(o.v)}
+	}
+	return st
+}
+
+func (o *adam) LoadState(st OptimizerState) error {
+	if st.Name != o.name() {
+		return fmt.Errorf("optimizer: state for %q cannot be loaded into %s", st.Name, o.name())
+	}
+	o.steps = st.Steps
+	o.m = copyTensors(st.Slots["m"])
+	o.v = copyTensors(st.Slots["v"])
+	return nil
+}
+
+// shapeLike returns buf if it matches the shape of params, or zeroed
+// buffers of that shape otherwise.
+func shapeLike(buf, params [][]float64) [][]float64 {
+	if len(buf) == len(params) {
+		match := true
+		for i := range params {
+			if len(buf[i]) != len(params[i]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return buf
+		}
+	}
+	out := make([][]float64, len(params))
+	for i, p := range params {
+		out[i] = make([]float64, len(p))
+	}
+	return out
+}
+
+func copyTensors(src [][]float64) [][]float64 {
+	if src == nil {
+		return nil
+	}
+	out := make([][]float64, len(src))
+	for i, t := range src {
+		out[i] = append([]float64(nil), t...)
+	}
+	return out
+}
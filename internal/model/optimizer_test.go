@@ -0,0 +1,101 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOptimizersReduceLoss(t *testing.T) {
+	batch := Batch{
+		Inputs: [][]float64{{0.1, 0.2, 0.3, 0.4}, {0.4, 0.3, 0.2, 0.1}, {0.9, 0.1, 0.5, 0.2}},
+		Labels: []int{1, 2, 0},
+	}
+	for _, cfg := range []OptimizerConfig{
+		{Name: "sgd", LR: 0.5},
+		{Name: "sgd", LR: 0.5, Momentum: 0.9},
+		{Name: "sgd", LR: 0.5, Momentum: 0.9, Nesterov: true, WeightDecay: 1e-4},
+		{Name: "adam", LR: 0.05},
+		{Name: "adamw", LR: 0.05, WeightDecay: 0.01},
+	} {
+		opt, err := NewOptimizer(cfg)
+		if err != nil {
+			t.Fatalf("%+v: %v", cfg, err)
+		}
+		mdl := NewSimpleCNN(3, 4, 0.1, 1)
+		first := mdl.Evaluate(batch).Loss
+		for i := 0; i < 20; i++ {
+			opt.Step(mdl.Params(), mdl.ComputeGradients(batch).Mean().Params)
+		}
+		if last := mdl.Evaluate(batch).Loss; last >= first {
+			t.Fatalf("%+v: loss went from %f to %f", cfg, first, last)
+		}
+	}
+}
+
+func TestOptimizerStateResumes(t *testing.T) {
+	batch := Batch{Inputs: [][]float64{{0.1, 0.2, 0.3, 0.4}, {0.4, 0.3, 0.2, 0.1}}, Labels: []int{1, 2}}
+	for _, cfg := range []OptimizerConfig{{Name: "sgd", LR: 0.1, Momentum: 0.9}, {Name: "adamw", LR: 0.01, WeightDecay: 0.1}} {
+		full, _ := NewOptimizer(cfg)
+		mdl := NewSimpleCNN(3, 4, 0.1, 1)
+		var midModel SimpleCNNState
+		var midOpt OptimizerState
+		for i := 0; i < 6; i++ {
+			if i == 3 {
+				midModel, midOpt = mdl.State(), full.State()
+			}
+			full.Step(mdl.Params(), mdl.ComputeGradients(batch).Mean().Params)
+		}
+
+		resumed, _ := NewOptimizer(cfg)
+		if err := resumed.LoadState(midOpt); err != nil {
+			t.Fatalf("%s: LoadState: %v", cfg.Name, err)
+		}
+		restored := NewSimpleCNN(3, 4, 0.1, 7)
+		if err := restored.LoadState(midModel); err != nil {
+			t.Fatalf("LoadState: %v", err)
+		}
+		for i := 3; i < 6; i++ {
+			resumed.Step(restored.Params(), restored.ComputeGradients(batch).Mean().Params)
+		}
+		a, b := mdl.State(), restored.State()
+		for i := range a.Weights {
+			if a.Weights[i] != b.Weights[i] {
+				t.Fatalf("%s: weight %d diverged after resume: %f vs %f", cfg.Name, i, a.Weights[i], b.Weights[i])
+			}
+		}
+	}
+
+	adam, _ := NewOptimizer(OptimizerConfig{Name: "adam", LR: 0.1})
+	sgd, _ := NewOptimizer(OptimizerConfig{Name: "sgd", LR: 0.1})
+	if err := sgd.LoadState(adam.State()); err == nil {
+		t.Fatal("expected adam state to be rejected by sgd")
+	}
+}
+
+func TestAdamWDecouplesWeightDecay(t *testing.T) {
+	opt, err := NewOptimizer(OptimizerConfig{Name: "adamw", LR: 0.1, WeightDecay: 0.5})
+	if err != nil {
+		t.Fatalf("NewOptimizer: %v", err)
+	}
+	params := [][]float64{{2, -4}}
+	opt.Step(params, [][]float64{{0, 0}})
+	// With zero gradients only the decay moves the weights: p -= lr*wd*p.
+	if math.Abs(params[0][0]-1.9) > 1e-12 || math.Abs(params[0][1]+3.8) > 1e-12 {
+		t.Fatalf("unexpected params after decay: %v", params)
+	}
+}
+
+func TestNewOptimizerRejectsBadConfig(t *testing.T) {
+	for _, cfg := range []OptimizerConfig{
+		{Name: "sgd"},
+		{Name: "rmsprop", LR: 0.1},
+		{Name: "sgd", LR: 0.1, Nesterov: true},
+		{Name: "sgd", LR: 0.1, Momentum: 1},
+		{Name: "adam", LR: 0.1, Beta2: 1.5},
+		{Name: "adamw", LR: 0.1, WeightDecay: -1},
+	} {
+		if _, err := NewOptimizer(cfg); err == nil {
+			t.Fatalf("expected an error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Schedule maps a training step, counted from 1, to a learning rate.
+type Schedule interface {
+	LR(step int) float64
+}
+
+// ScheduleConfig selects and tunes a learning-rate schedule. Zero fields
+// take the defaults noted on each.
+type ScheduleConfig struct {
+	// Name is "constant" (default), "step", "cosine" or "one_cycle".
+	Name string
+	// BaseLR is the rate the schedule starts from after warmup, or the peak
+	// rate of one_cycle.
+	BaseLR float64
+	// WarmupSteps ramps the rate linearly from BaseLR/WarmupSteps up to
+	// BaseLR for constant, step and cosine.
+	WarmupSteps int
+	// TotalSteps is the length of the cosine and one_cycle curves; later
+	// steps keep the final rate.
+	TotalSteps int
+	// StepSize and Gamma multiply the rate by Gamma (default 0.1) every
+	// StepSize steps after warmup.
+	StepSize int
+	Gamma    float64
+	// MinLR is the floor cosine decays to.
+	MinLR float64
+	// PctStart (default 0.3) is the share of one_cycle spent rising from
+	// BaseLR/DivFactor (default 25) to BaseLR; the rest anneals down to
+	// BaseLR/DivFactor/FinalDivFactor (default 1e4).
+	PctStart       float64
+	DivFactor      float64
+	FinalDivFactor float64
+}
+
+// NewSchedule builds the schedule described by cfg.
+func NewSchedule(cfg ScheduleConfig) (Schedule, error) {
+	if cfg.BaseLR <= 0 {
+		return nil, fmt.Errorf("schedule: base learning rate must be > 0 (got %g)", cfg.BaseLR)
+	}
+	if cfg.WarmupSteps < 0 || cfg.TotalSteps < 0 || cfg.StepSize < 0 {
+		return nil, fmt.Errorf("schedule: step counts must be >= 0")
+	}
+	switch strings.ToLower(cfg.Name) {
+	case "", "constant":
+		return warmup{steps: cfg.WarmupSteps, base: cfg.BaseLR, after: func(int) float64 { return cfg.BaseLR }}, nil
+	case "step":
+		if cfg.StepSize == 0 {
+			return nil, fmt.Errorf("schedule: step decay needs a step size")
+		}
+		gamma := cfg.Gamma
+		if gamma == 0 {
+			gamma = 0.1
+		}
+		return warmup{steps: cfg.WarmupSteps, base: cfg.BaseLR, after: func(step int) float64 {
+			return cfg.BaseLR * math.Pow(gamma, float64(step/cfg.StepSize))
+		}}, nil
+	case "cosine":
+		if cfg.TotalSteps <= cfg.WarmupSteps {
+			return nil, fmt.Errorf("schedule: cosine needs total steps beyond the %d warmup steps", cfg.WarmupSteps)
+		}
+		if cfg.MinLR < 0 || cfg.MinLR > cfg.BaseLR {
+			return nil, fmt.Errorf("schedule: min lr must be in [0, %g] (got %g)", cfg.BaseLR, cfg.MinLR)
+		}
+		// The first step after warmup runs at BaseLR, step TotalSteps at
+		// MinLR.
+		span := cfg.TotalSteps - cfg.WarmupSteps - 1
+		return warmup{steps: cfg.WarmupSteps, base: cfg.BaseLR, after: func(step int) float64 {
+			if span == 0 {
+				return cfg.MinLR
+			}
+			return cosineAnneal(cfg.BaseLR, cfg.MinLR, float64(step)/float64(span))
+		}}, nil
+	case "one_cycle":
+		if cfg.TotalSteps < 2 {
+			return nil, fmt.Errorf("schedule: one_cycle needs at least 2 total steps")
+		}
+		c := oneCycle{total: cfg.TotalSteps, peak: cfg.BaseLR, pctStart: cfg.PctStart}
+		if c.pctStart == 0 {
+			c.pctStart = 0.3
+		}
+		if c.pctStart < 0 || c.pctStart >= 1 {
+			return nil, fmt.Errorf("schedule: pct_start must be in (0, 1) (got %g)", cfg.PctStart)
+		}
+		div, finalDiv := cfg.DivFactor, cfg.FinalDivFactor
+		if div == 0 {
+			div = 25
+		}
+		if finalDiv == 0 {
+			finalDiv = 1e4
+		}
+		c.initial = cfg.BaseLR / div
+		c.final = c.initial / finalDiv
+		return c, nil
+	}
+	return nil, fmt.Errorf("schedule: unknown schedule %q (want constant, step, cosine or one_cycle)", cfg.Name)
+}
+
+// warmup ramps linearly to base over steps, then follows after, which is
+// called with the number of steps taken since warmup ended.
+type warmup struct {
+	steps int
+	base  float64
+	after func(step int) float64
+}
+
+func (w warmup) LR(step int) float64 {
+	if step < 1 {
+		step = 1
+	}
+	if step <= w.steps {
+		return w.base * float64(step) / float64(w.steps)
+	}
+	return w.after(step - w.steps - 1)
+}
+
+// oneCycle rises from initial to peak over the first pctStart of total
+// steps and anneals to final over the rest, both along a cosine.
+type oneCycle struct {
+	total    int
+	pctStart float64
+	initial  float64
+	peak     float64
+	final    float64
+}
+
+func (c oneCycle) LR(step int) float64 {
+	// Step 1 is position 0 and step total is position total-1.
+	pos := float64(step - 1)
+	last := float64(c.total - 1)
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > last {
+		pos = last
+	}
+	up := c.pctStart * last
+	if pos <= up {
+		return cosineAnneal(c.initial, c.peak, pos/up)
+	}
+	return cosineAnneal(c.peak, c.final, (pos-up)/(last-up))
+}
+
+// cosineAnneal moves from start to end along half a cosine as progress
+// goes from 0 to 1; progress beyond 1 stays at end.
+func cosineAnneal(start, end, progress float64) float64 {
+	if progress > 1 {
+		progress = 1
+	}
+	return end + (start-end)*(1+math.Cos(math.Pi*progress))/2
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSchedules(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  ScheduleConfig
+		want map[int]float64
+	}{
+		{ScheduleConfig{BaseLR: 0.1}, map[int]float64{1: 0.1, 1000: 0.1}},
+		{ScheduleConfig{Name: "constant", BaseLR: 0.1, WarmupSteps: 4}, map[int]float64{1: 0.025, 2: 0.05, 4: 0.1, 5: 0.1}},
+		{ScheduleConfig{Name: "step", BaseLR: 0.1, StepSize: 10, Gamma: 0.5}, map[int]float64{1: 0.1, 10: 0.1, 11: 0.05, 21: 0.025}},
+		{ScheduleConfig{Name: "cosine", BaseLR: 0.1, WarmupSteps: 10, TotalSteps: 111, MinLR: 0.01}, map[int]float64{
+			5: 0.05, 10: 0.1, 11: 0.1, 61: 0.055, 111: 0.01, 500: 0.01,
+		}},
+		{ScheduleConfig{Name: "one_cycle", BaseLR: 1, TotalSteps: 11, PctStart: 0.5, DivFactor: 10, FinalDivFactor: 100}, map[int]float64{
+			1: 0.1, 6: 1, 11: 0.001, 20: 0.001,
+		}},
+	} {
+		sched, err := NewSchedule(tc.cfg)
+		if err != nil {
+			t.Fatalf("%+v: %v", tc.cfg, err)
+		}
+		for step, want := range tc.want {
+			if got := sched.LR(step); math.Abs(got-want) > 1e-12 {
+				t.Fatalf("%s: LR(%d) = %g, want %g", tc.cfg.Name, step, got, want)
+			}
+		}
+	}
+}
+
+func TestOneCycleRisesThenFalls(t *testing.T) {
+	sched, err := NewSchedule(ScheduleConfig{Name: "one_cycle", BaseLR: 0.1, TotalSteps: 100})
+	if err != nil {
+		t.Fatalf("NewSchedule: %v", err)
+	}
+	peak := 0
+	for step := 2; step <= 100; step++ {
+		if sched.LR(step) > sched.LR(peak) || peak == 0 {
+			peak = step
+		}
+	}
+	if peak < 25 || peak > 35 || math.Abs(sched.LR(peak)-0.1) > 1e-3 {
+		t.Fatalf("expected a 0.1 peak near 30%% of the run, got %g at step %d", sched.LR(peak), peak)
+	}
+}
+
+func TestNewScheduleRejectsBadConfig(t *testing.T) {
+	for _, cfg := range []ScheduleConfig{
+		{Name: "constant"},
+		{Name: "step", BaseLR: 0.1},
+		{Name: "cosine", BaseLR: 0.1, WarmupSteps: 10, TotalSteps: 10},
+		{Name: "cosine", BaseLR: 0.1, TotalSteps: 10, MinLR: 1},
+		{Name: "one_cycle", BaseLR: 0.1},
+		{Name: "one_cycle", BaseLR: 0.1, TotalSteps: 10, PctStart: 1},
+		{Name: "linear", BaseLR: 0.1},
+	} {
+		if _, err := NewSchedule(cfg); err == nil {
+			t.Fatalf("expected an error for %+v", cfg)
+		}
+	}
+}
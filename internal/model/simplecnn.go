@@ -1,6 +1,7 @@
 package model
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
 )
@@ -40,46 +41,147 @@ func NewSimpleCNN(numClasses, inputSize int, lr float64, seed int64) *SimpleCNN
 	}
 }
 
-// TrainStep executes one SGD step and returns average loss.
+// TrainStep executes one SGD step per sample and returns average loss.
 func (m *SimpleCNN) TrainStep(batch Batch) float64 {
 	if len(batch.Inputs) == 0 {
 		return 0
 	}
 	totalLoss := 0.0
 	for i, input := range batch.Inputs {
-		if len(input) != m.inputSize {
+		probs, loss := m.sampleGradient(input, batch.Labels[i])
+		if probs == nil {
 			continue
 		}
-		label := batch.Labels[i]
-		if label < 0 || label >= m.numClasses {
-			label = label % m.numClasses
-			if label < 0 {
-				label += m.numClasses
-			}
+		totalLoss += loss
+		m.descend(probs, input, m.lr)
+	}
+	return totalLoss / float64(len(batch.Inputs))
+}
+
+// ComputeGradients returns the weight and bias gradients summed over batch.
+// Inputs of the wrong size are skipped.
+func (m *SimpleCNN) ComputeGradients(batch Batch) Gradients {
+	weights := make([]float64, len(m.weights))
+	bias := make([]float64, len(m.bias))
+	grads := Gradients{Params: [][]float64{weights, bias}}
+	for i, input := range batch.Inputs {
+		probs, loss := m.sampleGradient(input, batch.Labels[i])
+		if probs == nil {
+			continue
 		}
-		logits := make([]float64, m.numClasses)
-		for c := 0; c < m.numClasses; c++ {
-			sum := m.bias[c]
+		grads.Loss += loss
+		grads.Samples++
+		for c, grad := range probs {
+			bias[c] += grad
 			wStart := c * m.inputSize
-			for j := 0; j < m.inputSize; j++ {
-				sum += m.weights[wStart+j] * input[j]
+			for j, x := range input {
+				weights[wStart+j] += grad * x
 			}
-			logits[c] = sum
 		}
-		probs := softmax(logits)
-		totalLoss += -math.Log(math.Max(probs[label], 1e-9))
+	}
+	return grads
+}
 
-		probs[label] -= 1
-		for c := 0; c < m.numClasses; c++ {
-			grad := probs[c]
-			m.bias[c] -= m.lr * grad
-			wStart := c * m.inputSize
-			for j := 0; j < m.inputSize; j++ {
-				m.weights[wStart+j] -= m.lr * grad * input[j]
-			}
+// SetLearningRate changes the rate used by TrainStep.
+func (m *SimpleCNN) SetLearningRate(lr float64) {
+	if lr > 0 {
+		m.lr = lr
+	}
+}
+
+// Params returns the live weight and bias slices.
+func (m *SimpleCNN) Params() [][]float64 {
+	return [][]float64{m.weights, m.bias}
+}
+
+// sampleGradient returns the loss of one sample and the gradient of that
+// loss with respect to the logits, or nil when the input has the wrong
+// size. The weight gradient of class c is the logit gradient times input.
+func (m *SimpleCNN) sampleGradient(input []float64, label int) ([]float64, float64) {
+	probs := m.Predict(input)
+	if probs == nil {
+		return nil, 0
+	}
+	label = wrapLabel(label, m.numClasses)
+	loss := -math.Log(math.Max(probs[label], 1e-9))
+	probs[label] -= 1
+	return probs, loss
+}
+
+// descend applies one sample's logit gradient with learning rate lr.
+func (m *SimpleCNN) descend(logitGrad, input []float64, lr float64) {
+	for c, grad := range logitGrad {
+		m.bias[c] -= lr * grad
+		wStart := c * m.inputSize
+		for j, x := range input {
+			m.weights[wStart+j] -= lr * grad * x
 		}
 	}
-	return totalLoss / float64(len(batch.Inputs))
+}
+
+// Predict returns softmax class probabilities for input, or nil when the
+// input has the wrong size.
+func (m *SimpleCNN) Predict(input []float64) []float64 {
+	if len(input) != m.inputSize {
+		return nil
+	}
+	logits := make([]float64, m.numClasses)
+	for c := 0; c < m.numClasses; c++ {
+		sum := m.bias[c]
+		wStart := c * m.inputSize
+		for j := 0; j < m.inputSize; j++ {
+			sum += m.weights[wStart+j] * input[j]
+		}
+		logits[c] = sum
+	}
+	return softmax(logits)
+}
+
+// Evaluate returns the mean loss and top-1 predictions for batch.
+func (m *SimpleCNN) Evaluate(batch Batch) Evaluation {
+	return evaluate(batch, m.numClasses, m.Predict)
+}
+
+// evaluate scores batch with predict for a model of numClasses classes.
+func evaluate(batch Batch, numClasses int, predict func([]float64) []float64) Evaluation {
+	eval := Evaluation{Predictions: make([]int, len(batch.Inputs))}
+	scored := 0
+	for i, input := range batch.Inputs {
+		probs := predict(input)
+		if probs == nil {
+			eval.Predictions[i] = -1
+			continue
+		}
+		label := wrapLabel(batch.Labels[i], numClasses)
+		eval.Loss += -math.Log(math.Max(probs[label], 1e-9))
+		eval.Predictions[i] = argmax(probs)
+		scored++
+	}
+	if scored > 0 {
+		eval.Loss /= float64(scored)
+	}
+	return eval
+}
+
+// wrapLabel maps label into [0, numClasses).
+func wrapLabel(label, numClasses int) int {
+	if label < 0 || label >= numClasses {
+		label = label % numClasses
+		if label < 0 {
+			label += numClasses
+		}
+	}
+	return label
+}
+
+func argmax(values []float64) int {
+	best := 0
+	for i, v := range values {
+		if v > values[best] {
+			best = i
+		}
+	}
+	return best
 }
 
 func softmax(logits []float64) []float64 {
@@ -102,3 +204,42 @@ func softmax(logits []float64) []float64 {
 	}
 	return out
 }
+
+// SimpleCNNState is a serializable copy of the model parameters.
+type SimpleCNNState struct {
+	NumClasses int       `json:"num_classes"`
+	InputSize  int       `json:"input_size"`
+	Weights    []float64 `json:"weights"`
+	Bias       []float64 `json:"bias"`
+	LR         float64   `json:"lr"`
+}
+
+// State returns a copy of the current parameters.
+func (m *SimpleCNN) State() SimpleCNNState {
+	return SimpleCNNState{
+		NumClasses: m.numClasses,
+		InputSize:  m.inputSize,
+		Weights:    append([]float64(nil), m.weights...),
+		Bias:       append([]float64(nil), m.bias...),
+		LR:         m.lr,
+	}
+}
+
+// LoadState replaces the parameters with st, which must match the model
+// shape.
+func (m *SimpleCNN) LoadState(st SimpleCNNState) error {
+	if st.NumClasses != m.numClasses || st.InputSize != m.inputSize {
+		return fmt.Errorf("model: state shape %dx%d does not match model %dx%d",
+			st.NumClasses, st.InputSize, m.numClasses, m.inputSize)
+	}
+	if len(st.Weights) != m.numClasses*m.inputSize || len(st.Bias) != m.numClasses {
+		return fmt.Errorf("model: state has %d weights and %d biases, want %d and %d",
+			len(st.Weights), len(st.Bias), m.numClasses*m.inputSize, m.numClasses)
+	}
+	copy(m.weights, st.Weights)
+	copy(m.bias, st.Bias)
+	if st.LR > 0 {
+		m.lr = st.LR
+	}
+	return nil
+}
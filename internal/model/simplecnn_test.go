@@ -1,6 +1,9 @@
 package model
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestSimpleCNNTrainStepReducesLoss(t *testing.T) {
 	model := NewSimpleCNN(3, 4, 0.1, 1)
@@ -17,3 +20,85 @@ func TestSimpleCNNTrainStepReducesLoss(t *testing.T) {
 		t.Fatalf("expected loss to decrease; loss1=%f loss2=%f", loss1, loss2)
 	}
 }
+
+func TestSimpleCNNStateRoundTrip(t *testing.T) {
+	src := NewSimpleCNN(3, 4, 0.1, 1)
+	batch := Batch{Inputs: [][]float64{{0.1, 0.2, 0.3, 0.4}}, Labels: []int{2}}
+	src.TrainStep(batch)
+
+	dst := NewSimpleCNN(3, 4, 0.5, 99)
+	if err := dst.LoadState(src.State()); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if a, b := src.TrainStep(batch), dst.TrainStep(batch); a != b {
+		t.Fatalf("restored model diverged: %f vs %f", a, b)
+	}
+	if err := dst.LoadState(NewSimpleCNN(2, 4, 0.1, 1).State()); err == nil {
+		t.Fatal("expected shape mismatch error")
+	}
+}
+
+func TestSimpleCNNEvaluateDoesNotTrain(t *testing.T) {
+	model := NewSimpleCNN(3, 4, 0.1, 1)
+	batch := Batch{
+		Inputs: [][]float64{{0.1, 0.2, 0.3, 0.4}, {0.4, 0.3, 0.2}},
+		Labels: []int{1, 2},
+	}
+	first := model.Evaluate(batch)
+	second := model.Evaluate(batch)
+	if first.Loss != second.Loss {
+		t.Fatalf("Evaluate changed the model: %f vs %f", first.Loss, second.Loss)
+	}
+	if len(first.Predictions) != 2 || first.Predictions[1] != -1 {
+		t.Fatalf("unexpected predictions: %v", first.Predictions)
+	}
+	probs := model.Predict(batch.Inputs[0])
+	if first.Predictions[0] != argmax(probs) {
+		t.Fatalf("prediction %d does not match Predict %v", first.Predictions[0], probs)
+	}
+}
+
+func TestSimpleCNNGradientsMatchTrainStep(t *testing.T) {
+	stepped := NewSimpleCNN(3, 4, 0.1, 1)
+	applied := NewSimpleCNN(3, 4, 0.1, 1)
+	sample := Batch{Inputs: [][]float64{{0.1, 0.2, 0.3, 0.4}}, Labels: []int{2}}
+	stepped.TrainStep(sample)
+
+	grads := applied.ComputeGradients(Batch{Inputs: [][]float64{{0.1, 0.2, 0.3, 0.4}, {1, 2}}, Labels: []int{2, 0}})
+	if grads.Samples != 1 {
+		t.Fatalf("expected the short input to be skipped, got %d samples", grads.Samples)
+	}
+	if before := applied.State(); before.Weights[0] != NewSimpleCNN(3, 4, 0.1, 1).State().Weights[0] {
+		t.Fatal("ComputeGradients changed the model")
+	}
+	opt, err := NewOptimizer(OptimizerConfig{Name: "sgd", LR: 0.1})
+	if err != nil {
+		t.Fatalf("NewOptimizer: %v", err)
+	}
+	opt.Step(applied.Params(), grads.Mean().Params)
+
+	a, b := stepped.State(), applied.State()
+	for i := range a.Weights {
+		if math.Abs(a.Weights[i]-b.Weights[i]) > 1e-12 {
+			t.Fatalf("weight %d: TrainStep %f, sgd %f", i, a.Weights[i], b.Weights[i])
+		}
+	}
+	for c := range a.Bias {
+		if math.Abs(a.Bias[c]-b.Bias[c]) > 1e-12 {
+			t.Fatalf("bias %d: TrainStep %f, sgd %f", c, a.Bias[c], b.Bias[c])
+		}
+	}
+}
+
+func TestGradientsAddAndMean(t *testing.T) {
+	var total Gradients
+	total.Add(Gradients{Params: [][]float64{{1, 2}, {3}}, Loss: 1, Samples: 1})
+	total.Add(Gradients{Params: [][]float64{{3, 4}, {5}}, Loss: 3, Samples: 3})
+	mean := total.Mean()
+	if mean.Params[0][0] != 1 || mean.Params[0][1] != 1.5 || mean.Params[1][0] != 2 || mean.Loss != 1 {
+		t.Fatalf("unexpected mean %+v", mean)
+	}
+	if total.Params[0][0] != 4 {
+		t.Fatal("Mean modified its receiver")
+	}
+}
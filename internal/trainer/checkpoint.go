@@ -0,0 +1,211 @@
+package trainer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"warpdrive-forge/internal/logging"
+	"warpdrive-forge/internal/model"
+)
+
+// checkpointVersion is bumped whenever the on-disk layout changes
+// incompatibly; older versions are ignored by LoadLatestCheckpoint.
+// Version 2 replaced the sampler's rng_draws with per-epoch seeds.
+const checkpointVersion = 2
+
+const defaultKeepCheckpoints = 3
+
+// Checkpoint is the versioned on-disk snapshot written by Run.
+type Checkpoint struct {
+	Version    int    `json:"version"`
+	ConfigHash string `json:"config_hash"`
+	State      State  `json:"state"`
+	// Model holds the parameters of a SimpleCNN run and Network those of a
+	// layered one.
+	Model   *model.SimpleCNNState `json:"model,omitempty"`
+	Network *model.NetworkState   `json:"network,omitempty"`
+	// Optimizer is the state of the run's optimizer, when it has one.
+	Optimizer *model.OptimizerState `json:"optimizer,omitempty"`
+}
+
+// ConfigHash fingerprints the parts of cfg that determine the sample stream
+// and model shape, so a checkpoint is only resumed into a compatible run.
+// Step counts and logging knobs are deliberately excluded.
+func ConfigHash(cfg RunConfig) string {
+	h := sha256.New()
+	names := make([]string, 0, len(cfg.Roots))
+	for name := range cfg.Roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "root=%s weight=%g\n", name, cfg.Weights[name])
+		for _, shard := range cfg.Roots[name] {
+			fmt.Fprintf(h, "shard=%s\n", shard)
+		}
+	}
+	fmt.Fprintf(h, "seed=%d batch=%d classes=%d features=%d\n", cfg.Seed, cfg.BatchSize, numClasses, cfg.Preprocess.shape().Size())
+	if p := cfg.Preprocess; p.Mode != PreprocessRaw {
+		fmt.Fprintf(h, "preprocess=%s mean=%v std=%v crop=%g flip=%t brightness=%g\n", p.Mode, p.Mean, p.Std, p.CropScale, p.Flip, p.Brightness)
+	}
+	if cfg.ShuffleSamples > 0 || cfg.ShuffleBytes > 0 {
+		fmt.Fprintf(h, "shuffle=%d/%d\n", cfg.ShuffleSamples, cfg.ShuffleBytes)
+	}
+	for _, layer := range cfg.Layers {
+		fmt.Fprintf(h, "layer=%+v\n", layer)
+	}
+	if cfg.WorldSize > 1 {
+		fmt.Fprintf(h, "rank=%d world=%d partition=%s\n", cfg.Rank, cfg.WorldSize, cfg.Partition)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// LoadLatestCheckpoint returns the newest readable checkpoint in dir, or
+// (nil, nil) when there is none. Unreadable or unsupported files are
+// logged and skipped.
+func LoadLatestCheckpoint(dir string) (*Checkpoint, error) {
+	paths, err := listCheckpoints(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(paths) - 1; i >= 0; i-- {
+		ckpt, err := readCheckpoint(paths[i])
+		if err != nil {
+			logging.Error("checkpoint_skipped", err, logging.String("path", paths[i]))
+			continue
+		}
+		logging.Info("checkpoint_loaded", logging.String("path", paths[i]), logging.Int("step", ckpt.State.Step))
+		return ckpt, nil
+	}
+	return nil, nil
+}
+
+func readCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read checkpoint: %w", err)
+	}
+	var ckpt Checkpoint
+	if err := json.Unmarshal(data, &ckpt); err != nil {
+		return nil, fmt.Errorf("parse checkpoint: %w", err)
+	}
+	if ckpt.Version != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d", ckpt.Version)
+	}
+	return &ckpt, nil
+}
+
+func listCheckpoints(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "ckpt-*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("list checkpoints: %w", err)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// checkpointer writes checkpoints into dir and prunes all but the newest
+// keep files.
+type checkpointer struct {
+	dir  string
+	keep int
+	hash string
+}
+
+// restoreModel loads the model parameters saved in ckpt into mdl.
+func restoreModel(mdl trainable, ckpt *Checkpoint) error {
+	switch m := mdl.(type) {
+	case *model.SimpleCNN:
+		if ckpt.Model != nil {
+			return m.LoadState(*ckpt.Model)
+		}
+	case *model.Network:
+		if ckpt.Network != nil {
+			return m.LoadState(*ckpt.Network)
+		}
+	}
+	return errors.New("trainer: checkpoint holds no parameters for this model")
+}
+
+func (c *checkpointer) save(state *State, mdl trainable, opt model.Optimizer) error {
+	if c == nil || state == nil {
+		return nil
+	}
+	var optState *model.OptimizerState
+	if opt != nil {
+		st := opt.State()
+		optState = &st
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return fmt.Errorf("create checkpoint dir: %w", err)
+	}
+	snapshot := Checkpoint{
+		Version:    checkpointVersion,
+		ConfigHash: c.hash,
+		State:      *state,
+		Optimizer:  optState,
+	}
+	switch m := mdl.(type) {
+	case *model.SimpleCNN:
+		st := m.State()
+		snapshot.Model = &st
+	case *model.Network:
+		st := m.State()
+		snapshot.Network = &st
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("encode checkpoint: %w", err)
+	}
+	path := filepath.Join(c.dir, fmt.Sprintf("ckpt-%09d.json", state.Step))
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+	return c.prune()
+}
+
+func (c *checkpointer) prune() error {
+	paths, err := listCheckpoints(c.dir)
+	if err != nil {
+		return err
+	}
+	for len(paths) > c.keep {
+		if err := os.Remove(paths[0]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("prune checkpoint: %w", err)
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it
+// into place so readers never observe a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename %s: %w", path, err)
+	}
+	return nil
+}
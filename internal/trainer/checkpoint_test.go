@@ -0,0 +1,87 @@
+package trainer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"warpdrive-forge/internal/model"
+)
+
+func TestCheckpointerRetainsNewest(t *testing.T) {
+	dir := t.TempDir()
+	mdl := model.NewSimpleCNN(numClasses, featureSize, 0.05, 1)
+	ckpt := &checkpointer{dir: dir, keep: 2, hash: "abc"}
+	for step := 1; step <= 4; step++ {
+		if err := ckpt.save(&State{Step: step}, mdl, nil); err != nil {
+			t.Fatalf("save step %d: %v", step, err)
+		}
+	}
+	paths, err := listCheckpoints(dir)
+	if err != nil {
+		t.Fatalf("listCheckpoints: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("expected 2 retained checkpoints, got %v", paths)
+	}
+
+	// A corrupt newer file must be skipped in favour of the last good one.
+	if err := os.WriteFile(filepath.Join(dir, "ckpt-000000005.json"), []byte("{"), 0o644); err != nil {
+		t.Fatalf("write corrupt checkpoint: %v", err)
+	}
+	latest, err := LoadLatestCheckpoint(dir)
+	if err != nil {
+		t.Fatalf("LoadLatestCheckpoint: %v", err)
+	}
+	if latest == nil || latest.State.Step != 4 || latest.ConfigHash != "abc" {
+		t.Fatalf("unexpected checkpoint: %+v", latest)
+	}
+	restored := model.NewSimpleCNN(numClasses, featureSize, 0.05, 2)
+	if err := restored.LoadState(*latest.Model); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+}
+
+func TestLoadLatestCheckpointEmptyDir(t *testing.T) {
+	latest, err := LoadLatestCheckpoint(t.TempDir())
+	if err != nil || latest != nil {
+		t.Fatalf("expected no checkpoint, got %+v, %v", latest, err)
+	}
+}
+
+func TestCheckpointRestoresNetwork(t *testing.T) {
+	dir := t.TempDir()
+	cfg := RunConfig{Layers: []model.LayerSpec{{Type: "conv2d", Filters: 2, Kernel: 3}, {Type: "relu"}, {Type: "max_pool"}}}
+	src, err := newModel(cfg)
+	if err != nil {
+		t.Fatalf("newModel: %v", err)
+	}
+	ckpt := &checkpointer{dir: dir, keep: 1, hash: ConfigHash(cfg)}
+	if err := ckpt.save(&State{Step: 1}, src, nil); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	latest, err := LoadLatestCheckpoint(dir)
+	if err != nil || latest == nil {
+		t.Fatalf("LoadLatestCheckpoint: %v, %v", latest, err)
+	}
+	if latest.Model != nil || latest.Network == nil {
+		t.Fatalf("expected network parameters only, got %+v", latest)
+	}
+
+	cfg.Seed = 5
+	dst, _ := newModel(cfg)
+	if err := restoreModel(dst, latest); err != nil {
+		t.Fatalf("restoreModel: %v", err)
+	}
+	input := make([]float64, featureSize)
+	input[3] = 1
+	if a, b := src.Predict(input), dst.Predict(input); a[0] != b[0] {
+		t.Fatalf("restored network diverged: %v vs %v", a, b)
+	}
+	if err := restoreModel(model.NewSimpleCNN(numClasses, featureSize, 0.05, 1), latest); err == nil {
+		t.Fatal("expected network parameters to be rejected by SimpleCNN")
+	}
+	if ConfigHash(cfg) == ConfigHash(RunConfig{Seed: 5}) {
+		t.Fatal("expected the layers to change the config hash")
+	}
+}
@@ -0,0 +1,164 @@
+package trainer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"warpdrive-forge/internal/dataset"
+	"warpdrive-forge/internal/logging"
+	"warpdrive-forge/internal/model"
+)
+
+// EvalResult summarises one pass over the validation roots.
+type EvalResult struct {
+	Samples  int
+	Loss     float64
+	Accuracy float64
+	// Confusion[label][predicted] counts samples per true and predicted
+	// class.
+	Confusion [][]int
+}
+
+// evaluator scores the model on held-out shards. Shards are read one at a
+// time in sorted root then path order, so every pass sees the same samples.
+type evaluator struct {
+	shards     []evalShard
+	batchSize  int
+	maxSamples int
+	policy     dataset.ErrorPolicy
+	// quarantine lists shards to pass over; validation shards are never
+	// added to it.
+	quarantine *dataset.Quarantine
+	pre        *preprocessor
+	tel        *telemetry
+}
+
+type evalShard struct {
+	root string
+	path string
+}
+
+func newEvaluator(roots map[string][]string, batchSize, maxSamples int, policy dataset.ErrorPolicy, quarantine *dataset.Quarantine, pre *preprocessor, tel *telemetry) *evaluator {
+	var shards []evalShard
+	for _, name := range sortedKeys(roots) {
+		paths := append([]string(nil), roots[name]...)
+		sort.Strings(paths)
+		for _, path := range paths {
+			shards = append(shards, evalShard{root: name, path: path})
+		}
+	}
+	return &evaluator{shards: shards, batchSize: batchSize, maxSamples: maxSamples, policy: policy, quarantine: quarantine, pre: pre, tel: tel}
+}
+
+// run makes a single pass over the validation shards. A shard that fails
+// mid-read ends the pass under PolicyFail; under the skip policies it is
+// logged and the pass continues with the next one.
+func (e *evaluator) run(ctx context.Context, mdl model.Model) (EvalResult, error) {
+	result := EvalResult{Confusion: make([][]int, numClasses)}
+	for i := range result.Confusion {
+		result.Confusion[i] = make([]int, numClasses)
+	}
+	var lossSum float64
+	correct := 0
+	batch := model.Batch{}
+	flush := func() {
+		if len(batch.Inputs) == 0 {
+			return
+		}
+		eval := mdl.Evaluate(batch)
+		for i, predicted := range eval.Predictions {
+			if predicted < 0 {
+				continue
+			}
+			label := batch.Labels[i]
+			result.Confusion[label][predicted]++
+			if predicted == label {
+				correct++
+			}
+		}
+		lossSum += eval.Loss * float64(len(batch.Inputs))
+		result.Samples += len(batch.Inputs)
+		batch = model.Batch{}
+	}
+
+	for _, shard := range e.shards {
+		if e.quarantine.Contains(shard.path) {
+			continue
+		}
+		if e.maxSamples > 0 && result.Samples+len(batch.Inputs) >= e.maxSamples {
+			break
+		}
+		err := e.readShard(ctx, shard, &batch, result.Samples, flush)
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			e.tel.errors.With("shard").Inc()
+			if e.policy == dataset.PolicyFail {
+				return result, fmt.Errorf("eval shard %s: %w", shard.path, err)
+			}
+			logging.Error("eval_shard_failed", err, logging.String("root", shard.root), logging.String("shard", shard.path))
+		}
+	}
+	flush()
+
+	if result.Samples > 0 {
+		result.Loss = lossSum / float64(result.Samples)
+		result.Accuracy = float64(correct) / float64(result.Samples)
+	}
+	return result, nil
+}
+
+// readShard appends samples from shard to batch, calling flush whenever the
+// batch is full, and stops early once maxSamples have been gathered.
+func (e *evaluator) readShard(ctx context.Context, shard evalShard, batch *model.Batch, scored int, flush func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	samples, errs := dataset.StreamShard(ctx, shard.path, 0)
+	for sample := range samples {
+		features, err := e.pre.features(sample)
+		if err != nil {
+			e.tel.errors.With("decode").Inc()
+			continue
+		}
+		batch.Inputs = append(batch.Inputs, features)
+		batch.Labels = append(batch.Labels, clampLabel(sample.Label))
+		if e.maxSamples > 0 && scored+len(batch.Inputs) >= e.maxSamples {
+			cancel()
+			for range samples {
+			}
+			<-errs
+			return nil
+		}
+		if len(batch.Inputs) == e.batchSize {
+			scored += len(batch.Inputs)
+			flush()
+		}
+	}
+	return <-errs
+}
+
+func (e *evaluator) evaluate(ctx context.Context, step int, mdl model.Model) error {
+	start := time.Now()
+	result, err := e.run(ctx, mdl)
+	if err != nil {
+		return err
+	}
+	e.tel.evalLoss.Set(result.Loss)
+	e.tel.evalAccuracy.Set(result.Accuracy)
+	logging.Info("eval",
+		logging.Int("step", step),
+		logging.Int("samples", result.Samples),
+		logging.Float("loss", result.Loss, 4),
+		logging.Float("accuracy", result.Accuracy, 4),
+		logging.Float("eval_ms", durationMS(time.Since(start)), 1),
+		logging.Any("confusion", result.Confusion),
+	)
+	return nil
+}
+
+func durationMS(d time.Duration) float64 {
+	return d.Seconds() * 1000
+}
@@ -0,0 +1,138 @@
+package trainer
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"warpdrive-forge/internal/dataset"
+	"warpdrive-forge/internal/metrics"
+	"warpdrive-forge/internal/model"
+)
+
+func TestEvaluatorSinglePass(t *testing.T) {
+	dir := t.TempDir()
+	writeEvalShard(t, filepath.Join(dir, "val-000000.tar"), 0, 6)
+	writeEvalShard(t, filepath.Join(dir, "val-000001.tar"), 6, 5)
+
+	tel := newTelemetry(metrics.NewRegistry())
+	roots := map[string][]string{"val": {filepath.Join(dir, "val-000001.tar"), filepath.Join(dir, "val-000000.tar")}}
+	mdl := model.NewSimpleCNN(numClasses, featureSize, 0.05, 1)
+
+	eval := newEvaluator(roots, 4, 0, dataset.PolicyFail, nil, newPreprocessor(PreprocessConfig{}, 1), tel)
+	first, err := eval.run(context.Background(), mdl)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if first.Samples != 11 {
+		t.Fatalf("expected 11 samples, got %d", first.Samples)
+	}
+	total := 0
+	for label, row := range first.Confusion {
+		for _, n := range row {
+			total += n
+		}
+		if sum := sumInts(row); label < 3 && sum == 0 {
+			t.Fatalf("label %d missing from confusion matrix %v", label, first.Confusion)
+		}
+	}
+	if total != first.Samples {
+		t.Fatalf("confusion matrix counts %d samples, want %d", total, first.Samples)
+	}
+	second, err := eval.run(context.Background(), mdl)
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if second.Loss != first.Loss || second.Accuracy != first.Accuracy {
+		t.Fatalf("passes differ: %+v vs %+v", first, second)
+	}
+
+	capped, err := newEvaluator(roots, 4, 7, dataset.PolicyFail, nil, newPreprocessor(PreprocessConfig{}, 1), tel).run(context.Background(), mdl)
+	if err != nil {
+		t.Fatalf("capped run: %v", err)
+	}
+	if capped.Samples != 7 {
+		t.Fatalf("expected 7 capped samples, got %d", capped.Samples)
+	}
+}
+
+func TestEvaluatorFollowsErrorPolicy(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "val-000000.tar")
+	bad := filepath.Join(dir, "val-000001.tar")
+	writeEvalShard(t, good, 0, 4)
+	writeEvalShard(t, bad, 4, 4)
+	data, err := os.ReadFile(bad)
+	if err != nil {
+		t.Fatalf("read shard: %v", err)
+	}
+	if err := os.WriteFile(bad, data[:700], 0o644); err != nil {
+		t.Fatalf("truncate shard: %v", err)
+	}
+	quarantinePath := filepath.Join(dir, "quarantine.txt")
+	quarantine, err := dataset.LoadQuarantine(quarantinePath)
+	if err != nil {
+		t.Fatalf("LoadQuarantine: %v", err)
+	}
+	tel := newTelemetry(metrics.NewRegistry())
+	roots := map[string][]string{"val": {good, bad}}
+	mdl := model.NewSimpleCNN(numClasses, featureSize, 0.05, 1)
+
+	if _, err := newEvaluator(roots, 4, 0, dataset.PolicyFail, quarantine, newPreprocessor(PreprocessConfig{}, 1), tel).run(context.Background(), mdl); err == nil {
+		t.Fatal("expected the truncated shard to fail the pass under PolicyFail")
+	}
+	result, err := newEvaluator(roots, 4, 0, dataset.PolicySkipShard, quarantine, newPreprocessor(PreprocessConfig{}, 1), tel).run(context.Background(), mdl)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if result.Samples != 4 {
+		t.Fatalf("expected the 4 samples of the good shard, got %d", result.Samples)
+	}
+	// Validation shards stay out of the training quarantine.
+	if quarantine.Len() != 0 {
+		t.Fatalf("expected an empty quarantine, got %d shards", quarantine.Len())
+	}
+	if data, err := os.ReadFile(quarantinePath); err == nil && len(data) > 0 {
+		t.Fatalf("expected an empty quarantine file, got %q", data)
+	}
+}
+
+func writeEvalShard(t *testing.T, path string, first, count int) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create shard: %v", err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for i := first; i < first+count; i++ {
+		key := fmt.Sprintf("sample-%04d", i)
+		image := make([]byte, featureSize)
+		for j := range image {
+			image[j] = byte(i*31 + j)
+		}
+		for name, data := range map[string][]byte{key + ".jpg": image, key + ".cls": []byte(strconv.Itoa(i % 3))} {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(data)), Mode: 0o644}); err != nil {
+				t.Fatalf("write header: %v", err)
+			}
+			if _, err := tw.Write(data); err != nil {
+				t.Fatalf("write data: %v", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+}
+
+func sumInts(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
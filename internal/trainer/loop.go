@@ -3,10 +3,12 @@ package trainer
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"strings"
 	"time"
 
 	"warpdrive-forge/internal/dataset"
+	"warpdrive-forge/internal/logging"
 	"warpdrive-forge/internal/metrics"
 	"warpdrive-forge/internal/model"
 )
@@ -15,20 +17,133 @@ const featureGrid = 16
 const featureSize = featureGrid * featureGrid
 const numClasses = 10
 
+// defaultLearningRate is the base rate when the config sets none, and
+// defaultAdamLearningRate the one for adam and adamw.
+const (
+	defaultLearningRate     = 0.05
+	defaultAdamLearningRate = 0.001
+)
+
+// learningRate returns the base rate of o, or the default of its optimizer
+// when it sets none.
+func learningRate(o model.OptimizerConfig) float64 {
+	if o.LR > 0 {
+		return o.LR
+	}
+	switch strings.ToLower(o.Name) {
+	case "adam", "adamw":
+		return defaultAdamLearningRate
+	}
+	return defaultLearningRate
+}
+
 // RunConfig captures the knobs required by the training loop.
 type RunConfig struct {
-	Roots      map[string][]string
+	Roots   map[string][]string
+	Weights map[string]float64
+	// ShardSamples holds the sample count of training shards, as listed in
+	// the roots' manifests. With a count for every shard the trainer logs
+	// the size of each epoch and the progress through it, and a run bound
+	// only by Epochs knows its total steps.
+	ShardSamples map[string]int64
+	// Steps and Epochs bound the run; when both are set it stops at
+	// whichever comes first, and at least one must be set.
 	Steps      int
+	Epochs     int
 	BatchSize  int
 	NumWorkers int
 	LogEvery   int
 	Seed       int64
+	// ShuffleSamples and ShuffleBytes size the sampler's shuffle buffer.
+	ShuffleSamples int
+	ShuffleBytes   int64
+	// Ordering and ReorderWindow select the sampler's delivery order. A
+	// relaxed run resumes without losing samples but may repeat some.
+	Ordering      dataset.Ordering
+	ReorderWindow int
+	// Replicas, when > 0, trains each batch data-parallel: the batch is
+	// split across Replicas goroutines and their averaged gradients are
+	// applied once. 0 keeps the serial per-sample SGD of TrainStep.
+	Replicas int
+	// Optimizer, when its Name is set, applies the averaged gradients of
+	// each batch (computed by max(Replicas, 1) goroutines). Without it a
+	// data-parallel run uses plain SGD. Optimizer.LR is the base learning
+	// rate either way and defaults to 0.05, or 0.001 for adam and adamw.
+	Optimizer model.OptimizerConfig
+	// Preprocess turns sample bytes into model inputs.
+	Preprocess PreprocessConfig
+	// Layers, when set, trains a Network of these layers followed by a
+	// dense classifier instead of the linear SimpleCNN.
+	Layers []model.LayerSpec
+	// Schedule sets the learning rate of every step. Its BaseLR defaults to
+	// Optimizer.LR and its TotalSteps to the steps of Epochs when
+	// ShardSamples counts every training shard, or else to Steps.
+	Schedule model.ScheduleConfig
+	// Rank, WorldSize and Partition select this process's share of every
+	// epoch's shards.
+	Rank      int
+	WorldSize int
+	Partition dataset.Partition
+	// Resume continues a previous run from a checkpoint; its config hash
+	// must match this run.
+	Resume *Checkpoint
+	// CheckpointDir, when set, receives a checkpoint every CheckpointEvery
+	// steps and when the loop exits (including on cancellation).
+	CheckpointDir   string
+	CheckpointEvery int
+	// KeepCheckpoints is how many of the newest checkpoints are retained.
+	KeepCheckpoints int
+	// Metrics receives the trainer's Prometheus series; nil keeps them
+	// private to the run.
+	Metrics *metrics.Registry
+	// ValidationRoots, when set, are scored every EvalEvery steps and once
+	// more at the end of the run. EvalMaxSamples caps each pass (0 reads
+	// every shard).
+	ValidationRoots map[string][]string
+	EvalEvery       int
+	EvalMaxSamples  int
+	// ErrorPolicy, ErrorBudget, Quarantine and Verify are passed to the
+	// sampler. ErrorPolicy also decides whether a validation shard that
+	// fails ends the run; validation shards are never quarantined.
+	ErrorPolicy dataset.ErrorPolicy
+	ErrorBudget int
+	Quarantine  *dataset.Quarantine
+	Verify      *dataset.Verifier
+}
+
+// trainable is the model trained by Run.
+type trainable interface {
+	model.Differentiable
+	SetLearningRate(lr float64)
+}
+
+// newModel builds the Network described by cfg.Layers, or the linear
+// SimpleCNN when there are none.
+func newModel(cfg RunConfig) (trainable, error) {
+	shape := cfg.Preprocess.shape()
+	if len(cfg.Layers) == 0 {
+		return model.NewSimpleCNN(numClasses, shape.Size(), cfg.Optimizer.LR, cfg.Seed), nil
+	}
+	net, err := model.NewNetwork(cfg.Layers, shape, numClasses, cfg.Optimizer.LR, cfg.Seed)
+	if err != nil {
+		return nil, err
+	}
+	logging.Info("model", logging.String("layers", net.String()), logging.Int("params", paramCount(net)))
+	return net, nil
+}
+
+func paramCount(mdl model.Differentiable) int {
+	n := 0
+	for _, p := range mdl.Params() {
+		n += len(p)
+	}
+	return n
 }
 
 // Run executes the training workload.
 func Run(ctx context.Context, cfg RunConfig) error {
-	if cfg.Steps <= 0 {
-		return errors.New("trainer: steps must be > 0")
+	if cfg.Steps <= 0 && cfg.Epochs <= 0 {
+		return errors.New("trainer: steps or epochs must be > 0")
 	}
 	if cfg.BatchSize <= 0 {
 		return errors.New("trainer: batch size must be > 0")
@@ -37,71 +152,359 @@ func Run(ctx context.Context, cfg RunConfig) error {
 		cfg.LogEvery = 50
 	}
 
-	samplerCh, samplerErr, err := dataset.StartSampler(ctx, dataset.SamplerOptions{
+	opts := dataset.SamplerOptions{
 		Roots:      cfg.Roots,
+		Weights:    cfg.Weights,
 		Seed:       cfg.Seed,
 		NumWorkers: cfg.NumWorkers,
-	})
+		Epochs:     int64(cfg.Epochs),
+
+		ShuffleSamples: cfg.ShuffleSamples,
+		ShuffleBytes:   cfg.ShuffleBytes,
+		Ordering:       cfg.Ordering,
+		ReorderWindow:  cfg.ReorderWindow,
+		Rank:           cfg.Rank,
+		WorldSize:      cfg.WorldSize,
+		Partition:      cfg.Partition,
+
+		ErrorPolicy: cfg.ErrorPolicy,
+		ErrorBudget: cfg.ErrorBudget,
+		Quarantine:  cfg.Quarantine,
+		Verify:      cfg.Verify,
+	}
+	if cfg.Metrics == nil {
+		cfg.Metrics = metrics.NewRegistry()
+	}
+	tel := newTelemetry(cfg.Metrics)
+	opts.Observer = tel
+
+	hash := ConfigHash(cfg)
+	cfg.Optimizer.LR = learningRate(cfg.Optimizer)
+	mdl, err := newModel(cfg)
+	if err != nil {
+		return err
+	}
+	var opt model.Optimizer
+	if cfg.Optimizer.Name == "" && cfg.Replicas > 0 {
+		cfg.Optimizer.Name = "sgd"
+	}
+	if cfg.Optimizer.Name != "" {
+		if opt, err = model.NewOptimizer(cfg.Optimizer); err != nil {
+			return err
+		}
+	}
+	replicas := cfg.Replicas
+	if replicas <= 0 {
+		replicas = 1
+	}
+	if cfg.Schedule.BaseLR == 0 {
+		cfg.Schedule.BaseLR = cfg.Optimizer.LR
+	}
+	plan := newEpochPlan(opts, cfg.ShardSamples)
+	if plan != nil {
+		fields := []logging.Field{logging.Int64("epoch_samples", plan.size(0))}
+		if cfg.Epochs > 0 {
+			total := plan.steps(cfg.Epochs, cfg.BatchSize)
+			if cfg.Steps > 0 {
+				total = min(total, cfg.Steps)
+			}
+			if cfg.Schedule.TotalSteps == 0 {
+				cfg.Schedule.TotalSteps = total
+			}
+			fields = append(fields, logging.Int("total_steps", total))
+		}
+		logging.Info("epoch_plan", fields...)
+	}
+	if cfg.Schedule.TotalSteps == 0 {
+		cfg.Schedule.TotalSteps = cfg.Steps
+	}
+	if cfg.Schedule.TotalSteps == 0 && (cfg.Schedule.Name == "cosine" || cfg.Schedule.Name == "one_cycle") {
+		return fmt.Errorf("trainer: lr schedule %s needs total_steps, steps, or manifests with sample counts for every training shard", cfg.Schedule.Name)
+	}
+	schedule, err := model.NewSchedule(cfg.Schedule)
 	if err != nil {
 		return err
 	}
+	firstStep := 1
+	var state *State
+	if cfg.Resume != nil {
+		if cfg.Resume.ConfigHash != hash {
+			return errors.New("trainer: checkpoint was written with a different dataset or config")
+		}
+		if cfg.Steps > 0 && cfg.Resume.State.Step >= cfg.Steps {
+			logging.Info("resume_complete", logging.Int("step", cfg.Resume.State.Step), logging.Int("steps", cfg.Steps))
+			return nil
+		}
+		if err := restoreModel(mdl, cfg.Resume); err != nil {
+			return err
+		}
+		if opt != nil && cfg.Resume.Optimizer != nil {
+			if err := opt.LoadState(*cfg.Resume.Optimizer); err != nil {
+				return err
+			}
+		}
+		resumed := cfg.Resume.State
+		state = &resumed
+		firstStep = resumed.Step + 1
+		opts.Resume = &resumed.Sampler
+		logging.Info("resume",
+			logging.Int("step", resumed.Step),
+			logging.Int64("epoch", resumed.Sampler.Epoch),
+			logging.String("shard", resumed.Sampler.Shard),
+			logging.Int64("offset", resumed.Sampler.Offset),
+		)
+	}
+
+	var ckpt *checkpointer
+	if cfg.CheckpointDir != "" {
+		if cfg.KeepCheckpoints <= 0 {
+			cfg.KeepCheckpoints = defaultKeepCheckpoints
+		}
+		ckpt = &checkpointer{dir: cfg.CheckpointDir, keep: cfg.KeepCheckpoints, hash: hash}
+	}
+	savedStep := firstStep - 1
+
+	pre := newPreprocessor(cfg.Preprocess, cfg.Seed)
+	var eval *evaluator
+	if len(cfg.ValidationRoots) > 0 {
+		eval = newEvaluator(cfg.ValidationRoots, cfg.BatchSize, cfg.EvalMaxSamples, cfg.ErrorPolicy, cfg.Quarantine, pre.forEval(), tel)
+	}
+	evaluatedStep := 0
+
+	// Stop the sampler and the preprocessing stage when the loop returns.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	samplerCh, samplerErr, err := dataset.StartSampler(ctx, opts)
+	if err != nil {
+		return err
+	}
+	queue := startPrefetch(ctx, samplerCh, samplerErr, cfg.BatchSize, cfg.Preprocess.Prefetch, pre, tel)
+
+	// saveOnExit keeps the progress of a loop that stops early with err and
+	// returns the error to stop with. When the checkpoint cannot be saved
+	// that error no longer wraps err, so an interrupted run that lost its
+	// progress is not mistaken for a clean stop.
+	saveOnExit := func(err error) error {
+		if ckpt == nil || state == nil || state.Step <= savedStep {
+			return err
+		}
+		if serr := ckpt.save(state, mdl, opt); serr != nil {
+			logging.Error("checkpoint_failed", serr, logging.Int("step", state.Step))
+			return fmt.Errorf("%v, and the exit checkpoint failed: %w", err, serr)
+		}
+		logging.Info("checkpoint_saved", logging.Int("step", state.Step))
+		return err
+	}
 
-	mdl := model.NewSimpleCNN(numClasses, featureSize, 0.05, cfg.Seed)
 	var window metrics.Window
 
-	for step := 1; step <= cfg.Steps; step++ {
+	lastStep := firstStep - 1
+	finished := false
+	var lastEpoch int64
+	if state != nil {
+		lastEpoch = state.Sampler.Epoch
+	}
+	for step := firstStep; cfg.Steps <= 0 || step <= cfg.Steps; step++ {
+		tel.queueDepth.Set(float64(len(samplerCh)))
+		tel.prefetched.Set(float64(len(queue)))
+		stalled := len(queue) == 0
 		startData := time.Now()
-		batch, err := nextBatch(ctx, samplerCh, samplerErr, cfg.BatchSize)
-		if err != nil {
-			return err
+		next, ok := <-queue
+		if !ok {
+			return saveOnExit(ctx.Err())
+		}
+		finished = errors.Is(next.err, errSamplerDone)
+		if next.err != nil && !finished {
+			return saveOnExit(next.err)
+		}
+		for _, epoch := range next.epochStarts {
+			if epoch > 0 {
+				logEpochDone(epoch-1, step, tel)
+			}
+		}
+		if len(next.batch.Inputs) == 0 {
+			break
 		}
 		dataTime := time.Since(startData)
+		batch, cursor := next.batch, next.cursor
+		lastEpoch = next.epoch
+		images := len(batch.Inputs)
+
+		lr := schedule.LR(step)
+		if opt != nil {
+			opt.SetLearningRate(lr)
+		} else {
+			mdl.SetLearningRate(lr)
+		}
+		tel.learningRate.Set(lr)
 
 		startCompute := time.Now()
-		loss := mdl.TrainStep(batch)
+		var loss float64
+		if opt != nil {
+			loss = dataParallel{replicas: replicas}.step(mdl, opt, batch)
+		} else {
+			loss = mdl.TrainStep(batch)
+		}
 		computeTime := time.Since(startCompute)
 
-		window.Record(cfg.BatchSize, dataTime, computeTime, loss)
+		state = &State{Step: step, Sampler: cursor}
+		lastStep = step
+		window.Record(images, dataTime, computeTime, loss)
+		window.RecordPipeline(next.ioWait, next.prep, stalled)
+		tel.recordStep(images, dataTime, computeTime, loss)
+		tel.recordPipeline(next.ioWait, next.prep, stalled)
 
 		if step%cfg.LogEvery == 0 {
 			snap := window.Snapshot()
-			log.Printf("step=%d images_per_sec=%.1f data_ms=%.2f compute_ms=%.2f loss=%.4f",
-				step,
-				snap.ImagesPerSec,
-				snap.AvgDataMS,
-				snap.AvgComputeMS,
-				snap.LastLoss,
-			)
+			fields := []logging.Field{
+				logging.Int("step", step),
+				logging.Float("images_per_sec", snap.ImagesPerSec, 1),
+				logging.Float("data_ms", snap.AvgDataMS, 2),
+				logging.Float("io_ms", snap.AvgIOMS, 2),
+				logging.Float("prep_ms", snap.AvgPrepMS, 2),
+				logging.Int("stalls", snap.Stalls),
+				logging.Float("compute_ms", snap.AvgComputeMS, 2),
+				logging.Float("loss", snap.LastLoss, 4),
+				logging.Float("lr", lr, 6),
+			}
+			if plan != nil {
+				if size := plan.size(cursor.Epoch); size > 0 {
+					fields = append(fields, logging.Float("epoch_pct", 100*float64(plan.done(cursor))/float64(size), 1))
+				}
+			}
+			fields = append(fields, latencyFields("data", snap.Data)...)
+			fields = append(fields, latencyFields("compute", snap.Compute)...)
+			logging.Info("step", fields...)
+			tel.logIOWindow(step)
+		}
+		if eval != nil && cfg.EvalEvery > 0 && step%cfg.EvalEvery == 0 {
+			if err := eval.evaluate(ctx, step, mdl); err != nil {
+				return saveOnExit(err)
+			}
+			evaluatedStep = step
+		}
+		if cfg.CheckpointEvery > 0 && step%cfg.CheckpointEvery == 0 {
+			if err := ckpt.save(state, mdl, opt); err != nil {
+				return err
+			}
+			savedStep = step
+		}
+		if finished {
+			break
+		}
+	}
+	if finished && state != nil {
+		logEpochDone(lastEpoch, lastStep, tel)
+	}
+
+	final := window.Snapshot()
+	fields := []logging.Field{logging.Int("steps", lastStep-firstStep+1)}
+	fields = append(fields, latencyFields("data", final.RunData)...)
+	fields = append(fields, latencyFields("compute", final.RunCompute)...)
+	logging.Info("run_summary", fields...)
+	tel.logIOReport()
+	if eval != nil && evaluatedStep != lastStep {
+		if err := eval.evaluate(ctx, lastStep, mdl); err != nil {
+			return saveOnExit(err)
 		}
 	}
 
+	if state != nil && state.Step > savedStep {
+		return ckpt.save(state, mdl, opt)
+	}
 	return nil
 }
 
-func nextBatch(ctx context.Context, samples <-chan dataset.Sample, errs <-chan error, batchSize int) (model.Batch, error) {
-	inputs := make([][]float64, 0, batchSize)
-	labels := make([]int, 0, batchSize)
-	for len(inputs) < batchSize {
+func latencyFields(prefix string, s metrics.LatencySummary) []logging.Field {
+	return []logging.Field{
+		logging.Float(prefix+"_p50_ms", s.P50MS, 2),
+		logging.Float(prefix+"_p90_ms", s.P90MS, 2),
+		logging.Float(prefix+"_p99_ms", s.P99MS, 2),
+		logging.Float(prefix+"_max_ms", s.MaxMS, 2),
+	}
+}
+
+// errSamplerDone is returned by nextBatch once a finite sampler has
+// delivered its last sample.
+var errSamplerDone = errors.New("sampler finished")
+
+// batchResult is one assembled batch.
+type batchResult struct {
+	batch model.Batch
+	// cursor is the sampler state after the batch's last sample.
+	cursor dataset.SamplerState
+	// epoch is the epoch of the batch's last sample, and epochStarts lists
+	// the epochs whose first sample is in the batch.
+	epoch       int64
+	epochStarts []int64
+	// ioWait is the time spent waiting on the sampler for the batch's
+	// samples and prep the time spent preprocessing them.
+	ioWait time.Duration
+	prep   time.Duration
+}
+
+// nextBatch assembles one batch, preprocessing its samples with pre. When
+// the sampler finishes it returns the partial batch gathered so far
+// together with errSamplerDone.
+func nextBatch(ctx context.Context, samples <-chan dataset.Sample, errs <-chan error, batchSize int, pre *preprocessor, tel *telemetry) (res batchResult, err error) {
+	start := time.Now()
+	defer func() { res.ioWait = time.Since(start) - res.prep }()
+	res.batch.Inputs = make([][]float64, 0, batchSize)
+	res.batch.Labels = make([]int, 0, batchSize)
+	pending := make([]dataset.Sample, 0, batchSize)
+	// flush preprocesses the pending samples; those that fail to decode are
+	// replaced by later samples.
+	flush := func() {
+		startPrep := time.Now()
+		defer func() { res.prep += time.Since(startPrep) }()
+		decoded, failed := pre.batch(pending)
+		res.batch.Inputs = append(res.batch.Inputs, decoded.Inputs...)
+		res.batch.Labels = append(res.batch.Labels, decoded.Labels...)
+		tel.errors.With("decode").Add(float64(failed))
+		pending = pending[:0]
+	}
+	for len(res.batch.Inputs) < batchSize {
+		if len(res.batch.Inputs)+len(pending) == batchSize {
+			flush()
+			continue
+		}
 		select {
 		case <-ctx.Done():
-			return model.Batch{}, ctx.Err()
+			return res, ctx.Err()
 		case err, ok := <-errs:
-			if ok && err != nil {
-				return model.Batch{}, err
+			if !ok {
+				errs = nil
+				continue
+			}
+			if err != nil {
+				return res, err
 			}
 		case sample, ok := <-samples:
 			if !ok {
-				return model.Batch{}, errors.New("sampler closed")
+				// The sampler closes its error channel before the sample
+				// channel, so any final error is already buffered.
+				if errs != nil {
+					if err := <-errs; err != nil {
+						return res, err
+					}
+				}
+				flush()
+				return res, errSamplerDone
 			}
-			features, err := extractFeatures(sample.Image)
-			if err != nil {
-				continue
+			res.cursor = sample.State
+			res.epoch = sample.Epoch
+			if sample.EpochStart {
+				res.epochStarts = append(res.epochStarts, sample.Epoch)
 			}
-			inputs = append(inputs, features)
-			labels = append(labels, clampLabel(sample.Label))
+			pending = append(pending, sample)
 		}
 	}
-	return model.Batch{Inputs: inputs, Labels: labels}, nil
+	return res, nil
+}
+
+func logEpochDone(epoch int64, step int, tel *telemetry) {
+	tel.epochs.Inc()
+	logging.Info("epoch_done", logging.Int64("epoch", epoch), logging.Int("step", step))
 }
 
 func extractFeatures(raw []byte) ([]float64, error) {
@@ -6,6 +6,8 @@ import (
 	"image/color"
 	"image/png"
 	"testing"
+
+	"warpdrive-forge/internal/model"
 )
 
 func TestExtractFeatures(t *testing.T) {
@@ -32,3 +34,20 @@ func TestExtractFeatures(t *testing.T) {
 		}
 	}
 }
+
+func TestLearningRateDefaultsByOptimizer(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  model.OptimizerConfig
+		want float64
+	}{
+		{model.OptimizerConfig{}, 0.05},
+		{model.OptimizerConfig{Name: "sgd"}, 0.05},
+		{model.OptimizerConfig{Name: "adam"}, 0.001},
+		{model.OptimizerConfig{Name: "AdamW"}, 0.001},
+		{model.OptimizerConfig{Name: "adam", LR: 0.02}, 0.02},
+	} {
+		if got := learningRate(tc.cfg); got != tc.want {
+			t.Fatalf("learningRate(%+v) = %g, want %g", tc.cfg, got, tc.want)
+		}
+	}
+}
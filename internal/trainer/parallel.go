@@ -0,0 +1,49 @@
+package trainer
+
+import (
+	"sync"
+
+	"warpdrive-forge/internal/model"
+)
+
+// dataParallel trains on a batch by splitting it across replicas
+// goroutines. Each replica computes the gradients of its slice against the
+// same weights; the sums are all-reduced in process, averaged over the
+// batch and handed to the optimizer as a single update. The result does
+// not depend on the number of replicas beyond floating-point summation
+// order.
+type dataParallel struct {
+	replicas int
+}
+
+// step trains mdl on batch with opt and returns the mean loss over the
+// batch.
+func (p dataParallel) step(mdl model.Differentiable, opt model.Optimizer, batch model.Batch) float64 {
+	n := len(batch.Inputs)
+	if n == 0 {
+		return 0
+	}
+	replicas := p.replicas
+	if replicas > n {
+		replicas = n
+	}
+	parts := make([]model.Gradients, replicas)
+	var wg sync.WaitGroup
+	for r := 0; r < replicas; r++ {
+		lo, hi := r*n/replicas, (r+1)*n/replicas
+		wg.Add(1)
+		go func(r int, slice model.Batch) {
+			defer wg.Done()
+			parts[r] = mdl.ComputeGradients(slice)
+		}(r, model.Batch{Inputs: batch.Inputs[lo:hi], Labels: batch.Labels[lo:hi]})
+	}
+	wg.Wait()
+
+	// Reduce in replica order so a run is reproducible.
+	var total model.Gradients
+	for _, part := range parts {
+		total.Add(part)
+	}
+	opt.Step(mdl.Params(), total.Mean().Params)
+	return total.Loss / float64(n)
+}
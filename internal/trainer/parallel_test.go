@@ -0,0 +1,43 @@
+package trainer
+
+import (
+	"math"
+	"testing"
+
+	"warpdrive-forge/internal/model"
+)
+
+func TestDataParallelMatchesSingleReplica(t *testing.T) {
+	batch := model.Batch{}
+	for i := 0; i < 10; i++ {
+		input := make([]float64, featureSize)
+		for j := range input {
+			input[j] = float64((i*7+j)%13) / 13
+		}
+		batch.Inputs = append(batch.Inputs, input)
+		batch.Labels = append(batch.Labels, i%numClasses)
+	}
+
+	single := model.NewSimpleCNN(numClasses, featureSize, 0.05, 1)
+	parallel := model.NewSimpleCNN(numClasses, featureSize, 0.05, 1)
+	singleOpt, _ := model.NewOptimizer(model.OptimizerConfig{Name: "adam", LR: 0.01})
+	parallelOpt, _ := model.NewOptimizer(model.OptimizerConfig{Name: "adam", LR: 0.01})
+	for step := 0; step < 3; step++ {
+		a := dataParallel{replicas: 1}.step(single, singleOpt, batch)
+		b := dataParallel{replicas: 4}.step(parallel, parallelOpt, batch)
+		if math.Abs(a-b) > 1e-9 {
+			t.Fatalf("step %d: loss %f with 1 replica, %f with 4", step, a, b)
+		}
+	}
+	a, b := single.State(), parallel.State()
+	for i := range a.Weights {
+		if math.Abs(a.Weights[i]-b.Weights[i]) > 1e-9 {
+			t.Fatalf("weight %d diverged: %f vs %f", i, a.Weights[i], b.Weights[i])
+		}
+	}
+
+	// More replicas than samples leaves the extra replicas idle.
+	if loss := (dataParallel{replicas: 32}).step(parallel, parallelOpt, model.Batch{Inputs: batch.Inputs[:2], Labels: batch.Labels[:2]}); loss <= 0 {
+		t.Fatalf("expected a positive loss, got %f", loss)
+	}
+}
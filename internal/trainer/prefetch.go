@@ -0,0 +1,45 @@
+package trainer
+
+import (
+	"context"
+
+	"warpdrive-forge/internal/dataset"
+)
+
+// defaultPrefetch is the number of ready batches queued when the config
+// sets none.
+const defaultPrefetch = 2
+
+// prefetched is one batch built by the preprocessing stage, or the error
+// that ended the stage.
+type prefetched struct {
+	batchResult
+	err error
+}
+
+// startPrefetch assembles and preprocesses batches on its own goroutine,
+// so the batches after the current step are built while it computes. Up
+// to depth ready batches wait in the returned channel. The channel is
+// closed after the first batch carrying an error, errSamplerDone included,
+// or when ctx is cancelled.
+func startPrefetch(ctx context.Context, samples <-chan dataset.Sample, errs <-chan error, batchSize, depth int, pre *preprocessor, tel *telemetry) <-chan prefetched {
+	if depth <= 0 {
+		depth = defaultPrefetch
+	}
+	out := make(chan prefetched, depth)
+	go func() {
+		defer close(out)
+		for {
+			res, err := nextBatch(ctx, samples, errs, batchSize, pre, tel)
+			select {
+			case out <- prefetched{batchResult: res, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
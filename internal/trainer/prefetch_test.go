@@ -0,0 +1,52 @@
+package trainer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"warpdrive-forge/internal/dataset"
+	"warpdrive-forge/internal/metrics"
+)
+
+func TestPrefetchDeliversBatchesInOrder(t *testing.T) {
+	samples := make(chan dataset.Sample, 10)
+	errs := make(chan error)
+	for i := 0; i < 10; i++ {
+		samples <- dataset.Sample{Image: []byte{byte(i + 1)}, Label: i}
+	}
+	close(errs)
+	close(samples)
+
+	pre := newPreprocessor(PreprocessConfig{Workers: 2}, 1)
+	queue := startPrefetch(context.Background(), samples, errs, 4, 1, pre, newTelemetry(metrics.NewRegistry()))
+	var labels []int
+	var last error
+	for next := range queue {
+		labels = append(labels, next.batch.Labels...)
+		last = next.err
+	}
+	if !errors.Is(last, errSamplerDone) {
+		t.Fatalf("expected the queue to end with errSamplerDone, got %v", last)
+	}
+	if len(labels) != 10 {
+		t.Fatalf("got %d samples, want 10", len(labels))
+	}
+	for i, label := range labels {
+		if label != i {
+			t.Fatalf("sample %d has label %d", i, label)
+		}
+	}
+}
+
+func TestPrefetchStopsOnCancel(t *testing.T) {
+	samples := make(chan dataset.Sample)
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := startPrefetch(ctx, samples, nil, 4, 2, newPreprocessor(PreprocessConfig{}, 1), newTelemetry(metrics.NewRegistry()))
+	cancel()
+	for next := range queue {
+		if !errors.Is(next.err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", next.err)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package trainer
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"image"
+	_ "image/jpeg" // register the decoders used by PreprocessDecode
+	_ "image/png"
+	"math"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+
+	"warpdrive-forge/internal/dataset"
+	"warpdrive-forge/internal/model"
+)
+
+// PreprocessMode selects how sample bytes become model inputs.
+type PreprocessMode int
+
+const (
+	// PreprocessRaw samples the compressed bytes at a stride into a
+	// single-channel grid. It costs almost nothing, so a run measures I/O.
+	PreprocessRaw PreprocessMode = iota
+	// PreprocessDecode decodes JPEG or PNG pixels, resizes them to an RGB
+	// grid and normalizes each channel, with optional augmentation.
+	PreprocessDecode
+)
+
+// ParsePreprocessMode parses "raw" or "decode"; the empty string is raw.
+func ParsePreprocessMode(s string) (PreprocessMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "raw":
+		return PreprocessRaw, nil
+	case "decode":
+		return PreprocessDecode, nil
+	}
+	return PreprocessRaw, fmt.Errorf("trainer: unknown preprocess mode %q (want raw or decode)", s)
+}
+
+func (m PreprocessMode) String() string {
+	if m == PreprocessDecode {
+		return "decode"
+	}
+	return "raw"
+}
+
+// PreprocessConfig controls how training and validation samples are turned
+// into model inputs. The augmentations apply to decoded training samples
+// only.
+type PreprocessConfig struct {
+	Mode PreprocessMode
+	// Workers preprocess each batch in parallel; 0 uses one per CPU.
+	Workers int
+	// Prefetch is the number of ready batches queued ahead of the training
+	// loop (default 2).
+	Prefetch int
+	// Mean and Std normalize each RGB channel after scaling it to [0, 1].
+	// An all-zero Mean or Std takes the ImageNet statistics.
+	Mean [3]float64
+	Std  [3]float64
+	// CropScale, when in (0, 1), crops a random window whose width and
+	// height are each between CropScale and all of the image's before
+	// resizing.
+	CropScale float64
+	// Flip mirrors half of the images horizontally.
+	Flip bool
+	// Brightness scales every pixel by a random factor in
+	// [1-Brightness, 1+Brightness].
+	Brightness float64
+}
+
+var (
+	imageNetMean = [3]float64{0.485, 0.456, 0.406}
+	imageNetStd  = [3]float64{0.229, 0.224, 0.225}
+)
+
+// shape is the layout of the inputs produced by cfg.
+func (cfg PreprocessConfig) shape() model.Shape {
+	if cfg.Mode == PreprocessDecode {
+		return model.Shape{C: 3, H: featureGrid, W: featureGrid}
+	}
+	return model.Shape{C: 1, H: featureGrid, W: featureGrid}
+}
+
+// preprocessor turns samples into model inputs. Augmentation draws from a
+// generator seeded by the run seed and the sample's shard, key and epoch,
+// so the result does not depend on which worker handles a sample.
+type preprocessor struct {
+	cfg     PreprocessConfig
+	seed    int64
+	augment bool
+}
+
+func newPreprocessor(cfg PreprocessConfig, seed int64) *preprocessor {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.GOMAXPROCS(0)
+	}
+	if cfg.Mean == ([3]float64{}) {
+		cfg.Mean = imageNetMean
+	}
+	if cfg.Std == ([3]float64{}) {
+		cfg.Std = imageNetStd
+	}
+	augment := cfg.Mode == PreprocessDecode &&
+		((cfg.CropScale > 0 && cfg.CropScale < 1) || cfg.Flip || cfg.Brightness > 0)
+	return &preprocessor{cfg: cfg, seed: seed, augment: augment}
+}
+
+// forEval returns a copy of p that never augments.
+func (p *preprocessor) forEval() *preprocessor {
+	eval := *p
+	eval.augment = false
+	return &eval
+}
+
+// features returns the model input for one sample.
+func (p *preprocessor) features(sample dataset.Sample) ([]float64, error) {
+	if p.cfg.Mode == PreprocessRaw {
+		return extractFeatures(sample.Image)
+	}
+	var rng *rand.Rand
+	if p.augment {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d/%d/%s/%s", p.seed, sample.Epoch, sample.Shard, sample.Key)
+		rng = rand.New(rand.NewSource(int64(h.Sum64())))
+	}
+	return p.decode(sample.Image, rng)
+}
+
+// batch preprocesses samples on the configured workers and returns the
+// inputs and labels of those that succeeded, in order, and the number that
+// failed.
+func (p *preprocessor) batch(samples []dataset.Sample) (model.Batch, int) {
+	inputs := make([][]float64, len(samples))
+	workers := p.cfg.Workers
+	if workers > len(samples) {
+		workers = len(samples)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(samples); i += workers {
+				if features, err := p.features(samples[i]); err == nil {
+					inputs[i] = features
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	batch := model.Batch{Inputs: make([][]float64, 0, len(samples)), Labels: make([]int, 0, len(samples))}
+	for i, features := range inputs {
+		if features == nil {
+			continue
+		}
+		batch.Inputs = append(batch.Inputs, features)
+		batch.Labels = append(batch.Labels, clampLabel(samples[i].Label))
+	}
+	return batch, len(samples) - len(batch.Inputs)
+}
+
+// decode resizes the image in raw to the feature grid with bilinear
+// sampling, applying the augmentations drawn from rng when it is set.
+func (p *preprocessor) decode(raw []byte, rng *rand.Rand) ([]float64, error) {
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil, fmt.Errorf("decode image: empty image")
+	}
+	x0, y0 := float64(bounds.Min.X), float64(bounds.Min.Y)
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	flip, gain := false, 1.0
+	if rng != nil {
+		if s := p.cfg.CropScale; s > 0 && s < 1 {
+			cw, ch := w*(s+rng.Float64()*(1-s)), h*(s+rng.Float64()*(1-s))
+			x0 += rng.Float64() * (w - cw)
+			y0 += rng.Float64() * (h - ch)
+			w, h = cw, ch
+		}
+		flip = p.cfg.Flip && rng.Intn(2) == 1
+		if p.cfg.Brightness > 0 {
+			gain = 1 + (rng.Float64()*2-1)*p.cfg.Brightness
+		}
+	}
+
+	features := make([]float64, 3*featureGrid*featureGrid)
+	for gy := 0; gy < featureGrid; gy++ {
+		sy := y0 + (float64(gy)+0.5)*h/featureGrid - 0.5
+		for gx := 0; gx < featureGrid; gx++ {
+			col := gx
+			if flip {
+				col = featureGrid - 1 - gx
+			}
+			sx := x0 + (float64(col)+0.5)*w/featureGrid - 0.5
+			rgb := bilinear(img, sx, sy)
+			for c, v := range rgb {
+				v = math.Min(v*gain, 1)
+				features[(c*featureGrid+gy)*featureGrid+gx] = (v - p.cfg.Mean[c]) / p.cfg.Std[c]
+			}
+		}
+	}
+	return features, nil
+}
+
+// bilinear returns the RGB value of img at (x, y), in [0, 1], interpolated
+// between the four nearest pixels. Points outside the image take the
+// nearest edge pixel.
+func bilinear(img image.Image, x, y float64) [3]float64 {
+	b := img.Bounds()
+	x = math.Max(float64(b.Min.X), math.Min(x, float64(b.Max.X-1)))
+	y = math.Max(float64(b.Min.Y), math.Min(y, float64(b.Max.Y-1)))
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	x1, y1 := min(x0+1, b.Max.X-1), min(y0+1, b.Max.Y-1)
+	fx, fy := x-float64(x0), y-float64(y0)
+	taps := [4]struct {
+		x, y   int
+		weight float64
+	}{
+		{x0, y0, (1 - fx) * (1 - fy)},
+		{x1, y0, fx * (1 - fy)},
+		{x0, y1, (1 - fx) * fy},
+		{x1, y1, fx * fy},
+	}
+	var rgb [3]float64
+	for _, tap := range taps {
+		r, g, bl, _ := img.At(tap.x, tap.y).RGBA()
+		rgb[0] += tap.weight * float64(r) / 0xffff
+		rgb[1] += tap.weight * float64(g) / 0xffff
+		rgb[2] += tap.weight * float64(bl) / 0xffff
+	}
+	return rgb
+}
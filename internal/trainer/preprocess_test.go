@@ -0,0 +1,145 @@
+package trainer
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+
+	"warpdrive-forge/internal/dataset"
+)
+
+// halfImage is red on the left half and blue on the right.
+func halfImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 20 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestPreprocessDecodeResizesAndNormalizes(t *testing.T) {
+	pre := newPreprocessor(PreprocessConfig{Mode: PreprocessDecode}, 1)
+	features, err := pre.features(dataset.Sample{Image: encodePNG(t, halfImage())})
+	if err != nil {
+		t.Fatalf("features: %v", err)
+	}
+	if want := pre.cfg.shape().Size(); len(features) != want {
+		t.Fatalf("got %d features, want %d", len(features), want)
+	}
+	at := func(c, y, x int) float64 { return features[(c*featureGrid+y)*featureGrid+x] }
+	red := (1 - imageNetMean[0]) / imageNetStd[0]
+	blue := (0 - imageNetMean[0]) / imageNetStd[0]
+	if math.Abs(at(0, 3, 0)-red) > 1e-9 || math.Abs(at(0, 3, featureGrid-1)-blue) > 1e-9 {
+		t.Fatalf("unexpected red channel: left %f right %f", at(0, 3, 0), at(0, 3, featureGrid-1))
+	}
+
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, halfImage(), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	if _, err := pre.features(dataset.Sample{Image: buf.Bytes()}); err != nil {
+		t.Fatalf("jpeg features: %v", err)
+	}
+	if _, err := pre.features(dataset.Sample{Image: []byte("not an image")}); err == nil {
+		t.Fatal("expected a decode error")
+	}
+}
+
+func TestPreprocessAugmentationIsSeededPerSample(t *testing.T) {
+	cfg := PreprocessConfig{Mode: PreprocessDecode, CropScale: 0.6, Flip: true, Brightness: 0.3, Workers: 3}
+	pre := newPreprocessor(cfg, 7)
+	plain := newPreprocessor(PreprocessConfig{Mode: PreprocessDecode}, 7)
+	raw := encodePNG(t, halfImage())
+
+	var samples []dataset.Sample
+	for i := 0; i < 12; i++ {
+		samples = append(samples, dataset.Sample{Key: fmt.Sprintf("%04d", i), Shard: "s.tar", Image: raw, Label: i})
+	}
+	first, failed := pre.batch(samples)
+	cfg.Workers = 1
+	second, _ := newPreprocessor(cfg, 7).batch(samples)
+	if failed != 0 || len(first.Inputs) != len(samples) {
+		t.Fatalf("got %d inputs and %d failures", len(first.Inputs), failed)
+	}
+	unaugmented, _ := plain.features(samples[0])
+	flipped, differs := 0, 0
+	for i := range first.Inputs {
+		for j := range first.Inputs[i] {
+			if first.Inputs[i][j] != second.Inputs[i][j] {
+				t.Fatalf("sample %d differs between worker counts", i)
+			}
+		}
+		if first.Labels[i] != i%numClasses {
+			t.Fatalf("label %d out of order: %d", i, first.Labels[i])
+		}
+		// The red half is on the right after a flip.
+		if first.Inputs[i][0] < first.Inputs[i][featureGrid-1] {
+			flipped++
+		}
+		if first.Inputs[i][5] != unaugmented[5] {
+			differs++
+		}
+	}
+	if flipped == 0 || flipped == len(samples) {
+		t.Fatalf("expected some but not all samples flipped, got %d of %d", flipped, len(samples))
+	}
+	if differs == 0 {
+		t.Fatal("expected augmentation to change the inputs")
+	}
+	evalFeatures, _ := pre.forEval().features(samples[0])
+	for j := range evalFeatures {
+		if evalFeatures[j] != unaugmented[j] {
+			t.Fatal("expected evaluation inputs to be unaugmented")
+		}
+	}
+}
+
+func TestPreprocessBatchSkipsUndecodable(t *testing.T) {
+	pre := newPreprocessor(PreprocessConfig{Mode: PreprocessDecode, Workers: 2}, 1)
+	raw := encodePNG(t, halfImage())
+	batch, failed := pre.batch([]dataset.Sample{
+		{Image: raw, Label: 1},
+		{Image: []byte{1, 2, 3}, Label: 2},
+		{Image: raw, Label: 3},
+	})
+	if failed != 1 || len(batch.Inputs) != 2 || batch.Labels[0] != 1 || batch.Labels[1] != 3 {
+		t.Fatalf("unexpected batch: %d inputs, labels %v, %d failed", len(batch.Inputs), batch.Labels, failed)
+	}
+
+	rawMode := newPreprocessor(PreprocessConfig{}, 1)
+	if batch, failed := rawMode.batch([]dataset.Sample{{Image: []byte{1, 2, 3}}}); failed != 0 || len(batch.Inputs[0]) != featureSize {
+		t.Fatalf("raw mode should sample any bytes, got %d failures", failed)
+	}
+}
+
+func TestParsePreprocessMode(t *testing.T) {
+	for in, want := range map[string]PreprocessMode{"": PreprocessRaw, "raw": PreprocessRaw, "Decode": PreprocessDecode} {
+		got, err := ParsePreprocessMode(in)
+		if err != nil || got != want {
+			t.Fatalf("ParsePreprocessMode(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := ParsePreprocessMode("gpu"); err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,72 @@
+package trainer
+
+import "warpdrive-forge/internal/dataset"
+
+// epochPlan sizes epochs from per-shard sample counts, such as those listed
+// in the roots' manifests.
+type epochPlan struct {
+	opts   dataset.SamplerOptions
+	counts map[string]int64
+	// epoch and prefix cache the order of the epoch last asked about:
+	// prefix[i] is the number of samples in its first i shards.
+	epoch  int64
+	prefix []int64
+}
+
+// newEpochPlan returns nil unless every training shard has a count.
+// Quarantined shards are skipped by the sampler and count as empty.
+func newEpochPlan(opts dataset.SamplerOptions, counts map[string]int64) *epochPlan {
+	if len(counts) == 0 {
+		return nil
+	}
+	for _, shards := range opts.Roots {
+		for _, shard := range shards {
+			if _, ok := counts[shard]; !ok && !opts.Quarantine.Contains(shard) {
+				return nil
+			}
+		}
+	}
+	return &epochPlan{opts: opts, counts: counts, epoch: -1}
+}
+
+func (p *epochPlan) load(epoch int64) {
+	if epoch == p.epoch {
+		return
+	}
+	order := dataset.EpochOrder(p.opts, epoch)
+	p.prefix = make([]int64, len(order)+1)
+	for i, shard := range order {
+		n := p.counts[shard]
+		if p.opts.Quarantine.Contains(shard) {
+			n = 0
+		}
+		p.prefix[i+1] = p.prefix[i] + n
+	}
+	p.epoch = epoch
+}
+
+// size returns the number of samples this rank reads in epoch.
+func (p *epochPlan) size(epoch int64) int64 {
+	p.load(epoch)
+	return p.prefix[len(p.prefix)-1]
+}
+
+// done returns the number of samples of cursor's epoch delivered up to the
+// cursor. With a shuffle buffer the cursor trails by up to one block.
+func (p *epochPlan) done(cursor dataset.SamplerState) int64 {
+	p.load(cursor.Epoch)
+	if cursor.Index < 0 || cursor.Index >= len(p.prefix) {
+		return 0
+	}
+	return p.prefix[cursor.Index] + cursor.Offset
+}
+
+// steps returns the number of batches of batchSize in the first epochs
+// epochs; batches run across epoch boundaries, so only the last is short.
+func (p *epochPlan) steps(epochs, batchSize int) int {
+	var total int64
+	for epoch := 0; epoch < epochs; epoch++ {
+		total += p.size(int64(epoch))
+	}
+	return int((total + int64(batchSize) - 1) / int64(batchSize))
+}
@@ -0,0 +1,41 @@
+package trainer
+
+import (
+	"testing"
+
+	"warpdrive-forge/internal/dataset"
+)
+
+func TestEpochPlan(t *testing.T) {
+	opts := dataset.SamplerOptions{
+		Roots: map[string][]string{"a": {"a0", "a1"}, "b": {"b0"}},
+		Seed:  3,
+	}
+	counts := map[string]int64{"a0": 10, "a1": 20, "b0": 5}
+	if newEpochPlan(opts, map[string]int64{"a0": 10, "a1": 20}) != nil {
+		t.Fatal("expected no plan when a shard has no count")
+	}
+	plan := newEpochPlan(opts, counts)
+	if plan == nil || plan.size(0) != 35 || plan.size(4) != 35 {
+		t.Fatalf("expected epochs of 35 samples")
+	}
+	if got := plan.steps(2, 16); got != 5 {
+		t.Fatalf("steps(2, 16) = %d, want 5", got)
+	}
+
+	order := dataset.EpochOrder(opts, 1)
+	cursor := dataset.SamplerState{Epoch: 1, Index: 2, Shard: order[2], Offset: 3}
+	if want := counts[order[0]] + counts[order[1]] + 3; plan.done(cursor) != want {
+		t.Fatalf("done = %d, want %d", plan.done(cursor), want)
+	}
+
+	// Padding gives rank 1 of 2 a repeat of the epoch's first shard.
+	opts.WorldSize, opts.Rank = 2, 1
+	var want int64
+	for _, shard := range dataset.EpochOrder(opts, 0) {
+		want += counts[shard]
+	}
+	if got := newEpochPlan(opts, counts).size(0); got != want || len(dataset.EpochOrder(opts, 0)) != 2 {
+		t.Fatalf("rank 1 of 2 reads %d samples, want %d", got, want)
+	}
+}
@@ -0,0 +1,10 @@
+package trainer
+
+import "warpdrive-forge/internal/dataset"
+
+// State is the resumable progress of a training run: the last completed
+// step and the sampler cursor right after that step's batch.
+type State struct {
+	Step    int                  `json:"step"`
+	Sampler dataset.SamplerState `json:"sampler"`
+}
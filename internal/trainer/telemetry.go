@@ -0,0 +1,193 @@
+package trainer
+
+import (
+	"sort"
+	"time"
+
+	"warpdrive-forge/internal/dataset"
+	"warpdrive-forge/internal/logging"
+	"warpdrive-forge/internal/metrics"
+)
+
+// telemetry holds the Prometheus series exported by Run. It also
+// implements dataset.Observer so the sampler can report shard events.
+type telemetry struct {
+	steps        *metrics.Counter
+	images       *metrics.Counter
+	imagesPerSec *metrics.Gauge
+	loss         *metrics.Gauge
+	learningRate *metrics.Gauge
+	queueDepth   *metrics.Gauge
+	prefetched   *metrics.Gauge
+	dataWait     *metrics.Histogram
+	compute      *metrics.Histogram
+	ioWait       *metrics.Histogram
+	prep         *metrics.Histogram
+	stalls       *metrics.Counter
+	samples      *metrics.CounterVec
+	shardOpen    *metrics.HistogramVec
+	errors       *metrics.CounterVec
+	checksums    *metrics.CounterVec
+	readBytes    *metrics.CounterVec
+	readSeconds  *metrics.CounterVec
+	compressed   *metrics.CounterVec
+	inflated     *metrics.CounterVec
+	decompress   *metrics.CounterVec
+	shardTTFB    *metrics.HistogramVec
+	epochs       *metrics.Counter
+	evalLoss     *metrics.Gauge
+	evalAccuracy *metrics.Gauge
+	io           metrics.IOStats
+}
+
+func newTelemetry(reg *metrics.Registry) *telemetry {
+	t := &telemetry{
+		steps:        reg.Counter("forge_steps_total", "Completed training steps.").With(),
+		images:       reg.Counter("forge_images_total", "Images consumed by training steps.").With(),
+		imagesPerSec: reg.Gauge("forge_images_per_second", "Throughput of the most recent step.").With(),
+		loss:         reg.Gauge("forge_loss", "Training loss of the most recent step.").With(),
+		learningRate: reg.Gauge("forge_learning_rate", "Learning rate of the most recent step.").With(),
+		queueDepth:   reg.Gauge("forge_sampler_queue_depth", "Samples buffered between the sampler and the preprocessing stage.").With(),
+		prefetched:   reg.Gauge("forge_prefetch_queue_depth", "Ready batches queued ahead of the training loop.").With(),
+		dataWait:     reg.Histogram("forge_step_data_wait_seconds", "Time per step spent waiting for a batch.", nil).With(),
+		compute:      reg.Histogram("forge_step_compute_seconds", "Time per step spent in the model update.", nil).With(),
+		ioWait:       reg.Histogram("forge_batch_io_wait_seconds", "Time per batch the preprocessing stage waited on the sampler.", nil).With(),
+		prep:         reg.Histogram("forge_batch_preprocess_seconds", "Time per batch spent preprocessing samples.", nil).With(),
+		stalls:       reg.Counter("forge_prefetch_stalls_total", "Steps that found no ready batch in the prefetch queue.").With(),
+		samples:      reg.Counter("forge_samples_total", "Samples delivered by the sampler.", "root"),
+		shardOpen:    reg.Histogram("forge_shard_open_seconds", "Latency of opening a shard file.", nil, "root"),
+		errors:       reg.Counter("forge_errors_total", "Data pipeline errors.", "kind"),
+		checksums:    reg.Counter("forge_checksum_failures_total", "Shard reads that did not match their checksum.", "root"),
+		readBytes:    reg.Counter("forge_read_bytes_total", "Bytes read from shard files.", "root"),
+		readSeconds:  reg.Counter("forge_read_seconds_total", "Time spent inside shard file reads.", "root"),
+		compressed:   reg.Counter("forge_compressed_read_bytes_total", "Bytes read from compressed shard files.", "root", "codec"),
+		inflated:     reg.Counter("forge_decompressed_bytes_total", "Bytes of TAR stream produced by decompressing shards.", "root", "codec"),
+		decompress:   reg.Counter("forge_decompress_seconds_total", "Time spent decompressing shards, excluding file reads.", "root", "codec"),
+		shardTTFB:    reg.Histogram("forge_shard_ttfb_seconds", "Time from opening a shard to its first byte.", nil, "root"),
+		epochs:       reg.Counter("forge_epochs_total", "Completed passes over the training shards.").With(),
+		evalLoss:     reg.Gauge("forge_eval_loss", "Mean loss of the most recent validation pass.").With(),
+		evalAccuracy: reg.Gauge("forge_eval_accuracy", "Top-1 accuracy of the most recent validation pass.").With(),
+	}
+	// Export zero-valued error series so dashboards see them before the
+	// first failure.
+	t.errors.With("shard")
+	t.errors.With("decode")
+	t.errors.With("sample")
+	return t
+}
+
+func (t *telemetry) recordStep(batchSize int, dataTime, computeTime time.Duration, loss float64) {
+	t.steps.Inc()
+	t.images.Add(float64(batchSize))
+	if total := dataTime + computeTime; total > 0 {
+		t.imagesPerSec.Set(float64(batchSize) / total.Seconds())
+	}
+	t.loss.Set(loss)
+	t.dataWait.Observe(dataTime.Seconds())
+	t.compute.Observe(computeTime.Seconds())
+}
+
+// recordPipeline records how a batch was built and whether the training
+// loop had to wait for it.
+func (t *telemetry) recordPipeline(ioWait, prep time.Duration, stalled bool) {
+	t.ioWait.Observe(ioWait.Seconds())
+	t.prep.Observe(prep.Seconds())
+	if stalled {
+		t.stalls.Inc()
+	}
+}
+
+func (t *telemetry) ShardOpened(root, _ string, latency time.Duration) {
+	t.shardOpen.With(root).Observe(latency.Seconds())
+}
+
+func (t *telemetry) SampleDelivered(root string) {
+	t.samples.With(root).Inc()
+}
+
+func (t *telemetry) ShardDone(root, path string, stats dataset.ShardStats, err error) {
+	if err != nil {
+		t.errors.With("shard").Inc()
+	}
+	t.readBytes.With(root).Add(float64(stats.Bytes))
+	t.readSeconds.With(root).Add(stats.ReadTime.Seconds())
+	if codec := dataset.Compression(path); codec != "" {
+		t.compressed.With(root, codec).Add(float64(stats.Bytes))
+		t.inflated.With(root, codec).Add(float64(stats.Decompressed))
+		t.decompress.With(root, codec).Add(stats.DecompressTime.Seconds())
+	}
+	t.shardTTFB.With(root).Observe(stats.TTFB.Seconds())
+	t.io.Record(root, path, stats.Bytes, stats.Samples, stats.TTFB, stats.ReadTime)
+}
+
+func (t *telemetry) ShardSkipped(root, path string, err error) {
+	logging.Error("shard_skipped", err, logging.String("root", root), logging.String("shard", path))
+}
+
+func (t *telemetry) SampleSkipped(root, path, key string, err error) {
+	t.errors.With("sample").Inc()
+	logging.Error("sample_skipped", err, logging.String("root", root), logging.String("shard", path), logging.String("key", key))
+}
+
+func (t *telemetry) ChecksumFailed(root, path string, err error) {
+	t.checksums.With(root).Inc()
+	logging.Error("checksum_mismatch", err, logging.String("root", root), logging.String("shard", path))
+}
+
+// logIOWindow prints one line per root for shards completed since the
+// previous call.
+func (t *telemetry) logIOWindow(step int) {
+	window := t.io.TakeWindow()
+	for _, root := range sortedKeys(window) {
+		io := window[root]
+		logging.Info("io", append([]logging.Field{
+			logging.Int("step", step),
+			logging.String("root", root),
+		}, ioFields(io)...)...)
+	}
+}
+
+// logIOReport prints run-wide totals per root followed by the slowest
+// shards.
+func (t *telemetry) logIOReport() {
+	roots := t.io.Roots()
+	for _, root := range sortedKeys(roots) {
+		io := roots[root]
+		logging.Info("io_summary", append([]logging.Field{logging.String("root", root)}, ioFields(io)...)...)
+	}
+	shards := t.io.Shards()
+	if len(shards) > reportSlowestShards {
+		shards = shards[:reportSlowestShards]
+	}
+	for _, sh := range shards {
+		logging.Info("io_shard", append([]logging.Field{
+			logging.String("root", sh.Root),
+			logging.String("shard", sh.Shard),
+		}, ioFields(sh.IOTotals)...)...)
+	}
+}
+
+func ioFields(io metrics.IOTotals) []logging.Field {
+	return []logging.Field{
+		logging.Int64("shards", io.Shards),
+		logging.Int64("samples", io.Samples),
+		logging.Float("read_mb", float64(io.Bytes)/(1<<20), 2),
+		logging.Float("read_ms", io.ReadTime.Seconds()*1000, 1),
+		logging.Float("read_mb_per_sec", io.ReadMBPerSec(), 1),
+		logging.Float("ttfb_ms", io.AvgTTFBMS(), 2),
+		logging.Float("ttfb_max_ms", io.MaxTTFB.Seconds()*1000, 2),
+	}
+}
+
+// reportSlowestShards bounds the per-shard section of the end-of-run
+// report.
+const reportSlowestShards = 10
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,74 @@
+package zstd
+
+import "math/bits"
+
+// forwardBits reads a little-endian bit stream from its first bit onwards,
+// as used by FSE table descriptions.
+type forwardBits struct {
+	data []byte
+	pos  int // in bits
+}
+
+// read returns the next n (<= 32) bits; bits past the end read as zero.
+func (f *forwardBits) read(n int) uint32 {
+	v := uint32(bitsAt(f.data, f.pos, n))
+	f.pos += n
+	return v
+}
+
+// overrun reports whether more bits were read than the data holds.
+func (f *forwardBits) overrun() bool {
+	return f.pos > len(f.data)*8
+}
+
+// bytesUsed returns the number of whole bytes the reader has touched.
+func (f *forwardBits) bytesUsed() int {
+	return (f.pos + 7) / 8
+}
+
+// backwardBits reads a bit stream from its last bit towards its first, as
+// used by Huffman and FSE coded data. The stream ends in a padding one bit
+// that is skipped on construction. Reads past the start of the stream
+// return zero bits and leave pos negative, which callers use to detect the
+// end of interleaved FSE streams and corrupt data.
+type backwardBits struct {
+	data []byte
+	pos  int // bits not yet read
+}
+
+func newBackwardBits(data []byte) (*backwardBits, error) {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return nil, corrupt("bit stream has no end marker")
+	}
+	last := bits.Len8(data[len(data)-1]) - 1
+	return &backwardBits{data: data, pos: (len(data)-1)*8 + last}, nil
+}
+
+// read returns the next n (<= 32) bits, most significant first.
+func (b *backwardBits) read(n int) uint32 {
+	if n == 0 {
+		return 0
+	}
+	b.pos -= n
+	if b.pos >= 0 {
+		return uint32(bitsAt(b.data, b.pos, n))
+	}
+	// Only the bits from the start of the stream exist; the rest read as
+	// zeros below them.
+	avail := n + b.pos
+	if avail <= 0 {
+		return 0
+	}
+	return uint32(bitsAt(b.data, 0, avail)) << uint(-b.pos)
+}
+
+// bitsAt returns n (<= 32) bits of data starting at bit pos, counting bits
+// from the least significant bit of the first byte.
+func bitsAt(data []byte, pos, n int) uint64 {
+	var v uint64
+	start := pos >> 3
+	for i := 0; i < 5 && start+i < len(data); i++ {
+		v |= uint64(data[start+i]) << (8 * i)
+	}
+	return (v >> uint(pos&7)) & (1<<uint(n) - 1)
+}
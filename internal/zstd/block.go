@@ -0,0 +1,355 @@
+package zstd
+
+import "encoding/binary"
+
+// maxBlockSize bounds the decompressed size of one block.
+const maxBlockSize = 128 << 10
+
+// decodeBlock decompresses one compressed block, appending its output to
+// r.window.
+func (r *Reader) decodeBlock(data []byte) error {
+	lits, n, err := r.readLiterals(data)
+	if err != nil {
+		return err
+	}
+	return r.execSequences(data[n:], lits)
+}
+
+// readLiterals decodes the literals section (RFC 8878 3.1.1.3.1) and
+// returns the literals and the size of the section.
+func (r *Reader) readLiterals(data []byte) ([]byte, int, error) {
+	if len(data) == 0 {
+		return nil, 0, corrupt("missing literals section")
+	}
+	kind := data[0] & 3
+	format := (data[0] >> 2) & 3
+	if kind == 0 || kind == 1 {
+		// Raw and RLE literals store only the regenerated size.
+		var size, hdr int
+		switch format {
+		case 0, 2:
+			size, hdr = int(data[0]>>3), 1
+		case 1:
+			if len(data) < 2 {
+				return nil, 0, corrupt("truncated literals header")
+			}
+			size, hdr = int(data[0]>>4)|int(data[1])<<4, 2
+		case 3:
+			if len(data) < 3 {
+				return nil, 0, corrupt("truncated literals header")
+			}
+			size, hdr = int(data[0]>>4)|int(data[1])<<4|int(data[2])<<12, 3
+		}
+		if size > maxBlockSize {
+			return nil, 0, corrupt("literals size %d too large", size)
+		}
+		if kind == 0 {
+			if len(data) < hdr+size {
+				return nil, 0, corrupt("truncated raw literals")
+			}
+			return data[hdr : hdr+size], hdr + size, nil
+		}
+		if len(data) < hdr+1 {
+			return nil, 0, corrupt("truncated RLE literals")
+		}
+		lits := r.literalBuf(size)
+		for i := range lits {
+			lits[i] = data[hdr]
+		}
+		return lits, hdr + 1, nil
+	}
+
+	// Huffman coded literals, with a new table or the previous one.
+	var size, compressed, hdr int
+	streams := 4
+	switch format {
+	case 0, 1:
+		if len(data) < 3 {
+			return nil, 0, corrupt("truncated literals header")
+		}
+		v := uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16
+		size, compressed, hdr = int(v>>4&0x3ff), int(v>>14&0x3ff), 3
+		if format == 0 {
+			streams = 1
+		}
+	case 2:
+		if len(data) < 4 {
+			return nil, 0, corrupt("truncated literals header")
+		}
+		v := binary.LittleEndian.Uint32(data)
+		size, compressed, hdr = int(v>>4&0x3fff), int(v>>18), 4
+	case 3:
+		if len(data) < 5 {
+			return nil, 0, corrupt("truncated literals header")
+		}
+		v := uint64(binary.LittleEndian.Uint32(data)) | uint64(data[4])<<32
+		size, compressed, hdr = int(v>>4&0x3ffff), int(v>>22&0x3ffff), 5
+	}
+	if size > maxBlockSize {
+		return nil, 0, corrupt("literals size %d too large", size)
+	}
+	if len(data) < hdr+compressed {
+		return nil, 0, corrupt("truncated compressed literals")
+	}
+	src := data[hdr : hdr+compressed]
+	if kind == 2 {
+		table, n, err := readHuffmanTable(src)
+		if err != nil {
+			return nil, 0, err
+		}
+		r.huffman = table
+		src = src[n:]
+	} else if r.huffman == nil {
+		return nil, 0, corrupt("literals reuse a missing Huffman table")
+	}
+	lits := r.literalBuf(size)
+	if streams == 1 {
+		if err := r.huffman.decode(src, lits); err != nil {
+			return nil, 0, err
+		}
+		return lits, hdr + compressed, nil
+	}
+	if len(src) < 6 {
+		return nil, 0, corrupt("truncated literals jump table")
+	}
+	sizes := [4]int{
+		int(binary.LittleEndian.Uint16(src)),
+		int(binary.LittleEndian.Uint16(src[2:])),
+		int(binary.LittleEndian.Uint16(src[4:])),
+	}
+	src = src[6:]
+	sizes[3] = len(src) - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] < 0 {
+		return nil, 0, corrupt("literals jump table exceeds the section")
+	}
+	part := (size + 3) / 4
+	if 3*part > size {
+		return nil, 0, corrupt("too few literals for four streams")
+	}
+	for i, n := range sizes {
+		out := lits[i*part:]
+		if i < 3 {
+			out = out[:part]
+		}
+		if err := r.huffman.decode(src[:n], out); err != nil {
+			return nil, 0, err
+		}
+		src = src[n:]
+	}
+	return lits, hdr + compressed, nil
+}
+
+func (r *Reader) literalBuf(size int) []byte {
+	if cap(r.literals) < size {
+		r.literals = make([]byte, size, maxBlockSize)
+	}
+	return r.literals[:size]
+}
+
+// Literal and match length codes above these map to a baseline plus extra
+// bits (RFC 8878 3.1.1.3.2.1.1).
+var (
+	literalLengthBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	literalLengthBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	matchLengthBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	matchLengthBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+const maxOffsetCode = 31
+
+// seqKind identifies one of the three symbol streams of the sequences
+// section, in the order their tables are described.
+type seqKind int
+
+const (
+	seqLiteral seqKind = iota
+	seqOffset
+	seqMatch
+)
+
+var seqTableInfo = [3]struct {
+	predefined *fseTable
+	maxLog     int
+	maxSym     int
+}{
+	seqLiteral: {predefinedLiteralLengths, 9, len(literalLengthBase) - 1},
+	seqOffset:  {predefinedOffsets, 8, maxOffsetCode},
+	seqMatch:   {predefinedMatchLengths, 9, len(matchLengthBase) - 1},
+}
+
+// execSequences decodes the sequences section (RFC 8878 3.1.1.3.2) and
+// appends the block's output, literals interleaved with matches, to
+// r.window.
+func (r *Reader) execSequences(data, lits []byte) error {
+	if len(data) == 0 {
+		return corrupt("missing sequences section")
+	}
+	count, off := int(data[0]), 1
+	switch {
+	case count == 255:
+		if len(data) < 3 {
+			return corrupt("truncated sequences header")
+		}
+		count, off = int(data[1])+int(data[2])<<8+0x7f00, 3
+	case count >= 128:
+		if len(data) < 2 {
+			return corrupt("truncated sequences header")
+		}
+		count, off = (count-128)<<8+int(data[1]), 2
+	}
+	if count == 0 {
+		if off != len(data) {
+			return corrupt("data after empty sequences section")
+		}
+		r.window = append(r.window, lits...)
+		return nil
+	}
+	if off >= len(data) {
+		return corrupt("missing sequence compression modes")
+	}
+	modes := data[off]
+	off++
+	if modes&3 != 0 {
+		return corrupt("reserved sequence compression mode bits set")
+	}
+	for kind := seqLiteral; kind <= seqMatch; kind++ {
+		mode := modes >> (6 - 2*uint(kind)) & 3
+		n, err := r.readSeqTable(kind, mode, data[off:])
+		if err != nil {
+			return err
+		}
+		off += n
+	}
+
+	in, err := newBackwardBits(data[off:])
+	if err != nil {
+		return err
+	}
+	ll, of, ml := r.seqTables[seqLiteral], r.seqTables[seqOffset], r.seqTables[seqMatch]
+	llState := int(in.read(ll.log))
+	ofState := int(in.read(of.log))
+	mlState := int(in.read(ml.log))
+	start := len(r.window)
+	for i := 0; i < count; i++ {
+		llEntry, ofEntry, mlEntry := ll.entries[llState], of.entries[ofState], ml.entries[mlState]
+		if int(llEntry.sym) >= len(literalLengthBase) || int(mlEntry.sym) >= len(matchLengthBase) || ofEntry.sym > maxOffsetCode {
+			return corrupt("sequence code out of range")
+		}
+		offset := 1<<ofEntry.sym + in.read(int(ofEntry.sym))
+		matchLen := int(matchLengthBase[mlEntry.sym] + in.read(int(matchLengthBits[mlEntry.sym])))
+		litLen := int(literalLengthBase[llEntry.sym] + in.read(int(literalLengthBits[llEntry.sym])))
+		if i < count-1 {
+			llState = int(llEntry.base) + int(in.read(int(llEntry.bits)))
+			mlState = int(mlEntry.base) + int(in.read(int(mlEntry.bits)))
+			ofState = int(ofEntry.base) + int(in.read(int(ofEntry.bits)))
+		}
+		if in.pos < 0 {
+			return corrupt("sequence bit stream overrun")
+		}
+
+		if litLen > len(lits) {
+			return corrupt("sequence uses more literals than the block has")
+		}
+		r.window = append(r.window, lits[:litLen]...)
+		lits = lits[litLen:]
+
+		dist := r.offset(offset, litLen)
+		if dist == 0 || dist > len(r.window) {
+			return corrupt("match offset %d out of range", dist)
+		}
+		if len(r.window)-start+matchLen > maxBlockSize {
+			return corrupt("block exceeds %d bytes", maxBlockSize)
+		}
+		from := len(r.window) - dist
+		if dist >= matchLen {
+			r.window = append(r.window, r.window[from:from+matchLen]...)
+		} else {
+			// The match overlaps its own output.
+			for j := 0; j < matchLen; j++ {
+				r.window = append(r.window, r.window[from+j])
+			}
+		}
+	}
+	if in.pos != 0 {
+		return corrupt("sequence bit stream length mismatch")
+	}
+	r.window = append(r.window, lits...)
+	if len(r.window)-start > maxBlockSize {
+		return corrupt("block exceeds %d bytes", maxBlockSize)
+	}
+	return nil
+}
+
+// readSeqTable sets up the decoding table of one symbol stream for the
+// given compression mode and returns the bytes its description used.
+func (r *Reader) readSeqTable(kind seqKind, mode byte, data []byte) (int, error) {
+	info := seqTableInfo[kind]
+	switch mode {
+	case 0:
+		r.seqTables[kind] = info.predefined
+		return 0, nil
+	case 1:
+		if len(data) == 0 {
+			return 0, corrupt("missing RLE sequence symbol")
+		}
+		if int(data[0]) > info.maxSym {
+			return 0, corrupt("RLE sequence symbol %d out of range", data[0])
+		}
+		r.seqTables[kind] = rleFSETable(data[0])
+		return 1, nil
+	case 2:
+		table, n, err := readFSETable(data, info.maxLog, info.maxSym)
+		if err != nil {
+			return 0, err
+		}
+		r.seqTables[kind] = table
+		return n, nil
+	default:
+		if r.seqTables[kind] == nil {
+			return 0, corrupt("sequences repeat a missing table")
+		}
+		return 0, nil
+	}
+}
+
+// offset turns an offset value into a match distance, maintaining the
+// repeated offsets (RFC 8878 3.1.2.5).
+func (r *Reader) offset(value uint32, litLen int) int {
+	if value > 3 {
+		r.repeat[2], r.repeat[1], r.repeat[0] = r.repeat[1], r.repeat[0], value-3
+		return int(value - 3)
+	}
+	idx := value
+	if litLen == 0 {
+		idx++
+	}
+	switch idx {
+	case 1:
+		return int(r.repeat[0])
+	case 2:
+		r.repeat[1], r.repeat[0] = r.repeat[0], r.repeat[1]
+	case 3:
+		r.repeat[2], r.repeat[1], r.repeat[0] = r.repeat[1], r.repeat[0], r.repeat[2]
+	default:
+		r.repeat[2], r.repeat[1], r.repeat[0] = r.repeat[1], r.repeat[0], r.repeat[0]-1
+	}
+	return int(r.repeat[0])
+}
@@ -0,0 +1,154 @@
+package zstd
+
+import "math/bits"
+
+// fseEntry is one state of an FSE decoding table: the symbol it decodes
+// and how to find the next state, base plus the next bits of the stream.
+type fseEntry struct {
+	sym  uint8
+	bits uint8
+	base uint16
+}
+
+// fseTable decodes one FSE coded symbol stream.
+type fseTable struct {
+	log     int
+	entries []fseEntry
+}
+
+// readFSETable parses an FSE table description (RFC 8878 4.1.1) of at most
+// maxLog accuracy and symbols up to maxSym. It returns the table and the
+// number of bytes the description used.
+func readFSETable(data []byte, maxLog, maxSym int) (*fseTable, int, error) {
+	in := &forwardBits{data: data}
+	log := int(in.read(4)) + 5
+	if log > maxLog {
+		return nil, 0, corrupt("FSE accuracy log %d exceeds %d", log, maxLog)
+	}
+	var norm [256]int16
+	remaining := 1 << log
+	sym := 0
+	for remaining > 0 && sym <= maxSym {
+		n := bits.Len(uint(remaining + 1))
+		val := in.read(n)
+		lower := uint32(1)<<(n-1) - 1
+		threshold := uint32(1)<<n - 1 - uint32(remaining+1)
+		if val&lower < threshold {
+			// Small values are stored in one bit less.
+			in.pos--
+			val &= lower
+		} else if val > lower {
+			val -= threshold
+		}
+		prob := int16(val) - 1
+		if prob < 0 {
+			remaining--
+		} else {
+			remaining -= int(prob)
+		}
+		norm[sym] = prob
+		sym++
+		if prob == 0 {
+			// A zero probability is followed by 2-bit counts of further
+			// zero probability symbols; 3 means another count follows.
+			for {
+				repeat := int(in.read(2))
+				sym += repeat
+				if repeat != 3 || sym > maxSym {
+					break
+				}
+			}
+		}
+		if sym > maxSym+1 {
+			return nil, 0, corrupt("FSE table description has too many symbols")
+		}
+		if in.overrun() {
+			return nil, 0, corrupt("truncated FSE table description")
+		}
+	}
+	if remaining != 0 {
+		return nil, 0, corrupt("invalid FSE table description")
+	}
+	t, err := buildFSETable(norm[:sym], log)
+	if err != nil {
+		return nil, 0, err
+	}
+	return t, in.bytesUsed(), nil
+}
+
+// buildFSETable spreads the normalized symbol probabilities norm over a
+// table of 1<<log states. A probability of -1 marks a "less than one"
+// symbol that gets a single state at the end of the table.
+func buildFSETable(norm []int16, log int) (*fseTable, error) {
+	size := 1 << log
+	t := &fseTable{log: log, entries: make([]fseEntry, size)}
+	next := make([]uint16, len(norm))
+	high := size - 1
+	for sym, prob := range norm {
+		if prob == -1 {
+			t.entries[high].sym = uint8(sym)
+			high--
+			next[sym] = 1
+		}
+	}
+	step := size>>1 + size>>3 + 3
+	mask := size - 1
+	pos := 0
+	for sym, prob := range norm {
+		if prob <= 0 {
+			continue
+		}
+		next[sym] = uint16(prob)
+		for i := 0; i < int(prob); i++ {
+			t.entries[pos].sym = uint8(sym)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, corrupt("FSE probabilities do not fill the table")
+	}
+	for i := range t.entries {
+		e := &t.entries[i]
+		state := next[e.sym]
+		next[e.sym]++
+		e.bits = uint8(log - (bits.Len16(state) - 1))
+		e.base = uint16(int(state)<<e.bits - size)
+	}
+	return t, nil
+}
+
+// rleFSETable returns a table that always decodes sym and reads no bits.
+func rleFSETable(sym uint8) *fseTable {
+	return &fseTable{entries: []fseEntry{{sym: sym}}}
+}
+
+// mustFSETable builds one of the predefined tables.
+func mustFSETable(norm []int16, log int) *fseTable {
+	t, err := buildFSETable(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// The predefined distributions of RFC 8878 3.1.1.3.2.2.
+var (
+	predefinedLiteralLengths = mustFSETable([]int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}, 6)
+	predefinedMatchLengths = mustFSETable([]int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}, 6)
+	predefinedOffsets = mustFSETable([]int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}, 5)
+)
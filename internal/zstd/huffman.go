@@ -0,0 +1,166 @@
+package zstd
+
+import "math/bits"
+
+const maxHuffmanBits = 11
+
+// huffEntry is one slot of a Huffman decoding table, indexed by the next
+// maxBits bits of the stream.
+type huffEntry struct {
+	sym  uint8
+	bits uint8
+}
+
+type huffTable struct {
+	maxBits int
+	entries []huffEntry
+}
+
+// readHuffmanTable parses a Huffman tree description (RFC 8878 4.2.1) and
+// returns the table and the number of bytes the description used.
+func readHuffmanTable(data []byte) (*huffTable, int, error) {
+	if len(data) == 0 {
+		return nil, 0, corrupt("missing Huffman tree description")
+	}
+	var weights [256]uint8
+	var count, used int
+	header := int(data[0])
+	if header >= 128 {
+		// Weights are stored directly, four bits each.
+		count = header - 127
+		used = 1 + (count+1)/2
+		if len(data) < used {
+			return nil, 0, corrupt("truncated Huffman weights")
+		}
+		for i := 0; i < count; i++ {
+			b := data[1+i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 0xf
+			}
+		}
+	} else {
+		used = 1 + header
+		if len(data) < used {
+			return nil, 0, corrupt("truncated Huffman weights")
+		}
+		var err error
+		if count, err = readHuffmanWeights(data[1:used], weights[:255]); err != nil {
+			return nil, 0, err
+		}
+	}
+	t, err := buildHuffmanTable(weights[:count])
+	if err != nil {
+		return nil, 0, err
+	}
+	return t, used, nil
+}
+
+// readHuffmanWeights decodes FSE compressed weights into weights and
+// returns how many there were. The data holds an FSE table followed by two
+// interleaved streams that share one bit stream.
+func readHuffmanWeights(data, weights []uint8) (int, error) {
+	table, n, err := readFSETable(data, 6, 255)
+	if err != nil {
+		return 0, err
+	}
+	in, err := newBackwardBits(data[n:])
+	if err != nil {
+		return 0, err
+	}
+	mask := len(table.entries) - 1
+	states := [2]int{int(in.read(table.log)) & mask, int(in.read(table.log)) & mask}
+	count := 0
+	for s := 0; ; s ^= 1 {
+		if count+2 > len(weights) {
+			return 0, corrupt("too many Huffman weights")
+		}
+		e := table.entries[states[s]]
+		weights[count] = e.sym
+		count++
+		states[s] = int(e.base) + int(in.read(int(e.bits)))
+		if in.pos < 0 {
+			// The stream is exhausted; the other state holds the last
+			// weight.
+			weights[count] = table.entries[states[s^1]].sym
+			return count + 1, nil
+		}
+	}
+}
+
+// buildHuffmanTable builds the decoding table from the weights of every
+// symbol but the last, whose weight is implied by the others.
+func buildHuffmanTable(weights []uint8) (*huffTable, error) {
+	var total uint32
+	for _, w := range weights {
+		if w > maxHuffmanBits {
+			return nil, corrupt("Huffman weight %d too large", w)
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, corrupt("Huffman weights are all zero")
+	}
+	maxBits := bits.Len32(total)
+	if maxBits > maxHuffmanBits {
+		return nil, corrupt("Huffman codes longer than %d bits", maxHuffmanBits)
+	}
+	rest := uint32(1)<<maxBits - total
+	if rest&(rest-1) != 0 {
+		return nil, corrupt("Huffman weights do not form a prefix code")
+	}
+	all := make([]uint8, len(weights)+1)
+	copy(all, weights)
+	all[len(weights)] = uint8(bits.Len32(rest))
+
+	// Codes are assigned from the longest to the shortest, each length
+	// taking a contiguous run of table slots in symbol order.
+	var rankCount [maxHuffmanBits + 2]int
+	for _, w := range all {
+		if w > 0 {
+			rankCount[maxBits+1-int(w)]++
+		}
+	}
+	var rankStart [maxHuffmanBits + 2]int
+	pos := 0
+	for n := maxBits; n >= 1; n-- {
+		rankStart[n] = pos
+		pos += rankCount[n] << (maxBits - n)
+	}
+	t := &huffTable{maxBits: maxBits, entries: make([]huffEntry, 1<<maxBits)}
+	for sym, w := range all {
+		if w == 0 {
+			continue
+		}
+		n := maxBits + 1 - int(w)
+		span := 1 << (maxBits - n)
+		for i := rankStart[n]; i < rankStart[n]+span; i++ {
+			t.entries[i] = huffEntry{sym: uint8(sym), bits: uint8(n)}
+		}
+		rankStart[n] += span
+	}
+	return t, nil
+}
+
+// decode decodes len(out) symbols from one Huffman coded stream, which
+// must be consumed exactly.
+func (t *huffTable) decode(data, out []byte) error {
+	in, err := newBackwardBits(data)
+	if err != nil {
+		return err
+	}
+	mask := uint32(1)<<t.maxBits - 1
+	state := in.read(t.maxBits)
+	for i := range out {
+		e := t.entries[state]
+		out[i] = e.sym
+		state = (state<<e.bits | in.read(int(e.bits))) & mask
+	}
+	if in.pos != -t.maxBits {
+		return corrupt("Huffman stream length mismatch")
+	}
+	return nil
+}
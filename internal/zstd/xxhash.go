@@ -0,0 +1,97 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	prime64_1 = 11400714785074694791
+	prime64_2 = 14029467366897019727
+	prime64_3 = 1609587929392839161
+	prime64_4 = 9650029242287828579
+	prime64_5 = 2870177450012600261
+)
+
+// xxhash64 is a streaming XXH64 with a zero seed, the content checksum of
+// Zstandard frames.
+type xxhash64 struct {
+	v     [4]uint64
+	total uint64
+	buf   [32]byte
+	nbuf  int
+}
+
+func (h *xxhash64) Reset() {
+	p1, p2 := uint64(prime64_1), uint64(prime64_2)
+	h.v = [4]uint64{p1 + p2, p2, 0, -p1}
+	h.total = 0
+	h.nbuf = 0
+}
+
+func (h *xxhash64) Write(p []byte) {
+	h.total += uint64(len(p))
+	if h.nbuf > 0 {
+		n := copy(h.buf[h.nbuf:], p)
+		h.nbuf += n
+		p = p[n:]
+		if h.nbuf < len(h.buf) {
+			return
+		}
+		h.stripe(h.buf[:])
+		h.nbuf = 0
+	}
+	for len(p) >= len(h.buf) {
+		h.stripe(p[:32])
+		p = p[32:]
+	}
+	h.nbuf = copy(h.buf[:], p)
+}
+
+func (h *xxhash64) stripe(p []byte) {
+	for i := range h.v {
+		h.v[i] = xxRound(h.v[i], binary.LittleEndian.Uint64(p[8*i:]))
+	}
+}
+
+func (h *xxhash64) Sum64() uint64 {
+	var sum uint64
+	if h.total >= 32 {
+		v := h.v
+		sum = bits.RotateLeft64(v[0], 1) + bits.RotateLeft64(v[1], 7) + bits.RotateLeft64(v[2], 12) + bits.RotateLeft64(v[3], 18)
+		for _, lane := range v {
+			sum ^= xxRound(0, lane)
+			sum = sum*prime64_1 + prime64_4
+		}
+	} else {
+		sum = prime64_5
+	}
+	sum += h.total
+
+	p := h.buf[:h.nbuf]
+	for ; len(p) >= 8; p = p[8:] {
+		sum ^= xxRound(0, binary.LittleEndian.Uint64(p))
+		sum = bits.RotateLeft64(sum, 27)*prime64_1 + prime64_4
+	}
+	if len(p) >= 4 {
+		sum ^= uint64(binary.LittleEndian.Uint32(p)) * prime64_1
+		sum = bits.RotateLeft64(sum, 23)*prime64_2 + prime64_3
+		p = p[4:]
+	}
+	for _, b := range p {
+		sum ^= uint64(b) * prime64_5
+		sum = bits.RotateLeft64(sum, 11) * prime64_1
+	}
+
+	sum ^= sum >> 33
+	sum *= prime64_2
+	sum ^= sum >> 29
+	sum *= prime64_3
+	sum ^= sum >> 32
+	return sum
+}
+
+func xxRound(acc, lane uint64) uint64 {
+	acc += lane * prime64_2
+	return bits.RotateLeft64(acc, 31) * prime64_1
+}
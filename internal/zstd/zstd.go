@@ -0,0 +1,255 @@
+// Package zstd implements a Zstandard (RFC 8878) decompressor. It decodes
+// every frame a reference encoder produces except those that need a
+// dictionary, and checks content checksums when frames carry them.
+package zstd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+const (
+	frameMagic        = 0xfd2fb528
+	skippableMagic    = 0x184d2a50
+	skippableMask     = 0xfffffff0
+	maxWindowSize     = 1 << 30
+	checksumSize      = 4
+	blockHeaderSize   = 3
+	blockTypeRaw      = 0
+	blockTypeRLE      = 1
+	blockTypeCompress = 2
+)
+
+// ErrCorrupt is wrapped by the errors returned for malformed input.
+var ErrCorrupt = errors.New("zstd: corrupt input")
+
+func corrupt(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrCorrupt}, args...)...)
+}
+
+// Reader decompresses a stream of concatenated Zstandard frames.
+type Reader struct {
+	in  *bufio.Reader
+	err error
+	// frames counts the frames started, so an empty stream is an error.
+	frames int
+
+	// Frame state.
+	inFrame    bool
+	windowSize int
+	checksum   bool
+	digest     xxhash64
+	// window holds the frame's output: the history matches may refer to,
+	// followed by the undelivered output of the current block, out.
+	window []byte
+	out    []byte
+	block  []byte
+
+	// State carried from block to block within a frame.
+	repeat    [3]uint32
+	huffman   *huffTable
+	seqTables [3]*fseTable
+	literals  []byte
+}
+
+// NewReader returns a Reader that decompresses r. Errors in the stream
+// are reported by Read.
+func NewReader(r io.Reader) *Reader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Reader{in: br}
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// next decodes the next block, or the next frame header between frames.
+func (r *Reader) next() error {
+	if !r.inFrame {
+		return r.readFrameHeader()
+	}
+	// Keep at least a window of history, trimming it only once it has
+	// doubled so the copy is amortized.
+	if len(r.window) > 2*r.windowSize+maxBlockSize {
+		keep := copy(r.window, r.window[len(r.window)-r.windowSize:])
+		r.window = r.window[:keep]
+	}
+
+	var hdr [blockHeaderSize]byte
+	if _, err := io.ReadFull(r.in, hdr[:]); err != nil {
+		return unexpected(err)
+	}
+	v := uint32(hdr[0]) | uint32(hdr[1])<<8 | uint32(hdr[2])<<16
+	last := v&1 == 1
+	size := int(v >> 3)
+	start := len(r.window)
+	switch (v >> 1) & 3 {
+	case blockTypeRaw:
+		if size > maxBlockSize {
+			return corrupt("block of %d bytes", size)
+		}
+		r.window = slices.Grow(r.window, size)[:start+size]
+		if _, err := io.ReadFull(r.in, r.window[start:]); err != nil {
+			return unexpected(err)
+		}
+	case blockTypeRLE:
+		if size > maxBlockSize {
+			return corrupt("block of %d bytes", size)
+		}
+		b, err := r.in.ReadByte()
+		if err != nil {
+			return unexpected(err)
+		}
+		for i := 0; i < size; i++ {
+			r.window = append(r.window, b)
+		}
+	case blockTypeCompress:
+		if size > maxBlockSize {
+			return corrupt("compressed block of %d bytes", size)
+		}
+		if cap(r.block) < size {
+			r.block = make([]byte, size)
+		}
+		r.block = r.block[:size]
+		if _, err := io.ReadFull(r.in, r.block); err != nil {
+			return unexpected(err)
+		}
+		if err := r.decodeBlock(r.block); err != nil {
+			return err
+		}
+	default:
+		return corrupt("reserved block type")
+	}
+	r.out = r.window[start:]
+	if r.checksum {
+		r.digest.Write(r.out)
+	}
+	if last {
+		r.inFrame = false
+		if r.checksum {
+			var sum [checksumSize]byte
+			if _, err := io.ReadFull(r.in, sum[:]); err != nil {
+				return unexpected(err)
+			}
+			if binary.LittleEndian.Uint32(sum[:]) != uint32(r.digest.Sum64()) {
+				return corrupt("content checksum mismatch")
+			}
+		}
+	}
+	return nil
+}
+
+// readFrameHeader starts the next frame, skipping skippable frames. It
+// returns io.EOF at the end of the stream.
+func (r *Reader) readFrameHeader() error {
+	for {
+		var magic [4]byte
+		n, err := io.ReadFull(r.in, magic[:])
+		if err == io.EOF && r.frames > 0 {
+			return io.EOF
+		}
+		if err != nil {
+			if n == 0 && r.frames == 0 {
+				return fmt.Errorf("zstd: empty stream: %w", io.ErrUnexpectedEOF)
+			}
+			return unexpected(err)
+		}
+		r.frames++
+		m := binary.LittleEndian.Uint32(magic[:])
+		if m&skippableMask == skippableMagic {
+			var size [4]byte
+			if _, err := io.ReadFull(r.in, size[:]); err != nil {
+				return unexpected(err)
+			}
+			if _, err := io.CopyN(io.Discard, r.in, int64(binary.LittleEndian.Uint32(size[:]))); err != nil {
+				return unexpected(err)
+			}
+			continue
+		}
+		if m != frameMagic {
+			return corrupt("bad magic number %#x", m)
+		}
+		break
+	}
+
+	desc, err := r.in.ReadByte()
+	if err != nil {
+		return unexpected(err)
+	}
+	if desc&0x08 != 0 {
+		return corrupt("reserved frame header bit set")
+	}
+	singleSegment := desc&0x20 != 0
+	r.checksum = desc&0x04 != 0
+	if !singleSegment {
+		b, err := r.in.ReadByte()
+		if err != nil {
+			return unexpected(err)
+		}
+		base := uint64(1) << (10 + b>>3)
+		r.windowSize = int(min(base+base/8*uint64(b&7), maxWindowSize+1))
+	}
+	dictSize := [4]int{0, 1, 2, 4}[desc&3]
+	contentSize := [4]int{0, 2, 4, 8}[desc>>6]
+	if contentSize == 0 && singleSegment {
+		contentSize = 1
+	}
+	var buf [12]byte
+	field := buf[:dictSize+contentSize]
+	if _, err := io.ReadFull(r.in, field); err != nil {
+		return unexpected(err)
+	}
+	var dict uint64
+	for i := dictSize - 1; i >= 0; i-- {
+		dict = dict<<8 | uint64(field[i])
+	}
+	if dict != 0 {
+		return fmt.Errorf("zstd: frame needs dictionary %d, which is not supported", dict)
+	}
+	if singleSegment {
+		var fcs uint64
+		for i := contentSize - 1; i >= 0; i-- {
+			fcs = fcs<<8 | uint64(field[dictSize+i])
+		}
+		if contentSize == 2 {
+			fcs += 256
+		}
+		r.windowSize = int(min(fcs, maxWindowSize+1))
+	}
+	if r.windowSize > maxWindowSize {
+		return fmt.Errorf("zstd: window of more than %d bytes is not supported", maxWindowSize)
+	}
+
+	r.inFrame = true
+	r.window = r.window[:0]
+	r.repeat = [3]uint32{1, 4, 8}
+	r.huffman = nil
+	r.seqTables = [3]*fseTable{}
+	r.digest.Reset()
+	return nil
+}
+
+// unexpected converts the end of the input inside a frame into
+// io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
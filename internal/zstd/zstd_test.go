@@ -0,0 +1,106 @@
+package zstd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// The fixtures compress fixtureText with the reference encoder: at level
+// 19 with a content checksum, and at level 1 without one.
+const (
+	fixtureLevel19 = "28b52ffd64720f050900f6112913901b72282ef39cff1f419032e5a644ab1d569c2b001d002300336ed33eaf9b1596b042f4dff6db3253729b000a2810868222a00042c158141c8146e13190e0d028241c01f5985ed5d76da7ecaabe9ece0c67a3f9783a33e4289e66392447f1f3ba26943004124610e104134a1842322272625232846444fffd7eceeffaefd7637a966f3bd33eaf9b719bf659ab361ab569d2ae59ab361acb8aca8b4bcb14961595130280f9a811c0b7b1bf01c0332ad71124040c4182e08d783ec32516bbc5d462b5585ad61a5caa9d4dab20345c9e9d35bba0325c929d1dbb20316e5cb8f1e2868b332edc5871c3c519176eacb8e1e28c0b3756dc7071c685db8459b0c957c6cb762d5c195b566bc9ca60a9b0bbbbb3b7bb73b747ca462941055835e1149e8b"
+	fixtureLevel1  = "28b52ffd60720f25090076122b1690192407580a7ba1c6dd15801065ca4d49b472bd23082c001e0023005e37e336ed73372b2c5919fa6ffb6d9929b90d40435130401483868202e18050181288050105102410060a01f26dd7637a555fb79db2abfa7a3a339c8de6e3e9cc90a3789ae5901cc52f2c2b2a272625434846444e4c4a66706c687c7874cce0d8d07fbf9ff3bbfefbf5989e03001b8a8201a218cab4cfeb66dca67dd6aa8d466d9ab46bd6aa8dc6b2a2f2e2d2322580f8a821ec6dec6fd01bd5011124040c818247e2f9cb165fea7861c771acfd567bb03499a2fd3efb303389963d4dd6b4b1998a0de1175187d0836843e841f420f2107a1075083d8836841e440f220fa10751afb0026ce2cb7b9d5d272e6eeba0259359e7b1eee1f3f1f1f1e5f3f1635ca40415a0d4"
+)
+
+func fixtureText() []byte {
+	b := &bytes.Buffer{}
+	for i := 0; i < 120; i++ {
+		fmt.Fprintf(b, "shard-%06d.tar sample %d label %d\n", i%7, i, i*i%10)
+	}
+	return b.Bytes()
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("decode fixture: %v", err)
+	}
+	return b
+}
+
+func decompress(data []byte) ([]byte, error) {
+	return io.ReadAll(NewReader(bytes.NewReader(data)))
+}
+
+func TestReaderDecodesReferenceFrames(t *testing.T) {
+	want := fixtureText()
+	level19, level1 := mustHex(t, fixtureLevel19), mustHex(t, fixtureLevel1)
+	for name, data := range map[string][]byte{"level19": level19, "level1": level1} {
+		got, err := decompress(data)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("%s: got %d bytes, %v; want %d bytes", name, len(got), err, len(want))
+		}
+	}
+
+	// Frames are concatenated, with skippable frames ignored.
+	skippable := []byte{0x5a, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 'x', 'y', 'z'}
+	stream := append(append(append([]byte{}, level19...), skippable...), level1...)
+	got, err := decompress(stream)
+	if err != nil || !bytes.Equal(got, append(append([]byte{}, want...), want...)) {
+		t.Fatalf("concatenated frames: got %d bytes, %v", len(got), err)
+	}
+}
+
+func TestReaderRawAndRLEBlocks(t *testing.T) {
+	frame := []byte{
+		0x28, 0xb5, 0x2f, 0xfd, // magic
+		0x20, 8, // single segment, 8 bytes of content
+		0x18, 0, 0, 'a', 'b', 'c', // raw block of 3 bytes
+		0x2b, 0, 0, 'x', // last block, 5 bytes of 'x'
+	}
+	got, err := decompress(frame)
+	if err != nil || string(got) != "abcxxxxx" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestReaderRejectsCorruptInput(t *testing.T) {
+	data := mustHex(t, fixtureLevel19)
+	data[len(data)-1] ^= 1
+	if _, err := decompress(data); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected a checksum error, got %v", err)
+	}
+	data = mustHex(t, fixtureLevel19)
+	if _, err := decompress(data[:len(data)/2]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected a truncation error, got %v", err)
+	}
+	if _, err := decompress([]byte("not zstd")); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected a magic number error, got %v", err)
+	}
+	if _, err := decompress(nil); err == nil {
+		t.Fatal("expected an error for an empty stream")
+	}
+}
+
+func TestXXHash64(t *testing.T) {
+	var h xxhash64
+	h.Reset()
+	if got := h.Sum64(); got != 0xef46db3751d8e999 {
+		t.Fatalf("empty digest = %#x", got)
+	}
+	data := fixtureText()
+	h.Write(data)
+	whole := h.Sum64()
+	h.Reset()
+	for i := 0; i < len(data); i += 13 {
+		h.Write(data[i:min(i+13, len(data))])
+	}
+	if h.Sum64() != whole {
+		t.Fatal("chunked writes changed the digest")
+	}
+}